@@ -0,0 +1,383 @@
+// Package agent implements an embeddable HTTP handler that exposes
+// pprof-compatible endpoints for .NET processes:
+//
+//	/debug/pprof/profile?pid=X&seconds=30   wall clock profile in pprof format
+//	/debug/dotnet/processes                 IDs of .NET processes
+//	/debug/dotnet/trace?pid=X&seconds=30    raw .nettrace capture
+//	/debug/dotnet/counters?pid=X&seconds=5  EventCounters snapshot
+//
+// The trace endpoint accepts providers (dotnet-trace format) and preset
+// parameters, by default the CPU sampling preset is used. The counters
+// endpoint accepts providers parameter: a comma-separated list of
+// EventSource names.
+//
+// The number of sessions served concurrently is limited, requests exceeding
+// the limit are rejected with 429 (Too Many Requests) status code.
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pyroscope-io/dotnetdiag"
+	"github.com/pyroscope-io/dotnetdiag/counters"
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+	"github.com/pyroscope-io/dotnetdiag/nettrace/profiler"
+)
+
+// Handler serves diagnostic endpoints.
+type Handler struct {
+	mux           *http.ServeMux
+	clientOptions []dotnetdiag.Option
+	maxDuration   time.Duration
+	address       func(pid int) string
+	// Semaphore of the active sessions.
+	sessions chan struct{}
+}
+
+// Option overrides default Handler parameters.
+type Option func(*Handler)
+
+// WithClientOptions specifies options for the diagnostic clients.
+func WithClientOptions(options ...dotnetdiag.Option) Option {
+	return func(h *Handler) {
+		h.clientOptions = options
+	}
+}
+
+// WithMaxDuration limits the duration of a session that can be requested.
+// By default, the limit is 5 minutes.
+func WithMaxDuration(d time.Duration) Option {
+	return func(h *Handler) {
+		h.maxDuration = d
+	}
+}
+
+// WithMaxSessions limits the number of sessions that can be active at the
+// same time. By default, the limit is 4.
+func WithMaxSessions(n int) Option {
+	return func(h *Handler) {
+		h.sessions = make(chan struct{}, n)
+	}
+}
+
+const (
+	defaultMaxSessions      = 4
+	defaultMaxDuration      = 5 * time.Minute
+	defaultProfileDuration  = 30 * time.Second
+	defaultCountersDuration = 5 * time.Second
+)
+
+// NewHandler creates a new Handler.
+func NewHandler(options ...Option) *Handler {
+	h := Handler{
+		mux:         http.NewServeMux(),
+		maxDuration: defaultMaxDuration,
+		address:     dotnetdiag.DefaultServerAddress,
+		sessions:    make(chan struct{}, defaultMaxSessions),
+	}
+	for _, option := range options {
+		option(&h)
+	}
+	h.mux.HandleFunc("/debug/pprof/profile", h.profile)
+	h.mux.HandleFunc("/debug/dotnet/processes", h.processes)
+	h.mux.HandleFunc("/debug/dotnet/trace", h.trace)
+	h.mux.HandleFunc("/debug/dotnet/counters", h.counters)
+	return &h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+var (
+	errBadRequest      = errors.New("bad request")
+	errTooManySessions = errors.New("too many sessions")
+)
+
+// acquire reserves a session slot, the returned function releases it.
+func (h *Handler) acquire() (func(), error) {
+	select {
+	case h.sessions <- struct{}{}:
+		return func() { <-h.sessions }, nil
+	default:
+		return nil, fmt.Errorf("%w: %d sessions are active", errTooManySessions, cap(h.sessions))
+	}
+}
+
+func (h *Handler) client(r *http.Request) (*dotnetdiag.Client, error) {
+	pid, err := strconv.Atoi(r.URL.Query().Get("pid"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid pid", errBadRequest)
+	}
+	addr := h.address(pid)
+	if addr == "" {
+		return nil, fmt.Errorf("%w: diagnostic server of process %d not found", errBadRequest, pid)
+	}
+	return dotnetdiag.NewClient(addr, h.clientOptions...), nil
+}
+
+func (h *Handler) duration(r *http.Request, d time.Duration) (time.Duration, error) {
+	if s := r.URL.Query().Get("seconds"); s != "" {
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("%w: invalid seconds", errBadRequest)
+		}
+		d = time.Duration(n * float64(time.Second))
+	}
+	if d > h.maxDuration {
+		return 0, fmt.Errorf("%w: duration exceeds %v", errBadRequest, h.maxDuration)
+	}
+	return d, nil
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errBadRequest):
+		code = http.StatusBadRequest
+	case errors.Is(err, errTooManySessions):
+		code = http.StatusTooManyRequests
+	}
+	http.Error(w, err.Error(), code)
+}
+
+// startSession creates a session that is closed after d, or when
+// the request is cancelled.
+func startSession(r *http.Request, c *dotnetdiag.Client, providers []dotnetdiag.ProviderConfig, d time.Duration) (*dotnetdiag.Session, func(), error) {
+	s, err := c.CollectTracing(dotnetdiag.CollectTracingConfig{
+		CircularBufferSizeMB: 256,
+		Providers:            providers,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	t := time.NewTimer(d)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-t.C:
+		case <-r.Context().Done():
+		case <-done:
+			t.Stop()
+		}
+		_ = s.Close()
+	}()
+	return s, func() { close(done) }, nil
+}
+
+func (h *Handler) profile(w http.ResponseWriter, r *http.Request) {
+	c, err := h.client(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	d, err := h.duration(r, defaultProfileDuration)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var options []profiler.Option
+	if r.URL.Query().Get("managed") == "1" {
+		options = append(options, profiler.WithManagedCodeOnly())
+	}
+	release, err := h.acquire()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer release()
+	start := time.Now()
+	s, stop, err := startSession(r, c, []dotnetdiag.ProviderConfig{dotnetdiag.CPUSamplingProviders()[0]}, d)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer stop()
+	samples, err := sampleProfile(s, options...)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="profile"`)
+	_ = profiler.WritePprof(w, samples, start, time.Since(start))
+}
+
+func sampleProfile(r io.Reader, options ...profiler.Option) (map[string]time.Duration, error) {
+	stream := nettrace.NewStream(r)
+	trace, err := stream.Open()
+	if err != nil {
+		return nil, err
+	}
+	p := profiler.NewSampleProfiler(trace, options...)
+	stream.EventHandler = p.EventHandler
+	stream.MetadataHandler = p.MetadataHandler
+	stream.StackBlockHandler = p.StackBlockHandler
+	stream.SequencePointBlockHandler = p.SequencePointBlockHandler
+	for {
+		err = stream.Next()
+		switch {
+		case err == nil:
+		case errors.Is(err, io.EOF):
+			return p.Samples(), nil
+		default:
+			return nil, err
+		}
+	}
+}
+
+func (h *Handler) processes(w http.ResponseWriter, _ *http.Request) {
+	pids, err := dotnetdiag.Processes()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	type process struct {
+		PID int `json:"pid"`
+	}
+	resp := make([]process, len(pids))
+	for i, pid := range pids {
+		resp[i].PID = pid
+	}
+	writeJSON(w, resp)
+}
+
+func (h *Handler) trace(w http.ResponseWriter, r *http.Request) {
+	c, err := h.client(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	d, err := h.duration(r, defaultProfileDuration)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	providers, err := traceProviders(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	release, err := h.acquire()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer release()
+	s, stop, err := startSession(r, c, providers, d)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer stop()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="trace.nettrace"`)
+	_, _ = io.Copy(w, s)
+}
+
+func traceProviders(r *http.Request) ([]dotnetdiag.ProviderConfig, error) {
+	q := r.URL.Query()
+	var providers []dotnetdiag.ProviderConfig
+	if preset := q.Get("preset"); preset != "" {
+		p, err := dotnetdiag.Preset(preset)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errBadRequest, err)
+		}
+		providers = append(providers, p...)
+	}
+	if s := q.Get("providers"); s != "" {
+		p, err := dotnetdiag.ParseProviders(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errBadRequest, err)
+		}
+		providers = append(providers, p...)
+	}
+	if len(providers) == 0 {
+		providers = dotnetdiag.CPUSamplingProviders()
+	}
+	return providers, nil
+}
+
+func (h *Handler) counters(w http.ResponseWriter, r *http.Request) {
+	c, err := h.client(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	d, err := h.duration(r, defaultCountersDuration)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var config counters.Config
+	if s := r.URL.Query().Get("providers"); s != "" {
+		config.Providers = strings.Split(s, ",")
+	}
+	release, err := h.acquire()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer release()
+	result, err := collectCounters(r, c, config, d)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, result)
+}
+
+// collectCounters returns the latest value of every counter published
+// within the given duration.
+func collectCounters(r *http.Request, c *dotnetdiag.Client, config counters.Config, d time.Duration) ([]counters.Counter, error) {
+	reader, err := counters.NewReader(c, config)
+	if err != nil {
+		return nil, err
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	go func() {
+		select {
+		case <-t.C:
+		case <-r.Context().Done():
+		}
+		_ = reader.Close()
+	}()
+	latest := make(map[string]counters.Counter)
+	for {
+		x, err := reader.Next()
+		switch {
+		case err == nil:
+			latest[x.Provider+"/"+x.Name] = x
+			continue
+		case errors.Is(err, io.EOF):
+		default:
+			return nil, err
+		}
+		break
+	}
+	result := make([]counters.Counter, 0, len(latest))
+	for _, x := range latest {
+		result = append(result, x)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Provider != result[j].Provider {
+			return result[i].Provider < result[j].Provider
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
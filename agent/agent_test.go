@@ -0,0 +1,195 @@
+package agent
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+
+	"github.com/pyroscope-io/dotnetdiag"
+)
+
+const sample = "../nettrace/testdata/dotnet-5.0-SampleProfiler-single-thread.golden.nettrace"
+
+// fakeServer implements the diagnostic server side of EventPipe commands:
+// every session streams the trace given, and ends when it is stopped.
+type fakeServer struct {
+	trace   []byte
+	started chan uint64
+
+	m        sync.Mutex
+	sessions map[uint64]net.Conn
+	lastID   uint64
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	b, err := os.ReadFile(sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fakeServer{
+		trace:    b,
+		started:  make(chan uint64, 10),
+		sessions: make(map[uint64]net.Conn),
+	}
+}
+
+func (f *fakeServer) dial(string) (net.Conn, error) {
+	c, s := net.Pipe()
+	go f.serve(s)
+	return c, nil
+}
+
+func (f *fakeServer) serve(conn net.Conn) {
+	var h dotnetdiag.Header
+	if err := binary.Read(conn, binary.LittleEndian, &h); err != nil {
+		_ = conn.Close()
+		return
+	}
+	payload := make([]byte, int(h.Size)-binary.Size(h))
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		_ = conn.Close()
+		return
+	}
+	switch h.CommandID {
+	case dotnetdiag.EventPipeCollectTracing, dotnetdiag.EventPipeCollectTracing2:
+		f.m.Lock()
+		f.lastID++
+		id := f.lastID
+		f.sessions[id] = conn
+		f.m.Unlock()
+		writeSessionID(conn, id)
+		f.started <- id
+		_, _ = conn.Write(f.trace)
+	case dotnetdiag.EventPipeStopTracing:
+		id := binary.LittleEndian.Uint64(payload)
+		writeSessionID(conn, id)
+		_ = conn.Close()
+		f.m.Lock()
+		s := f.sessions[id]
+		f.m.Unlock()
+		_ = s.Close()
+	}
+}
+
+func writeSessionID(w io.Writer, id uint64) {
+	h := dotnetdiag.Header{
+		Magic: [14]uint8{0x44, 0x4F, 0x54, 0x4E, 0x45, 0x54, 0x5f, 0x49, 0x50, 0x43, 0x5F, 0x56, 0x31, 0x00},
+		Size:  uint16(binary.Size(dotnetdiag.Header{}) + 8),
+	}
+	_ = binary.Write(w, binary.LittleEndian, h)
+	_ = binary.Write(w, binary.LittleEndian, id)
+}
+
+func newTestServer(f *fakeServer, options ...Option) (*httptest.Server, *Handler) {
+	h := NewHandler(append(options, WithClientOptions(dotnetdiag.WithDialer(f.dial)))...)
+	h.address = func(int) string { return "fake" }
+	return httptest.NewServer(h), h
+}
+
+func TestHandlerProfile(t *testing.T) {
+	srv, _ := newTestServer(newFakeServer(t))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/debug/pprof/profile?pid=1&seconds=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %s", resp.Status)
+	}
+	p, err := profile.Parse(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.SampleType) != 1 || p.SampleType[0].Type != "wall" || p.SampleType[0].Unit != "nanoseconds" {
+		t.Fatalf("unexpected sample types: %v", p.SampleType)
+	}
+
+	f, err := os.Open(sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	samples, err := sampleProfile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected, total time.Duration
+	for _, v := range samples {
+		expected += v
+	}
+	for _, s := range p.Sample {
+		total += time.Duration(s.Value[0])
+	}
+	if len(p.Sample) != len(samples) || total != expected {
+		t.Fatalf("expected %d samples of %v, got %d samples of %v", len(samples), expected, len(p.Sample), total)
+	}
+}
+
+func TestHandlerBadRequest(t *testing.T) {
+	srv, _ := newTestServer(newFakeServer(t), WithMaxDuration(time.Minute))
+	defer srv.Close()
+	for _, path := range []string{
+		"/debug/pprof/profile?pid=x",
+		"/debug/pprof/profile?pid=1&seconds=120",
+		"/debug/dotnet/trace?pid=1&preset=unknown",
+		"/debug/dotnet/trace?pid=1&providers=:",
+	} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("%s: expected status 400, got %s", path, resp.Status)
+		}
+	}
+}
+
+func TestHandlerMaxSessions(t *testing.T) {
+	f := newFakeServer(t)
+	srv, h := newTestServer(f, WithMaxSessions(1))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/debug/dotnet/trace?pid=1&seconds=60", nil)
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+	}()
+	<-f.started
+
+	resp, err := http.Get(srv.URL + "/debug/pprof/profile?pid=1&seconds=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %s", resp.Status)
+	}
+
+	// The session is stopped when the request is cancelled.
+	cancel()
+	<-done
+	deadline := time.Now().Add(5 * time.Second)
+	for len(h.sessions) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("session slot is not released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
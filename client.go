@@ -1,15 +1,19 @@
 package dotnetdiag
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"sort"
 )
 
 // Client implement Diagnostic IPC Protocol client.
 // https://github.com/dotnet/diagnostics/blob/main/documentation/design-docs/ipc-protocol.md
 type Client struct {
-	addr string
-	dial Dialer
+	addr     string
+	dial     Dialer
+	observer Observer
 }
 
 // Dialer establishes connection to the given address. Due to the potential for
@@ -30,6 +34,14 @@ func WithDialer(d Dialer) Option {
 	}
 }
 
+// WithObserver specifies an observer to be notified about the client
+// and sessions activity.
+func WithObserver(o Observer) Option {
+	return func(c *Client) {
+		c.observer = o
+	}
+}
+
 // Session represents EventPipe stream of NetTrace data created with
 // `CollectTracing` command.
 //
@@ -53,6 +65,13 @@ type CollectTracingConfig struct {
 	// See ETW documentation for a more detailed explanation of Keywords, Filters, and Log Level:
 	// https://docs.microsoft.com/en-us/message-analyzer/system-etw-provider-event-keyword-level-settings
 	Providers []ProviderConfig
+	// DisableRundown prescribes the runtime not to emit rundown events
+	// at the end of the session. The option requires CollectTracing2
+	// command support (.NET 5.0 and newer).
+	DisableRundown bool
+	// NetPerf requests the stream in NetPerf format (version 3) instead of
+	// NetTrace. The format is supported by .NET Core 3.x runtimes only.
+	NetPerf bool
 }
 
 // NewClient creates a new Diagnostic IPC Protocol client for the transport
@@ -71,11 +90,24 @@ func NewClient(addr string, options ...Option) *Client {
 	if c.dial == nil {
 		c.dial = DefaultDialer()
 	}
+	if c.observer == nil {
+		c.observer = NopObserver{}
+	}
 	return c
 }
 
 // CollectTracing creates a new EventPipe session stream of NetTrace data.
-func (c *Client) CollectTracing(config CollectTracingConfig) (s *Session, err error) {
+func (c *Client) CollectTracing(config CollectTracingConfig) (*Session, error) {
+	s, err := c.collectTracing(config)
+	if err != nil {
+		c.observer.Error(err)
+		return nil, err
+	}
+	c.observer.SessionStarted(s.ID)
+	return s, nil
+}
+
+func (c *Client) collectTracing(config CollectTracingConfig) (s *Session, err error) {
 	// Every session has its own IPC connection which cannot be reused for any
 	// other purposes; in order to close the connection another connection
 	// to be opened - see `StopTracing`.
@@ -90,13 +122,7 @@ func (c *Client) CollectTracing(config CollectTracingConfig) (s *Session, err er
 		}
 	}()
 
-	p := CollectTracingPayload{
-		CircularBufferSizeMB: config.CircularBufferSizeMB,
-		Format:               FormatNetTrace,
-		Providers:            config.Providers,
-	}
-
-	if err = writeMessage(conn, CommandSetEventPipe, EventPipeCollectTracing, p.Bytes()); err != nil {
+	if err = writeMessage(conn, CommandSetEventPipe, config.command(), config.payload()); err != nil {
 		return nil, err
 	}
 	var resp CollectTracingResponse
@@ -113,8 +139,47 @@ func (c *Client) CollectTracing(config CollectTracingConfig) (s *Session, err er
 	return s, nil
 }
 
+func (config CollectTracingConfig) command() uint8 {
+	if config.DisableRundown {
+		return EventPipeCollectTracing2
+	}
+	return EventPipeCollectTracing
+}
+
+func (config CollectTracingConfig) format() Format {
+	if config.NetPerf {
+		return FormatNetPerf
+	}
+	return FormatNetTrace
+}
+
+func (config CollectTracingConfig) payload() []byte {
+	if config.DisableRundown {
+		return CollectTracing2Payload{
+			CircularBufferSizeMB: config.CircularBufferSizeMB,
+			Format:               config.format(),
+			RequestRundown:       false,
+			Providers:            config.Providers,
+		}.Bytes()
+	}
+	return CollectTracingPayload{
+		CircularBufferSizeMB: config.CircularBufferSizeMB,
+		Format:               config.format(),
+		Providers:            config.Providers,
+	}.Bytes()
+}
+
 // StopTracing stops the given streaming session started with CollectTracing.
 func (c *Client) StopTracing(sessionID uint64) error {
+	if err := c.stopTracing(sessionID); err != nil {
+		c.observer.Error(err)
+		return err
+	}
+	c.observer.SessionStopped(sessionID)
+	return nil
+}
+
+func (c *Client) stopTracing(sessionID uint64) error {
 	conn, err := c.dial(c.addr)
 	if err != nil {
 		return err
@@ -137,10 +202,90 @@ func (c *Client) StopTracing(sessionID uint64) error {
 	return nil
 }
 
+// CreateCoreDump requests the runtime to write a dump of the process to the
+// given path. The path is interpreted by the target process.
+func (c *Client) CreateCoreDump(path string, dumpType DumpType) error {
+	err := c.createCoreDump(path, dumpType)
+	if err != nil {
+		c.observer.Error(err)
+	}
+	return err
+}
+
+func (c *Client) createCoreDump(path string, dumpType DumpType) error {
+	conn, err := c.dial(c.addr)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	p := CreateCoreDumpPayload{DumpName: path, DumpType: dumpType}
+	if err := writeMessage(conn, CommandSetDump, DumpCreateCoreDump, p.Bytes()); err != nil {
+		return err
+	}
+	var resp CreateCoreDumpResponse
+	if err := readResponse(conn, &resp); err != nil {
+		return err
+	}
+	if resp.HResult != 0 {
+		return fmt.Errorf("%w: create core dump: error code %#x", ErrDiagnosticServer, resp.HResult)
+	}
+	return nil
+}
+
+// ProcessInfo returns information about the target process.
+func (c *Client) ProcessInfo() (*ProcessInfoResponse, error) {
+	info, err := c.processInfo()
+	if err != nil {
+		c.observer.Error(err)
+	}
+	return info, err
+}
+
+func (c *Client) processInfo() (*ProcessInfoResponse, error) {
+	conn, err := c.dial(c.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if err = writeMessage(conn, CommandSetProcess, ProcessProcessInfo, nil); err != nil {
+		return nil, err
+	}
+	var resp ProcessInfoResponse
+	if err = readResponse(conn, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 func (s *Session) Read(b []byte) (int, error) {
-	return s.conn.Read(b)
+	n, err := s.conn.Read(b)
+	if n > 0 {
+		s.c.observer.SessionRead(s.ID, n)
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		s.c.observer.Error(err)
+	}
+	return n, err
 }
 
 func (s *Session) Close() error {
 	return s.c.StopTracing(s.ID)
 }
+
+func uniquePIDs(pids []int) []int {
+	sort.Ints(pids)
+	j := 0
+	for i := range pids {
+		if i == 0 || pids[i] != pids[j-1] {
+			pids[j] = pids[i]
+			j++
+		}
+	}
+	return pids[:j]
+}
@@ -8,6 +8,8 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
 )
 
 func DefaultDialer() Dialer {
@@ -34,3 +36,25 @@ func DefaultServerAddress(pid int) string {
 	sort.Slice(paths, func(i, j int) bool { return paths[i] > paths[j] })
 	return paths[0]
 }
+
+// Processes returns sorted list of IDs of the processes that have
+// Diagnostic Server unix domain socket open.
+func Processes() ([]int, error) {
+	paths, err := filepath.Glob(fmt.Sprintf("%s/dotnet-diagnostic-*-*-socket", os.TempDir()))
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, p := range paths {
+		f := strings.Split(filepath.Base(p), "-")
+		if len(f) != 5 {
+			continue
+		}
+		pid, err := strconv.Atoi(f[2])
+		if err != nil {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	return uniquePIDs(pids), nil
+}
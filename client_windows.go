@@ -3,6 +3,9 @@ package dotnetdiag
 import (
 	"fmt"
 	"net"
+	"os"
+	"strconv"
+	"strings"
 
 	"github.com/Microsoft/go-winio"
 )
@@ -18,3 +21,25 @@ func DefaultDialer() Dialer {
 func DefaultServerAddress(pid int) string {
 	return fmt.Sprintf(`\\.\pipe\dotnet-diagnostic-%d`, pid)
 }
+
+// Processes returns sorted list of IDs of the processes that have
+// Diagnostic Server named pipe open.
+func Processes() ([]int, error) {
+	entries, err := os.ReadDir(`\\.\pipe\`)
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, "dotnet-diagnostic-") {
+			continue
+		}
+		pid, err := strconv.Atoi(strings.TrimPrefix(name, "dotnet-diagnostic-"))
+		if err != nil {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	return uniquePIDs(pids), nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pyroscope-io/dotnetdiag"
+)
+
+func collect(args []string) error {
+	fs := newFlagSet("collect", "-p <pid> [-preset names] [-providers spec] [-duration d] [-o file]")
+	pid := fs.Int("p", 0, "target process ID")
+	output := fs.String("o", "trace.nettrace", "output file")
+	presets := fs.String("preset", "", "comma-separated list of presets: "+strings.Join(dotnetdiag.Presets(), ", "))
+	providers := fs.String("providers", "", "providers in dotnet-trace format, e.g. 'Microsoft-Windows-DotNETRuntime:0x1:5'")
+	duration := fs.Duration("duration", 0, "collection duration; by default, until interrupted")
+	buffer := fs.Uint("buffer", 256, "circular buffer size in MB")
+	_ = fs.Parse(args)
+
+	c, err := newClient(*pid)
+	if err != nil {
+		return err
+	}
+	config, err := providerConfigs(*presets, *providers)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	s, err := c.CollectTracing(dotnetdiag.CollectTracingConfig{
+		CircularBufferSizeMB: uint32(*buffer),
+		Providers:            config,
+	})
+	if err != nil {
+		return err
+	}
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			if err := s.Close(); err != nil {
+				log.Println("failed to stop session:", err)
+			}
+		})
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		<-sigs
+		stop()
+	}()
+	if *duration > 0 {
+		t := time.AfterFunc(*duration, stop)
+		defer t.Stop()
+	}
+
+	log.Printf("collecting trace to %s, press Ctrl+C to stop", *output)
+	n, err := io.Copy(f, s)
+	if err != nil {
+		stop()
+		return err
+	}
+	log.Printf("%d bytes written", n)
+	return f.Close()
+}
+
+// providerConfigs combines the providers of the given presets, and the ones
+// specified in dotnet-trace format. By default, CPU sampling preset is used.
+func providerConfigs(presets, providers string) ([]dotnetdiag.ProviderConfig, error) {
+	var config []dotnetdiag.ProviderConfig
+	if presets != "" {
+		for _, name := range strings.Split(presets, ",") {
+			p, err := dotnetdiag.Preset(strings.TrimSpace(name))
+			if err != nil {
+				return nil, err
+			}
+			config = append(config, p...)
+		}
+	}
+	if providers != "" {
+		p, err := dotnetdiag.ParseProviders(providers)
+		if err != nil {
+			return nil, err
+		}
+		config = append(config, p...)
+	}
+	if len(config) == 0 {
+		config = dotnetdiag.CPUSamplingProviders()
+	}
+	return config, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace/profiler"
+)
+
+var formatExtensions = map[string]string{
+	"pprof":      ".pb.gz",
+	"folded":     ".folded",
+	"speedscope": ".speedscope.json",
+}
+
+func convert(args []string) error {
+	fs := newFlagSet("convert", "[-format pprof|folded|speedscope] [-o file] <trace.nettrace>")
+	format := fs.String("format", "pprof", "output format: pprof, folded, or speedscope")
+	output := fs.String("o", "", "output file; by default, the input file name with the format extension")
+	managed := fs.Bool("managed", false, "ignore time spent in native code")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	input := fs.Arg(0)
+	ext, ok := formatExtensions[*format]
+	if !ok {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	if *output == "" {
+		*output = strings.TrimSuffix(input, filepath.Ext(input)) + ext
+	}
+
+	p, err := readProfile(input, profilerOptions(*managed)...)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case "pprof":
+		err = profiler.WritePprof(f, p.samples, p.start, p.duration)
+	case "folded":
+		err = profiler.WriteFolded(f, p.samples)
+	case "speedscope":
+		err = profiler.WriteSpeedscope(f, filepath.Base(input), p.samples)
+	}
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pyroscope-io/dotnetdiag/counters"
+)
+
+func showCounters(args []string) error {
+	fs := newFlagSet("counters", "-p <pid> [-providers names] [-interval d]")
+	pid := fs.Int("p", 0, "target process ID")
+	providers := fs.String("providers", "", "comma-separated list of EventSource names; by default, System.Runtime")
+	interval := fs.Duration("interval", time.Second, "counters publishing interval")
+	_ = fs.Parse(args)
+
+	c, err := newClient(*pid)
+	if err != nil {
+		return err
+	}
+	config := counters.Config{Interval: *interval}
+	if *providers != "" {
+		config.Providers = strings.Split(*providers, ",")
+	}
+	r, err := counters.NewReader(c, config)
+	if err != nil {
+		return err
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		<-sigs
+		_ = r.Close()
+	}()
+
+	// Counters of a single interval are published at once: the screen is
+	// redrawn when a counter of the next interval arrives.
+	latest := make(map[string]counters.Counter)
+	var ts time.Time
+	for {
+		x, err := r.Next()
+		switch {
+		case err == nil:
+		case errors.Is(err, io.EOF):
+			return nil
+		default:
+			_ = r.Close()
+			return err
+		}
+		if x.Timestamp.Sub(ts) > *interval/2 && len(latest) > 0 {
+			draw(latest)
+		}
+		ts = x.Timestamp
+		latest[x.Provider+"/"+x.Name] = x
+	}
+}
+
+func draw(latest map[string]counters.Counter) {
+	keys := make([]string, 0, len(latest))
+	for k := range latest {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	// Clear screen and move the cursor home.
+	fmt.Print("\033[H\033[2J")
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	var provider string
+	for _, k := range keys {
+		x := latest[k]
+		if x.Provider != provider {
+			provider = x.Provider
+			_, _ = fmt.Fprintf(w, "[%s]\n", provider)
+		}
+		name := x.DisplayName
+		if x.DisplayUnits != "" {
+			name += " (" + x.DisplayUnits + ")"
+		}
+		_, _ = fmt.Fprintf(w, "    %s\t%.4g\n", name, x.Value)
+	}
+	_ = w.Flush()
+}
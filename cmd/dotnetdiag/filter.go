@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+)
+
+func filter(args []string) error {
+	fs := newFlagSet("filter", "[-providers names] [-events ids] [-threads ids] [-start d] [-end d] -o file <trace.nettrace>")
+	output := fs.String("o", "", "output file")
+	providers := fs.String("providers", "", "comma-separated list of providers to keep")
+	events := fs.String("events", "", "comma-separated list of event IDs to keep")
+	threads := fs.String("threads", "", "comma-separated list of thread IDs to keep")
+	start := fs.Duration("start", 0, "start of the time window, relative to the trace start")
+	end := fs.Duration("end", 0, "end of the time window, relative to the trace start")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 || *output == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var options []nettrace.RewriteOption
+	if *providers != "" {
+		options = append(options, nettrace.RewriteProviders(strings.Split(*providers, ",")...))
+	}
+	if *events != "" {
+		var ids []int32
+		for _, s := range strings.Split(*events, ",") {
+			id, err := strconv.ParseInt(s, 10, 32)
+			if err != nil {
+				return fmt.Errorf("invalid event ID %q", s)
+			}
+			ids = append(ids, int32(id))
+		}
+		options = append(options, nettrace.RewriteEventIDs(ids...))
+	}
+	if *threads != "" {
+		var ids []int64
+		for _, s := range strings.Split(*threads, ",") {
+			id, err := strconv.ParseInt(s, 0, 64)
+			if err != nil {
+				return fmt.Errorf("invalid thread ID %q", s)
+			}
+			ids = append(ids, id)
+		}
+		options = append(options, nettrace.RewriteThreads(ids...))
+	}
+
+	in, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	if *start > 0 || *end > 0 {
+		trace, err := nettrace.NewDecoder(in).OpenTrace()
+		if err != nil {
+			return err
+		}
+		if _, err = in.Seek(0, 0); err != nil {
+			return err
+		}
+		var from, to time.Time
+		if *start > 0 {
+			from = trace.SyncTime().Add(*start)
+		}
+		if *end > 0 {
+			to = trace.SyncTime().Add(*end)
+		}
+		options = append(options, nettrace.RewriteTimeRange(from, to))
+	}
+
+	out, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err = nettrace.Rewrite(out, in, options...); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,83 @@
+// Command dotnetdiag collects and processes diagnostic data of .NET
+// processes without .NET SDK installed.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/pyroscope-io/dotnetdiag"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"ps", "list .NET processes", ps},
+	{"collect", "collect a trace to a .nettrace file", collect},
+	{"convert", "convert a .nettrace file to pprof, folded stacks or speedscope", convert},
+	{"filter", "write a .nettrace file restricted by provider, event, thread or time", filter},
+	{"redact", "write a .nettrace file with sensitive payload data redacted", redact},
+	{"split", "split a .nettrace file into size- or time-bounded files", split},
+	{"merge", "merge .nettrace files of the same process into one", merge},
+	{"report", "print the top hot methods of a .nettrace file", report},
+	{"top", "show the hottest methods of a process in real time", top},
+	{"counters", "show EventCounters of a process", showCounters},
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("dotnetdiag: ")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	name := flag.Arg(0)
+	for _, c := range commands {
+		if c.name == name {
+			if err := c.run(flag.Args()[1:]); err != nil {
+				log.Fatalln(err)
+			}
+			return
+		}
+	}
+	log.Printf("unknown command %q", name)
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	w := flag.CommandLine.Output()
+	_, _ = fmt.Fprintf(w, "Usage: dotnetdiag <command> [arguments]\n\nCommands:\n")
+	for _, c := range commands {
+		_, _ = fmt.Fprintf(w, "  %-10s %s\n", c.name, c.usage)
+	}
+	_, _ = fmt.Fprintf(w, "\nRun 'dotnetdiag <command> -h' for the command arguments.\n")
+}
+
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(fs.Output(), "Usage: dotnetdiag %s %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func newClient(pid int) (*dotnetdiag.Client, error) {
+	if pid <= 0 {
+		return nil, fmt.Errorf("target process ID (-p) is required")
+	}
+	addr := dotnetdiag.DefaultServerAddress(pid)
+	if addr == "" {
+		return nil, fmt.Errorf("diagnostic server of process %d not found", pid)
+	}
+	return dotnetdiag.NewClient(addr), nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	pprof "github.com/google/pprof/profile"
+)
+
+const sample = "../../nettrace/testdata/dotnet-5.0-SampleProfiler-single-thread.golden.nettrace"
+
+func TestHotMethods(t *testing.T) {
+	methods, total := hotMethods(map[string]time.Duration{
+		"Main;A;B;A": 3 * time.Millisecond,
+		"Main;A":     2 * time.Millisecond,
+		"Main;C":     time.Millisecond,
+	})
+	if total != 6*time.Millisecond {
+		t.Fatalf("expected 6ms total, got %v", total)
+	}
+	expected := []methodTime{
+		// Time of the recursive call is accounted once.
+		{name: "A", self: 5 * time.Millisecond, total: 5 * time.Millisecond},
+		{name: "C", self: time.Millisecond, total: time.Millisecond},
+		{name: "Main", total: 6 * time.Millisecond},
+		{name: "B", total: 3 * time.Millisecond},
+	}
+	if len(methods) != len(expected) {
+		t.Fatalf("expected %d methods, got %+v", len(expected), methods)
+	}
+	for i, m := range methods {
+		if m != expected[i] {
+			t.Fatalf("method %d: expected %+v, got %+v", i, expected[i], m)
+		}
+	}
+}
+
+func TestConvertFormats(t *testing.T) {
+	p, err := readProfile(sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected time.Duration
+	for _, v := range p.samples {
+		expected += v
+	}
+
+	dir := t.TempDir()
+	for format := range formatExtensions {
+		output := filepath.Join(dir, format)
+		if err = convert([]string{"-format", format, "-o", output, sample}); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		f, err := os.Open(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var total time.Duration
+		switch format {
+		case "pprof":
+			x, err := pprof.Parse(f)
+			if err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+			for _, s := range x.Sample {
+				total += time.Duration(s.Value[0])
+			}
+		case "folded":
+			s := bufio.NewScanner(f)
+			for s.Scan() {
+				i := strings.LastIndexByte(s.Text(), ' ')
+				v, err := strconv.ParseInt(s.Text()[i+1:], 10, 64)
+				if i < 0 || err != nil {
+					t.Fatalf("%s: malformed line %q", format, s.Text())
+				}
+				total += time.Duration(v) * time.Microsecond
+			}
+		case "speedscope":
+			var x struct {
+				Profiles []struct {
+					Weights []int64 `json:"weights"`
+				} `json:"profiles"`
+			}
+			if err = json.NewDecoder(f).Decode(&x); err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+			for _, w := range x.Profiles[0].Weights {
+				total += time.Duration(w)
+			}
+		}
+		_ = f.Close()
+		// Folded values are rounded to microseconds.
+		if d := expected - total; d < 0 || d > time.Duration(len(p.samples))*time.Microsecond {
+			t.Fatalf("%s: expected %v total, got %v", format, expected, total)
+		}
+	}
+}
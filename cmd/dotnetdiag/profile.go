@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+	"github.com/pyroscope-io/dotnetdiag/nettrace/profiler"
+)
+
+// profile contains samples of a trace.
+type profile struct {
+	samples  map[string]time.Duration
+	start    time.Time
+	duration time.Duration
+}
+
+// readProfile processes SampleProfiler events of the given .nettrace file.
+func readProfile(path string, options ...profiler.Option) (*profile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	stream := nettrace.NewStream(f)
+	trace, err := stream.Open()
+	if err != nil {
+		return nil, err
+	}
+	p := profiler.NewSampleProfiler(trace, options...)
+	var last int64
+	stream.EventHandler = func(e *nettrace.Blob) error {
+		if e.Header.TimeStamp > last {
+			last = e.Header.TimeStamp
+		}
+		return p.EventHandler(e)
+	}
+	stream.MetadataHandler = p.MetadataHandler
+	stream.StackBlockHandler = p.StackBlockHandler
+	stream.SequencePointBlockHandler = p.SequencePointBlockHandler
+	for {
+		err = stream.Next()
+		switch {
+		case err == nil:
+			continue
+		case errors.Is(err, io.EOF):
+			x := profile{
+				samples: p.Samples(),
+				start:   trace.SyncTime(),
+			}
+			if last > trace.SyncTimeQPC {
+				x.duration = trace.Duration(last - trace.SyncTimeQPC)
+			}
+			return &x, nil
+		default:
+			return nil, err
+		}
+	}
+}
+
+func profilerOptions(managedOnly bool) []profiler.Option {
+	if managedOnly {
+		return []profiler.Option{profiler.WithManagedCodeOnly()}
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/pyroscope-io/dotnetdiag"
+)
+
+func ps(args []string) error {
+	fs := newFlagSet("ps", "")
+	_ = fs.Parse(args)
+	pids, err := dotnetdiag.Processes()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "PID\tOS\tARCH\tCOMMAND")
+	for _, pid := range pids {
+		info, err := dotnetdiag.NewClient(dotnetdiag.DefaultServerAddress(pid)).ProcessInfo()
+		if err != nil {
+			// The process may have exited, or the socket is stale.
+			_, _ = fmt.Fprintf(w, "%d\t\t\t(%v)\n", pid, err)
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", pid, info.OS, info.Arch, info.CommandLine)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+	"github.com/pyroscope-io/dotnetdiag/nettrace/clr"
+)
+
+var redactActions = map[string]nettrace.RedactAction{
+	"hash": nettrace.RedactHash,
+	"mask": nettrace.RedactMask,
+	"drop": nettrace.RedactDrop,
+}
+
+func redact(args []string) error {
+	fs := newFlagSet("redact", "[-rules rules] [-no-defaults] [-key key] -o file <trace.nettrace>")
+	output := fs.String("o", "", "output file")
+	rules := fs.String("rules", "", "comma-separated list of [provider/[event IDs/]]field=hash|mask|drop rules, "+
+		"event IDs are separated with '+'; e.g. 'My-Provider/1+2/Sql=mask'")
+	noDefaults := fs.Bool("no-defaults", false, "do not redact file paths and command lines of the runtime events")
+	key := fs.String("key", os.Getenv("DOTNETDIAG_REDACT_KEY"), "secret key of the hash action, "+
+		"DOTNETDIAG_REDACT_KEY by default; if not specified, a random key is used")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 || *output == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var r []nettrace.RedactRule
+	if *rules != "" {
+		for _, s := range strings.Split(*rules, ",") {
+			rule, err := parseRedactRule(s)
+			if err != nil {
+				return err
+			}
+			r = append(r, rule)
+		}
+	}
+	if !*noDefaults {
+		r = append(r, nettrace.DefaultRedactRules...)
+	}
+
+	k := []byte(*key)
+	if len(k) == 0 {
+		// Hashes only match within the output.
+		k = make([]byte, 32)
+		if _, err := rand.Read(k); err != nil {
+			return err
+		}
+	}
+
+	in, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	out, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	err = nettrace.Rewrite(out, in,
+		nettrace.RewriteMetadataFields(clr.Fields),
+		nettrace.RewriteRedact(k, r...))
+	if err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func parseRedactRule(s string) (rule nettrace.RedactRule, err error) {
+	i := strings.LastIndexByte(s, '=')
+	if i < 0 {
+		return rule, fmt.Errorf("invalid rule %q: action is not specified", s)
+	}
+	var ok bool
+	if rule.Action, ok = redactActions[s[i+1:]]; !ok {
+		return rule, fmt.Errorf("invalid rule %q: unknown action", s)
+	}
+	parts := strings.Split(s[:i], "/")
+	switch len(parts) {
+	case 1:
+	case 2:
+		rule.Provider = parts[0]
+	case 3:
+		rule.Provider = parts[0]
+		for _, x := range strings.Split(parts[1], "+") {
+			id, err := strconv.ParseInt(x, 10, 32)
+			if err != nil {
+				return rule, fmt.Errorf("invalid rule %q: invalid event ID %q", s, x)
+			}
+			rule.EventIDs = append(rule.EventIDs, int32(id))
+		}
+	default:
+		return rule, fmt.Errorf("invalid rule %q", s)
+	}
+	rule.Field = parts[len(parts)-1]
+	return rule, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func report(args []string) error {
+	fs := newFlagSet("report", "[-n 20] [-managed] <trace.nettrace>")
+	n := fs.Int("n", 20, "number of methods to show")
+	managed := fs.Bool("managed", false, "ignore time spent in native code")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	p, err := readProfile(fs.Arg(0), profilerOptions(*managed)...)
+	if err != nil {
+		return err
+	}
+	return writeReport(os.Stdout, p.samples, *n)
+}
+
+type methodTime struct {
+	name  string
+	self  time.Duration
+	total time.Duration
+}
+
+// hotMethods calculates self and total time of every method. Time of
+// recursive calls is accounted once per stack.
+func hotMethods(samples map[string]time.Duration) (methods []methodTime, total time.Duration) {
+	m := make(map[string]*methodTime)
+	get := func(name string) *methodTime {
+		x, ok := m[name]
+		if !ok {
+			x = &methodTime{name: name}
+			m[name] = x
+		}
+		return x
+	}
+	for stack, d := range samples {
+		total += d
+		frames := strings.Split(stack, ";")
+		get(frames[len(frames)-1]).self += d
+		seen := make(map[string]struct{}, len(frames))
+		for _, f := range frames {
+			if _, ok := seen[f]; ok {
+				continue
+			}
+			seen[f] = struct{}{}
+			get(f).total += d
+		}
+	}
+	methods = make([]methodTime, 0, len(m))
+	for _, x := range m {
+		methods = append(methods, *x)
+	}
+	sort.Slice(methods, func(i, j int) bool {
+		if methods[i].self != methods[j].self {
+			return methods[i].self > methods[j].self
+		}
+		if methods[i].total != methods[j].total {
+			return methods[i].total > methods[j].total
+		}
+		return methods[i].name < methods[j].name
+	})
+	return methods, total
+}
+
+func writeReport(out io.Writer, samples map[string]time.Duration, n int) error {
+	methods, total := hotMethods(samples)
+	if n > 0 && len(methods) > n {
+		methods = methods[:n]
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprintf(w, "SELF\tSELF%%\tTOTAL\tTOTAL%%\t\n")
+	for _, x := range methods {
+		_, _ = fmt.Fprintf(w, "%v\t%.2f%%\t%v\t%.2f%%\t  %s\n",
+			x.self.Round(time.Millisecond), percent(x.self, total),
+			x.total.Round(time.Millisecond), percent(x.total, total),
+			x.name)
+	}
+	return w.Flush()
+}
+
+func percent(d, total time.Duration) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(d) / float64(total) * 100
+}
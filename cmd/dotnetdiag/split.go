@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+)
+
+func split(args []string) error {
+	fs := newFlagSet("split", "[-size MB | -duration d | -n count] [-o file] <trace.nettrace>")
+	output := fs.String("o", "", "output file name, the file number is inserted before the extension; by default, the input file name")
+	size := fs.Uint64("size", 0, "file size in MB")
+	duration := fs.Duration("duration", 0, "file duration")
+	n := fs.Uint64("n", 0, "approximate number of files of equal size")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 || (*size == 0 && *duration == 0 && *n == 0) {
+		fs.Usage()
+		os.Exit(2)
+	}
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "n" && *n < 1 {
+			err = fmt.Errorf("invalid number of files %d", *n)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	input := fs.Arg(0)
+	if *output == "" {
+		*output = input
+	}
+
+	f, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	var options []nettrace.RotateOption
+	switch {
+	case *n > 0:
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if *n > uint64(fi.Size()) {
+			return fmt.Errorf("invalid number of files %d: file size %d", *n, fi.Size())
+		}
+		options = append(options, nettrace.RotateBySize(uint64(fi.Size()) / *n))
+	case *size > 0:
+		options = append(options, nettrace.RotateBySize(*size<<20))
+	}
+	if *duration > 0 {
+		options = append(options, nettrace.RotateByDuration(*duration))
+	}
+	var files []string
+	create := nettrace.NumberedFiles(*output)
+	err = nettrace.Split(f, func(i int) (io.WriteCloser, error) {
+		files = append(files, numberedFile(*output, i))
+		return create(i)
+	}, options...)
+	if err != nil {
+		return err
+	}
+	for _, name := range files {
+		fmt.Println(name)
+	}
+	return nil
+}
+
+// numberedFile returns the name of the file created by nettrace.NumberedFiles.
+func numberedFile(path string, n int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(path, ext), n, ext)
+}
+
+func merge(args []string) error {
+	fs := newFlagSet("merge", "-o file <trace.nettrace>...")
+	output := fs.String("o", "", "output file")
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 || *output == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+	srcs := make([]io.Reader, fs.NArg())
+	for i, name := range fs.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+		srcs[i] = f
+	}
+	out, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err = nettrace.Merge(out, srcs); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
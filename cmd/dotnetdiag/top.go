@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pyroscope-io/dotnetdiag"
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+	"github.com/pyroscope-io/dotnetdiag/nettrace/profiler"
+)
+
+func top(args []string) error {
+	fs := newFlagSet("top", "-p <pid> [-n 20] [-window d] [-refresh d] [-thread id] [-managed]")
+	pid := fs.Int("p", 0, "target process ID")
+	n := fs.Int("n", 20, "number of methods to show")
+	window := fs.Duration("window", 10*time.Second, "sliding window duration")
+	refresh := fs.Duration("refresh", time.Second, "screen refresh interval")
+	tid := fs.Int64("thread", 0, "show samples of the given thread only")
+	managed := fs.Bool("managed", false, "ignore time spent in native code")
+	_ = fs.Parse(args)
+
+	c, err := newClient(*pid)
+	if err != nil {
+		return err
+	}
+	// Methods that have been compiled before the session started are only
+	// reported in the rundown, which is emitted at the end of a session:
+	// a short session is used to populate the symbol table.
+	sym, err := rundown(c)
+	if err != nil {
+		return err
+	}
+	s, err := c.CollectTracing(dotnetdiag.CollectTracingConfig{
+		CircularBufferSizeMB: 64,
+		Providers: []dotnetdiag.ProviderConfig{
+			{
+				ProviderName: dotnetdiag.ProviderSampleProfiler,
+				Keywords:     dotnetdiag.SampleProfilerKeywords,
+				LogLevel:     dotnetdiag.LevelInformational,
+			},
+			{
+				ProviderName: dotnetdiag.ProviderRuntime,
+				Keywords:     dotnetdiag.RuntimeKeywordJit | dotnetdiag.RuntimeKeywordLoader,
+				LogLevel:     dotnetdiag.LevelVerbose,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			_ = s.Close()
+		})
+	}
+	defer stop()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	stream := nettrace.NewStream(s)
+	trace, err := stream.Open()
+	if err != nil {
+		return err
+	}
+	options := append(profilerOptions(*managed), profiler.WithSymbolsOf(sym))
+	p := profiler.NewSampleProfiler(trace, options...)
+	stream.EventHandler = p.EventHandler
+	stream.MetadataHandler = p.MetadataHandler
+	stream.StackBlockHandler = p.StackBlockHandler
+	stream.SequencePointBlockHandler = p.SequencePointBlockHandler
+	done := make(chan error, 1)
+	go func() {
+		done <- processStream(stream)
+	}()
+
+	v := topView{window: *window, threadID: *tid}
+	t := time.NewTicker(*refresh)
+	defer t.Stop()
+	for {
+		select {
+		case <-sigs:
+			stop()
+			return <-done
+		case err = <-done:
+			return err
+		case now := <-t.C:
+			v.add(now, p.Drain())
+			v.draw(os.Stdout, now, *n)
+		}
+	}
+}
+
+// rundown collects a trace with no events but the rundown, and returns
+// a profiler which symbol table is populated.
+func rundown(c *dotnetdiag.Client) (*profiler.SampleProfiler, error) {
+	s, err := c.CollectTracing(dotnetdiag.CollectTracingConfig{
+		CircularBufferSizeMB: 64,
+		Providers: []dotnetdiag.ProviderConfig{{
+			ProviderName: dotnetdiag.ProviderSampleProfiler,
+			LogLevel:     dotnetdiag.LevelCritical,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	stream := nettrace.NewStream(s)
+	trace, err := stream.Open()
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	p := profiler.NewSampleProfiler(trace)
+	stream.EventHandler = p.EventHandler
+	stream.MetadataHandler = p.MetadataHandler
+	// The rundown is written when the session is being stopped: the stream
+	// must be read concurrently, otherwise the runtime may block on writing
+	// to the session, and never reply to the stop command.
+	done := make(chan error, 1)
+	go func() {
+		done <- processStream(stream)
+	}()
+	log.Println("loading symbols")
+	if err = s.Close(); err != nil {
+		return nil, err
+	}
+	return p, <-done
+}
+
+func processStream(stream *nettrace.Stream) error {
+	for {
+		err := stream.Next()
+		switch {
+		case err == nil:
+		case errors.Is(err, io.EOF):
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
+// topView aggregates samples within the sliding window.
+type topView struct {
+	window   time.Duration
+	threadID int64
+	batches  []batch
+}
+
+type batch struct {
+	time    time.Time
+	samples []profiler.Sample
+}
+
+func (v *topView) add(now time.Time, samples []profiler.Sample) {
+	v.batches = append(v.batches, batch{time: now, samples: samples})
+	i := 0
+	for i < len(v.batches) && now.Sub(v.batches[i].time) > v.window {
+		i++
+	}
+	v.batches = v.batches[i:]
+}
+
+func (v *topView) draw(w io.Writer, now time.Time, n int) {
+	samples := make(map[string]time.Duration)
+	threads := make(map[int64]time.Duration)
+	for _, b := range v.batches {
+		for _, x := range b.samples {
+			threads[x.ThreadID] += x.Value
+			if v.threadID != 0 && x.ThreadID != v.threadID {
+				continue
+			}
+			samples[strings.Join(x.Stack, ";")] += x.Value
+		}
+	}
+	// Clear screen and move the cursor home.
+	_, _ = fmt.Fprint(w, "\033[H\033[2J")
+	_, _ = fmt.Fprintf(w, "%s  window: %v  threads: %d", now.Format(time.Stamp), v.window, len(threads))
+	if v.threadID != 0 {
+		_, _ = fmt.Fprintf(w, "  thread: %d", v.threadID)
+	}
+	_, _ = fmt.Fprint(w, "\n\n")
+	_ = writeReport(w, samples, n)
+}
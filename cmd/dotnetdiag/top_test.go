@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pyroscope-io/dotnetdiag"
+)
+
+// rundownServer streams the trace when the session is being stopped,
+// and only replies to the stop command once the trace is read.
+type rundownServer struct {
+	trace   []byte
+	session net.Conn
+}
+
+func (r *rundownServer) dial(string) (net.Conn, error) {
+	c, s := net.Pipe()
+	go r.serve(s)
+	return c, nil
+}
+
+func (r *rundownServer) serve(conn net.Conn) {
+	var h dotnetdiag.Header
+	if err := binary.Read(conn, binary.LittleEndian, &h); err != nil {
+		return
+	}
+	payload := make([]byte, int(h.Size)-binary.Size(h))
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return
+	}
+	const sessionID = 1
+	switch h.CommandID {
+	case dotnetdiag.EventPipeCollectTracing:
+		r.session = conn
+		writeSessionID(conn, sessionID)
+		// The stream header and the trace object are written at once.
+		_, _ = conn.Write(r.trace[:4096])
+	case dotnetdiag.EventPipeStopTracing:
+		_, _ = r.session.Write(r.trace[4096:])
+		_ = r.session.Close()
+		writeSessionID(conn, sessionID)
+		_ = conn.Close()
+	}
+}
+
+func writeSessionID(w io.Writer, id uint64) {
+	_ = binary.Write(w, binary.LittleEndian, dotnetdiag.Header{
+		Magic: [14]uint8{0x44, 0x4F, 0x54, 0x4E, 0x45, 0x54, 0x5f, 0x49, 0x50, 0x43, 0x5F, 0x56, 0x31, 0x00},
+		Size:  uint16(binary.Size(dotnetdiag.Header{}) + 8),
+	})
+	_ = binary.Write(w, binary.LittleEndian, id)
+}
+
+func TestRundown(t *testing.T) {
+	b, err := os.ReadFile(sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := rundownServer{trace: b}
+	c := dotnetdiag.NewClient("fake", dotnetdiag.WithDialer(srv.dial))
+	done := make(chan error, 1)
+	go func() {
+		_, err := rundown(c)
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("rundown is not completed")
+	}
+}
@@ -0,0 +1,192 @@
+// Package counters implements EventCounters reader.
+// https://docs.microsoft.com/en-us/dotnet/core/diagnostics/event-counters
+package counters
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pyroscope-io/dotnetdiag"
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+)
+
+// Counter represents a single EventCounters sample.
+type Counter struct {
+	Provider     string
+	Name         string
+	DisplayName  string
+	DisplayUnits string
+	// Type is either "Mean" (polling counters) or "Sum" (incrementing counters).
+	Type string
+	// Value is the mean value of a polling counter, or the rate per second
+	// of an incrementing counter.
+	Value float64
+	// Increment is the raw increment of an incrementing counter.
+	Increment float64
+	Interval  time.Duration
+	Timestamp time.Time
+}
+
+const (
+	CounterTypeMean = "Mean"
+	CounterTypeSum  = "Sum"
+)
+
+// Config specifies EventCounters session parameters.
+type Config struct {
+	// Providers lists EventSource names which counters to be collected.
+	// By default, System.Runtime counters are collected.
+	Providers []string
+	// Interval specifies counter publishing interval, by default one second.
+	Interval time.Duration
+}
+
+// Reader collects EventCounters of the target process.
+type Reader struct {
+	session *dotnetdiag.Session
+	stream  *nettrace.Stream
+	trace   *nettrace.Trace
+
+	md      map[int32]*nettrace.Metadata
+	pending []Counter
+}
+
+const eventCountersEventName = "EventCounters"
+
+// ProviderConfigs returns EventPipe provider configuration for the given config.
+func (c Config) ProviderConfigs() []dotnetdiag.ProviderConfig {
+	providers := c.Providers
+	if len(providers) == 0 {
+		providers = []string{dotnetdiag.ProviderSystemRuntime}
+	}
+	interval := c.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	configs := make([]dotnetdiag.ProviderConfig, len(providers))
+	for i, name := range providers {
+		configs[i] = dotnetdiag.ProviderConfig{
+			ProviderName: name,
+			Keywords:     0xFFFFFFFF,
+			LogLevel:     dotnetdiag.LevelVerbose,
+			FilterData:   fmt.Sprintf("EventCounterIntervalSec=%g", interval.Seconds()),
+		}
+	}
+	return configs
+}
+
+// NewReader starts a new EventCounters session.
+func NewReader(c *dotnetdiag.Client, config Config) (*Reader, error) {
+	s, err := c.CollectTracing(dotnetdiag.CollectTracingConfig{
+		CircularBufferSizeMB: 1,
+		Providers:            config.ProviderConfigs(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	r, err := newReader(s)
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	r.session = s
+	return r, nil
+}
+
+func newReader(src io.Reader) (*Reader, error) {
+	r := Reader{
+		stream: nettrace.NewStream(src),
+		md:     make(map[int32]*nettrace.Metadata),
+	}
+	var err error
+	if r.trace, err = r.stream.Open(); err != nil {
+		return nil, err
+	}
+	r.stream.MetadataHandler = r.metadataHandler
+	r.stream.EventHandler = r.eventHandler
+	return &r, nil
+}
+
+// Next returns the next counter sample. The call blocks until a sample
+// is available. io.EOF is returned when the session is closed.
+func (r *Reader) Next() (Counter, error) {
+	for len(r.pending) == 0 {
+		if err := r.stream.Next(); err != nil {
+			return Counter{}, err
+		}
+	}
+	c := r.pending[0]
+	r.pending = r.pending[1:]
+	return c, nil
+}
+
+// Close stops the session. Remaining samples can be read with Next
+// until io.EOF is returned.
+func (r *Reader) Close() error {
+	if r.session == nil {
+		return nil
+	}
+	return r.session.Close()
+}
+
+func (r *Reader) metadataHandler(md *nettrace.Metadata) error {
+	r.md[md.Header.MetaDataID] = md
+	return nil
+}
+
+func (r *Reader) eventHandler(blob *nettrace.Blob) error {
+	md, ok := r.md[blob.Header.MetadataID]
+	if !ok || md.Header.EventName != eventCountersEventName {
+		return nil
+	}
+	fields, err := nettrace.DecodePayloadMap(blob, md)
+	if err != nil {
+		return fmt.Errorf("decoding %s event: %w", eventCountersEventName, err)
+	}
+	payload, ok := fields["Payload"].(map[string]interface{})
+	if !ok {
+		return errors.New("EventCounters payload not found")
+	}
+	c := Counter{
+		Provider:     md.Header.ProviderName,
+		Name:         stringValue(payload["Name"]),
+		DisplayName:  stringValue(payload["DisplayName"]),
+		DisplayUnits: stringValue(payload["DisplayUnits"]),
+		Type:         stringValue(payload["CounterType"]),
+		Interval:     time.Duration(floatValue(payload["IntervalSec"]) * float64(time.Second)),
+		Timestamp:    r.trace.Time(blob.Header.TimeStamp),
+	}
+	switch c.Type {
+	case CounterTypeSum:
+		c.Increment = floatValue(payload["Increment"])
+		if s := c.Interval.Seconds(); s > 0 {
+			c.Value = c.Increment / s
+		}
+	default:
+		c.Value = floatValue(payload["Mean"])
+	}
+	r.pending = append(r.pending, c)
+	return nil
+}
+
+func stringValue(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+func floatValue(v interface{}) float64 {
+	switch x := v.(type) {
+	case float64:
+		return x
+	case float32:
+		return float64(x)
+	case int32:
+		return float64(x)
+	case int64:
+		return float64(x)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,100 @@
+package counters
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+	"github.com/pyroscope-io/dotnetdiag/nettrace/typecode"
+)
+
+func TestReader(t *testing.T) {
+	field := func(code typecode.TypeCode, name string) nettrace.MetadataField {
+		return nettrace.MetadataField{TypeCode: code, Name: name}
+	}
+	b := nettrace.NewBuilder()
+	// EventCounters payload is an object of the counter properties.
+	id := b.Metadata("System.Runtime", 0, "EventCounters", nettrace.MetadataField{
+		TypeCode: typecode.Object,
+		Name:     "Payload",
+		Payload: nettrace.MetadataPayload{Fields: []nettrace.MetadataField{
+			field(typecode.String, "Name"),
+			field(typecode.String, "DisplayName"),
+			field(typecode.Double, "Mean"),
+			field(typecode.Double, "Increment"),
+			field(typecode.Single, "IntervalSec"),
+			field(typecode.String, "CounterType"),
+			field(typecode.String, "DisplayUnits"),
+		}},
+	})
+	payload := func(name, display string, mean, increment float64, interval float32, typ, units string) []byte {
+		var buf bytes.Buffer
+		str := func(s string) {
+			_ = binary.Write(&buf, binary.LittleEndian, append(utf16.Encode([]rune(s)), 0))
+		}
+		str(name)
+		str(display)
+		_ = binary.Write(&buf, binary.LittleEndian, mean)
+		_ = binary.Write(&buf, binary.LittleEndian, increment)
+		_ = binary.Write(&buf, binary.LittleEndian, interval)
+		str(typ)
+		str(units)
+		return buf.Bytes()
+	}
+	ts := b.Trace.SyncTimeQPC + int64(time.Second/100)
+	b.Event(nettrace.BlobHeader{MetadataID: id, ThreadID: 1, TimeStamp: ts},
+		payload("cpu-usage", "CPU Usage", 42.5, 0, 1, CounterTypeMean, "%"))
+	b.Event(nettrace.BlobHeader{MetadataID: id, ThreadID: 1, TimeStamp: ts},
+		payload("exception-count", "Exception Count", 0, 10, 2, CounterTypeSum, ""))
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := newReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []Counter
+	for {
+		c, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual = append(actual, c)
+	}
+	timestamp := b.Trace.Time(ts)
+	expected := []Counter{
+		{
+			Provider:     "System.Runtime",
+			Name:         "cpu-usage",
+			DisplayName:  "CPU Usage",
+			DisplayUnits: "%",
+			Type:         CounterTypeMean,
+			Value:        42.5,
+			Interval:     time.Second,
+			Timestamp:    timestamp,
+		},
+		{
+			Provider:    "System.Runtime",
+			Name:        "exception-count",
+			DisplayName: "Exception Count",
+			Type:        CounterTypeSum,
+			Value:       5,
+			Increment:   10,
+			Interval:    2 * time.Second,
+			Timestamp:   timestamp,
+		},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected:\n%+v\ngot:\n%+v", expected, actual)
+	}
+}
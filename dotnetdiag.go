@@ -6,6 +6,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"unicode/utf16"
 
 	"golang.org/x/text/encoding/unicode"
 )
@@ -39,6 +40,11 @@ const (
 	CommandSetServer = 0xFF
 )
 
+const (
+	_ = iota
+	DumpCreateCoreDump
+)
+
 const (
 	_ = iota
 	EventPipeStopTracing
@@ -46,12 +52,23 @@ const (
 	EventPipeCollectTracing2
 )
 
+const (
+	ProcessProcessInfo = iota
+)
+
 type CollectTracingPayload struct {
 	CircularBufferSizeMB uint32
 	Format               Format
 	Providers            []ProviderConfig
 }
 
+type CollectTracing2Payload struct {
+	CircularBufferSizeMB uint32
+	Format               Format
+	RequestRundown       bool
+	Providers            []ProviderConfig
+}
+
 type Format uint32
 
 const (
@@ -66,6 +83,35 @@ type ProviderConfig struct {
 	FilterData   string
 }
 
+type CreateCoreDumpPayload struct {
+	DumpName    string
+	DumpType    DumpType
+	Diagnostics uint32
+}
+
+type DumpType uint32
+
+const (
+	_ DumpType = iota
+	DumpTypeNormal
+	DumpTypeWithHeap
+	DumpTypeTriage
+	DumpTypeFull
+)
+
+type CreateCoreDumpResponse struct {
+	HResult uint32
+}
+
+// ProcessInfoResponse describes the target process.
+type ProcessInfoResponse struct {
+	ProcessID     uint64
+	RuntimeCookie [16]byte
+	CommandLine   string
+	OS            string
+	Arch          string
+}
+
 type ErrorResponse struct {
 	Code uint32
 }
@@ -109,6 +155,9 @@ func readResponse(r io.Reader, v interface{}) error {
 		return ErrHeaderMalformed
 	}
 	if !(h.CommandSet == CommandSetServer && h.CommandID == 0xFF) {
+		if d, ok := v.(responseDecoder); ok {
+			return d.decode(r)
+		}
 		return binary.Read(r, binary.LittleEndian, v)
 	}
 	// TODO: improve error handling.
@@ -119,17 +168,59 @@ func readResponse(r io.Reader, v interface{}) error {
 	return fmt.Errorf("%w: error code %#x", ErrDiagnosticServer, er.Code)
 }
 
+// responseDecoder is implemented by responses of variable size.
+type responseDecoder interface {
+	decode(io.Reader) error
+}
+
+func (p *ProcessInfoResponse) decode(r io.Reader) error {
+	if err := binary.Read(r, binary.LittleEndian, &p.ProcessID); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(r, p.RuntimeCookie[:]); err != nil {
+		return err
+	}
+	for _, s := range []*string{&p.CommandLine, &p.OS, &p.Arch} {
+		var err error
+		if *s, err = readString(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (p CollectTracingPayload) Bytes() []byte {
 	b := new(bytes.Buffer)
 	_ = binary.Write(b, binary.LittleEndian, p.CircularBufferSizeMB)
 	_ = binary.Write(b, binary.LittleEndian, p.Format)
-	_ = binary.Write(b, binary.LittleEndian, uint32(len(p.Providers)))
-	for _, x := range p.Providers {
+	writeProviders(b, p.Providers)
+	return b.Bytes()
+}
+
+func (p CollectTracing2Payload) Bytes() []byte {
+	b := new(bytes.Buffer)
+	_ = binary.Write(b, binary.LittleEndian, p.CircularBufferSizeMB)
+	_ = binary.Write(b, binary.LittleEndian, p.Format)
+	_ = binary.Write(b, binary.LittleEndian, p.RequestRundown)
+	writeProviders(b, p.Providers)
+	return b.Bytes()
+}
+
+func writeProviders(b *bytes.Buffer, providers []ProviderConfig) {
+	_ = binary.Write(b, binary.LittleEndian, uint32(len(providers)))
+	for _, x := range providers {
 		_ = binary.Write(b, binary.LittleEndian, x.Keywords)
 		_ = binary.Write(b, binary.LittleEndian, x.LogLevel)
 		b.Write(mustStringBytes(x.ProviderName))
 		b.Write(mustStringBytes(x.FilterData))
 	}
+}
+
+func (p CreateCoreDumpPayload) Bytes() []byte {
+	b := new(bytes.Buffer)
+	b.Write(mustStringBytes(p.DumpName))
+	_ = binary.Write(b, binary.LittleEndian, p.DumpType)
+	_ = binary.Write(b, binary.LittleEndian, p.Diagnostics)
 	return b.Bytes()
 }
 
@@ -139,7 +230,9 @@ func (p StopTracingPayload) Bytes() []byte {
 	return b
 }
 
-var enc = unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewEncoder()
+var (
+	enc = unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewEncoder()
+)
 
 func mustStringBytes(s string) []byte {
 	b := new(bytes.Buffer) // TODO pre-allocate
@@ -154,3 +247,20 @@ func mustStringBytes(s string) []byte {
 	_ = binary.Write(b, binary.LittleEndian, uint16(0))
 	return b.Bytes()
 }
+
+// readString reads a length-prefixed null-terminated UTF-16 string.
+func readString(r io.Reader) (string, error) {
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return "", err
+	}
+	if n == 0 {
+		return "", nil
+	}
+	// The decoder is not used here: it is not safe for concurrent use.
+	u := make([]uint16, n)
+	if err := binary.Read(r, binary.LittleEndian, u); err != nil {
+		return "", err
+	}
+	return string(utf16.Decode(u[:n-1])), nil
+}
@@ -0,0 +1,31 @@
+package dotnetdiag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestReadString(t *testing.T) {
+	const s = "/usr/bin/dotnet Приложение.dll 𝄞"
+	var b bytes.Buffer
+	u := append(utf16.Encode([]rune(s)), 0)
+	_ = binary.Write(&b, binary.LittleEndian, uint32(len(u)))
+	_ = binary.Write(&b, binary.LittleEndian, u)
+
+	// Strings are decoded concurrently by the clients.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			x, err := readString(bytes.NewReader(b.Bytes()))
+			if err != nil || x != s {
+				t.Errorf("expected %q, got %q, err: %v", s, x, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -4,5 +4,6 @@ go 1.16
 
 require (
 	github.com/Microsoft/go-winio v0.5.0
+	github.com/google/pprof v0.0.0-20210407192527-94a9f03dee38
 	golang.org/x/text v0.3.6
 )
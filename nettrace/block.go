@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"unsafe"
 )
@@ -20,6 +21,8 @@ type BlobBlock struct {
 	// block uses compressed headers format.
 	lastHeader    BlobHeader
 	extractHeader func(*Blob) error
+	// Format version 6 blocks are not versioned, see Decoder.readBlock.
+	version int32
 
 	p *Parser
 }
@@ -57,6 +60,9 @@ type BlobHeader struct {
 	ActivityID        [16]byte
 	RelatedActivityID [16]byte
 	PayloadSize       int32
+	// LabelListID refers to a LabelList, format version 6 and newer.
+	// In format version 6, ThreadID and CaptureThreadID are thread indexes.
+	LabelListID int32
 }
 
 type StackBlock struct {
@@ -85,12 +91,14 @@ type compressedHeaderFlag byte
 const (
 	flagMetadataID compressedHeaderFlag = 1 << iota
 	// Specifies that CaptureThreadID, CaptureProcNumber and SequenceNumber
-	// values are to be read from the stream: Incremented SequenceNumber should
-	// be added to the previous value. If the flag is not set, and MetadataID
+	// values are to be read from the stream: Incremented SequenceNumber delta
+	// should be added to the previous value. If the flag is not set, and MetadataID
 	// field is zero, incremented previous SequenceNumber should be used.
 	flagCaptureThreadAndSequence
 	flagThreadID
 	flagStackID
+	// Specifies ActivityID in format versions 4-5, and LabelListID
+	// in version 6.
 	flagActivityID
 	flagRelatedActivityID
 	// Specifies that the events are sorted.
@@ -104,7 +112,7 @@ func (b *Blob) IsSorted() bool { return b.sorted }
 
 func BlobBlockFromObject(o Object) (*BlobBlock, error) {
 	p := Parser{Buffer: o.Payload}
-	var b BlobBlock
+	b := BlobBlock{version: o.Version}
 	p.Read(&b.Header)
 	b.compressed = b.Header.Flags&0x0001 != 0
 	if b.compressed {
@@ -130,17 +138,66 @@ func (b *BlobBlock) Next(blob *Blob) error {
 	}
 	pb := b.Payload.Next(int(blob.Header.PayloadSize))
 	blob.Payload = bytes.NewBuffer(pb)
+	if !b.compressed {
+		// Uncompressed blobs are 4-byte aligned.
+		b.p.Skip(alignmentPadding(len(pb)))
+	}
 	return nil
 }
 
+// uncompressedBlobHeader is the layout of BlobHeader in blocks that do
+// not use compressed headers.
+type uncompressedBlobHeader struct {
+	// Size of the blob not counting this field and the padding.
+	Size              int32
+	MetadataID        int32
+	SequenceNumber    int32
+	ThreadID          int64
+	CaptureThreadID   int64
+	CaptureProcNumber int32
+	StackID           int32
+	TimeStamp         int64
+	ActivityID        [16]byte
+	RelatedActivityID [16]byte
+	PayloadSize       int32
+}
+
+// binary.Size(uncompressedBlobHeader{})
+const uncompressedBlobHeaderSize = 80
+
+func alignmentPadding(n int) int {
+	if r := n % 4; r != 0 {
+		return 4 - r
+	}
+	return 0
+}
+
 func (b *BlobBlock) readHeader(blob *Blob) error {
-	b.p.Read(blob)
+	var h uncompressedBlobHeader
+	b.p.Read(&h)
+	if err := b.p.Err(); err != nil {
+		return err
+	}
+	if h.Size != uncompressedBlobHeaderSize-4+h.PayloadSize {
+		return fmt.Errorf("invalid blob size %d: payload size %d", h.Size, h.PayloadSize)
+	}
 	// In the context of an EventBlock the low 31 bits are a foreign key to the
 	// event's metadata. In the context of a metadata block the low 31 bits are
 	// always zeroed. The high bit is the IsSorted flag.
-	blob.Header.MetadataID &= 0x7FFF
-	blob.sorted = uint32(blob.Header.MetadataID)&0x8000 == 0
-	return b.p.Err()
+	blob.sorted = uint32(h.MetadataID)&0x80000000 != 0
+	blob.Header = BlobHeader{
+		MetadataID:        h.MetadataID & 0x7FFFFFFF,
+		SequenceNumber:    h.SequenceNumber,
+		ThreadID:          h.ThreadID,
+		CaptureThreadID:   h.CaptureThreadID,
+		CaptureProcNumber: h.CaptureProcNumber,
+		StackID:           h.StackID,
+		TimeStamp:         h.TimeStamp,
+		ActivityID:        h.ActivityID,
+		RelatedActivityID: h.RelatedActivityID,
+		PayloadSize:       h.PayloadSize,
+	}
+	return nil
 }
 
 func (b *BlobBlock) readHeaderCompressed(blob *Blob) error {
@@ -152,7 +209,7 @@ func (b *BlobBlock) readHeaderCompressed(blob *Blob) error {
 		blob.Header.MetadataID = int32(b.p.Uvarint())
 	}
 	if flags&flagCaptureThreadAndSequence != 0 {
-		blob.Header.SequenceNumber = int32(b.p.Uvarint()) + 1
+		blob.Header.SequenceNumber += int32(b.p.Uvarint()) + 1
 		blob.Header.CaptureThreadID = int64(b.p.Uvarint())
 		blob.Header.CaptureProcNumber = int32(b.p.Uvarint())
 	} else if blob.Header.MetadataID != 0 {
@@ -165,7 +222,11 @@ func (b *BlobBlock) readHeaderCompressed(blob *Blob) error {
 		blob.Header.StackID = int32(b.p.Uvarint())
 	}
 	blob.Header.TimeStamp += int64(b.p.Uvarint())
-	if flags&flagActivityID != 0 {
+	switch {
+	case flags&flagActivityID == 0:
+	case b.version >= netTraceV6:
+		blob.Header.LabelListID = int32(b.p.Uvarint())
+	default:
 		b.p.Read(&blob.Header.ActivityID)
 	}
 	if flags&flagRelatedActivityID != 0 {
@@ -201,6 +262,16 @@ func SequencePointBlockFromObject(o Object) (*SequencePointBlock, error) {
 	p := Parser{Buffer: o.Payload}
 	p.Read(&b.TimeStamp)
 	p.Read(&count)
+	if o.Version >= netTraceV6 {
+		// Thread indexes and sequence numbers are variable-length.
+		for i := int32(0); i < count && p.Err() == nil; i++ {
+			b.Threads = append(b.Threads, Thread{
+				ThreadID:       int64(p.Uvarint()),
+				SequenceNumber: int32(p.Uvarint()),
+			})
+		}
+		return &b, p.Err()
+	}
 	b.Threads = make([]Thread, count)
 	for i := int32(0); i < count; i++ {
 		var t Thread
@@ -231,3 +302,66 @@ func address64(b []byte, i int) uint64 {
 func address32(b []byte, i int) uint64 {
 	return uint64(binary.LittleEndian.Uint32(b[i*4 : (i+1)*4]))
 }
+
+// legacyBlobHeader is the event header of NetPerf format (versions 1-3).
+// The header is followed by the payload, and the stack.
+type legacyBlobHeader struct {
+	// Size of the event not counting this field.
+	Size              int32
+	MetadataID        int32
+	ThreadID          int32
+	TimeStamp         int64
+	ActivityID        [16]byte
+	RelatedActivityID [16]byte
+	PayloadSize       int32
+}
+
+// legacyBlob is an event or metadata record of NetPerf format. Records
+// with zero MetadataID describe event metadata.
+type legacyBlob struct {
+	Blob
+	stack []byte
+}
+
+// legacyBlobsFromObject reads records of NetPerf EventBlock or
+// MetadataBlock, which has no block header.
+func legacyBlobsFromObject(o Object) ([]legacyBlob, error) {
+	var blobs []legacyBlob
+	p := Parser{Buffer: o.Payload}
+	for o.Payload.Len() > 0 {
+		var h legacyBlobHeader
+		p.Read(&h)
+		if err := p.Err(); err != nil {
+			return nil, err
+		}
+		// The rest of the record: the payload, the stack, and the padding
+		// to reach 4-byte alignment, if any.
+		n := int(h.Size) - (legacyBlobHeaderSize - 4)
+		if n < 0 || n > o.Payload.Len() {
+			return nil, io.ErrUnexpectedEOF
+		}
+		r := Parser{Buffer: bytes.NewBuffer(o.Payload.Next(n))}
+		b := legacyBlob{Blob: Blob{
+			Header: BlobHeader{
+				MetadataID:        h.MetadataID,
+				ThreadID:          int64(h.ThreadID),
+				TimeStamp:         h.TimeStamp,
+				ActivityID:        h.ActivityID,
+				RelatedActivityID: h.RelatedActivityID,
+				PayloadSize:       h.PayloadSize,
+			},
+			Payload: bytes.NewBuffer(r.Next(int(h.PayloadSize))),
+		}}
+		var stackSize int32
+		r.Read(&stackSize)
+		b.stack = r.Next(int(stackSize))
+		if err := r.Err(); err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, b)
+	}
+	return blobs, nil
+}
+
+// binary.Size(legacyBlobHeader{})
+const legacyBlobHeaderSize = 56
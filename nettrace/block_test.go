@@ -0,0 +1,53 @@
+package nettrace_test
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+)
+
+func TestBlobHeaderDecoding(t *testing.T) {
+	for _, sample := range []string{
+		"testdata/dotnet-5.0-SampleProfiler-webapp.golden.nettrace",
+		"testdata/dotnet-5.0-SampleProfiler-single-thread.golden.nettrace",
+	} {
+		t.Run(sample, func(t *testing.T) {
+			f, err := os.Open(sample)
+			requireNoError(t, err)
+			defer f.Close()
+			stream := nettrace.NewStream(f)
+			_, err = stream.Open()
+			requireNoError(t, err)
+			md := make(map[int32]bool)
+			stream.MetadataHandler = func(m *nettrace.Metadata) error {
+				md[m.Header.MetaDataID] = true
+				return nil
+			}
+			// The runtime does not drop events in the samples: sequence
+			// numbers of every capture thread must be consecutive.
+			last := make(map[int64]int32)
+			stream.EventHandler = func(blob *nettrace.Blob) error {
+				h := blob.Header
+				if !md[h.MetadataID] {
+					t.Fatalf("unknown metadata ID %d", h.MetadataID)
+				}
+				if prev, ok := last[h.CaptureThreadID]; ok && h.SequenceNumber != prev+1 {
+					t.Fatalf("thread %d: sequence number %d follows %d", h.CaptureThreadID, h.SequenceNumber, prev)
+				}
+				last[h.CaptureThreadID] = h.SequenceNumber
+				return nil
+			}
+			for {
+				if err = stream.Next(); err == io.EOF {
+					break
+				}
+				requireNoError(t, err)
+			}
+			if len(last) == 0 {
+				t.Fatal("no events decoded")
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package nettrace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sort"
+)
+
+// Builder constructs synthetic NetTrace streams: events, metadata and
+// stacks are collected in memory and written with a single WriteTo call.
+type Builder struct {
+	Trace Trace
+	// Compressed specifies whether blob headers are compressed.
+	Compressed bool
+
+	metadata []*Metadata
+	stacks   []Stack
+	events   []Blob
+	// Capture thread ID -> last sequence number.
+	sequences map[int64]int32
+}
+
+// NewBuilder creates a Builder of a 64-bit process trace.
+func NewBuilder() *Builder {
+	return &Builder{
+		Trace: Trace{
+			PointerSize:        8,
+			NumberOfProcessors: 1,
+			QPCFrequency:       1e7,
+		},
+		sequences: make(map[int64]int32),
+	}
+}
+
+// Metadata adds the event metadata and returns its ID.
+func (b *Builder) Metadata(provider string, eventID int32, name string, fields ...MetadataField) int32 {
+	id := int32(len(b.metadata) + 1)
+	b.metadata = append(b.metadata, &Metadata{
+		Header: MetadataHeader{
+			MetaDataID:   id,
+			ProviderName: provider,
+			EventID:      eventID,
+			EventName:    name,
+		},
+		Payload: MetadataPayload{Fields: fields},
+	})
+	return id
+}
+
+// Stack adds the stack of instruction pointers and returns its ID.
+// The stack is serialized according to the trace pointer size.
+func (b *Builder) Stack(ips ...uint64) int32 {
+	var buf bytes.Buffer
+	for _, ip := range ips {
+		if b.Trace.PointerSize == 4 {
+			_ = binary.Write(&buf, binary.LittleEndian, uint32(ip))
+		} else {
+			_ = binary.Write(&buf, binary.LittleEndian, ip)
+		}
+	}
+	id := int32(len(b.stacks) + 1)
+	b.stacks = append(b.stacks, Stack{ID: id, Data: buf.Bytes()})
+	return id
+}
+
+// Event adds the event. If the header does not specify the capture thread,
+// the event thread is used. If the sequence number is not specified, the
+// next number of the capture thread is assigned.
+func (b *Builder) Event(h BlobHeader, payload []byte) {
+	if h.CaptureThreadID == 0 {
+		h.CaptureThreadID = h.ThreadID
+	}
+	if h.SequenceNumber == 0 {
+		h.SequenceNumber = b.sequences[h.CaptureThreadID] + 1
+	}
+	b.sequences[h.CaptureThreadID] = h.SequenceNumber
+	b.events = append(b.events, Blob{
+		Header:  h,
+		Payload: bytes.NewBuffer(payload),
+	})
+}
+
+// WriteTo writes the trace: the metadata, stack and event blocks are
+// followed by a sequence point block that specifies the last sequence
+// number of every capture thread.
+func (b *Builder) WriteTo(w io.Writer) (int64, error) {
+	cw := countingWriter{w: w}
+	e := NewEncoder(&cw)
+	if err := e.EncodeTrace(&b.Trace); err != nil {
+		return cw.n, err
+	}
+	if len(b.metadata) > 0 {
+		if err := e.EncodeMetadataBlock(b.metadata, b.Compressed); err != nil {
+			return cw.n, err
+		}
+	}
+	if len(b.stacks) > 0 {
+		if err := e.EncodeStackBlock(&StackBlock{Stacks: b.stacks}); err != nil {
+			return cw.n, err
+		}
+	}
+	if len(b.events) > 0 {
+		if err := e.EncodeEventBlock(b.events, b.Compressed); err != nil {
+			return cw.n, err
+		}
+	}
+	sp := SequencePointBlock{Threads: make([]Thread, 0, len(b.sequences))}
+	for _, blob := range b.events {
+		if blob.Header.TimeStamp > sp.TimeStamp {
+			sp.TimeStamp = blob.Header.TimeStamp
+		}
+	}
+	for id, seq := range b.sequences {
+		sp.Threads = append(sp.Threads, Thread{ThreadID: id, SequenceNumber: seq})
+	}
+	sort.Slice(sp.Threads, func(i, j int) bool {
+		return sp.Threads[i].ThreadID < sp.Threads[j].ThreadID
+	})
+	if err := e.EncodeSequencePointBlock(&sp); err != nil {
+		return cw.n, err
+	}
+	err := e.Close()
+	return cw.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,103 @@
+// Package clr provides payload types of the .NET runtime events. The types
+// and event IDs are generated from the CLR ETW manifest, ClrEtwAll.man: the
+// vendored copy is a subset of the upstream manifest, add events to it and
+// run go generate to extend the package.
+package clr
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+	"github.com/pyroscope-io/dotnetdiag/nettrace/typecode"
+)
+
+//go:generate go run ./internal/clrgen -o events.go -provider Microsoft-Windows-DotNETRuntime=Runtime -provider Microsoft-Windows-DotNETRuntimeRundown=Rundown testdata/ClrEtwAll.man
+
+type eventKey struct {
+	provider string
+	id       int32
+	version  int32
+}
+
+// Parse decodes the event payload into the type generated for the event
+// version; pointers are read according to the trace pointer size. If the
+// version is not known, the latest preceding one is used: later versions
+// append fields. If the event is not known, ok is false.
+func Parse(t *nettrace.Trace, md *nettrace.Metadata, blob *nettrace.Blob) (v interface{}, ok bool, err error) {
+	newPayload, ok := payloadFor(md)
+	if !ok {
+		return nil, false, nil
+	}
+	v = newPayload()
+	if err = t.Unmarshal(blob, v); err != nil {
+		return nil, true, err
+	}
+	return v, true, nil
+}
+
+func payloadFor(md *nettrace.Metadata) (func() interface{}, bool) {
+	h := md.Header
+	for version := int32(h.Version); version >= 0; version-- {
+		if newPayload, ok := payloads[eventKey{h.ProviderName, h.EventID, version}]; ok {
+			return newPayload, true
+		}
+	}
+	return nil, false
+}
+
+// Fields returns field definitions of the event payload: the runtime does
+// not include them in the event metadata. Like Parse, Fields falls back to
+// the latest preceding event version. If the event is not known, or the
+// payload contains arrays which length is specified by another field, ok
+// is false. Pointers are described as UInt64: the definitions only apply
+// to traces of 64-bit processes.
+func Fields(md *nettrace.Metadata) (fields []nettrace.MetadataField, ok bool) {
+	newPayload, ok := payloadFor(md)
+	if !ok {
+		return nil, false
+	}
+	return structFields(reflect.TypeOf(newPayload()).Elem())
+}
+
+var typeCodes = map[reflect.Kind]typecode.TypeCode{
+	reflect.String:  typecode.String,
+	reflect.Bool:    typecode.Boolean,
+	reflect.Int8:    typecode.SByte,
+	reflect.Uint8:   typecode.Byte,
+	reflect.Int16:   typecode.Int16,
+	reflect.Uint16:  typecode.UInt16,
+	reflect.Int32:   typecode.Int32,
+	reflect.Uint32:  typecode.UInt32,
+	reflect.Int64:   typecode.Int64,
+	reflect.Uint64:  typecode.UInt64,
+	reflect.Float32: typecode.Single,
+	reflect.Float64: typecode.Double,
+}
+
+func structFields(t reflect.Type) ([]nettrace.MetadataField, bool) {
+	fields := make([]nettrace.MetadataField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		f := nettrace.MetadataField{Name: sf.Name}
+		switch {
+		case sf.Type == reflect.TypeOf(time.Time{}):
+			f.TypeCode = typecode.DateTime
+		case sf.Type == reflect.TypeOf([16]byte{}):
+			f.TypeCode = typecode.Guid
+		case sf.Type.Kind() == reflect.Struct:
+			var ok bool
+			if f.Payload.Fields, ok = structFields(sf.Type); !ok {
+				return nil, false
+			}
+			f.TypeCode = typecode.Object
+		default:
+			var ok bool
+			if f.TypeCode, ok = typeCodes[sf.Type.Kind()]; !ok {
+				return nil, false
+			}
+		}
+		fields = append(fields, f)
+	}
+	return fields, true
+}
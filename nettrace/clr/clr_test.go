@@ -0,0 +1,235 @@
+package clr_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+	"github.com/pyroscope-io/dotnetdiag/nettrace/clr"
+)
+
+func TestParse(t *testing.T) {
+	f, err := os.Open("../testdata/dotnet-5.0-SampleProfiler-single-thread.golden.nettrace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stream := nettrace.NewStream(f)
+	trace, err := stream.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := make(map[int32]*nettrace.Metadata)
+	stream.MetadataHandler = func(m *nettrace.Metadata) error {
+		md[m.Header.MetaDataID] = m
+		return nil
+	}
+	var (
+		info    *clr.RuntimeInformationRundown
+		methods int
+		modules int
+	)
+	stream.EventHandler = func(e *nettrace.Blob) error {
+		v, ok, err := clr.Parse(trace, md[e.Header.MetadataID], e)
+		if err != nil || !ok {
+			return err
+		}
+		switch x := v.(type) {
+		case *clr.RuntimeInformationRundown:
+			info = x
+		case *clr.MethodLoadUnloadRundownVerboseV1:
+			if x.MethodName == "" {
+				t.Errorf("invalid method: %+v", x)
+			}
+			methods++
+		case *clr.DomainModuleLoadUnloadRundownV1:
+			if !strings.HasSuffix(x.ModuleILPath, ".dll") {
+				t.Errorf("invalid module: %+v", x)
+			}
+			modules++
+		}
+		return nil
+	}
+	for {
+		err = stream.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if info == nil || info.VMMajorVersion != 5 || !strings.Contains(info.RuntimeDllPath, "coreclr") {
+		t.Fatalf("unexpected runtime information: %+v", info)
+	}
+	if methods == 0 || modules == 0 {
+		t.Fatalf("methods: %d, modules: %d", methods, modules)
+	}
+}
+
+func TestRedactRundown(t *testing.T) {
+	f, err := os.Open("../testdata/dotnet-5.0-SampleProfiler-single-thread.golden.nettrace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var b bytes.Buffer
+	err = nettrace.Rewrite(&b, f,
+		nettrace.RewriteMetadataFields(clr.Fields),
+		nettrace.RewriteRedact([]byte("key"), nettrace.DefaultRedactRules...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream := nettrace.NewStream(&b)
+	trace, err := stream.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := make(map[int32]*nettrace.Metadata)
+	stream.MetadataHandler = func(m *nettrace.Metadata) error {
+		md[m.Header.MetaDataID] = m
+		return nil
+	}
+	var methods, modules int
+	stream.EventHandler = func(e *nettrace.Blob) error {
+		v, ok, err := clr.Parse(trace, md[e.Header.MetadataID], e)
+		if err != nil || !ok {
+			return err
+		}
+		switch x := v.(type) {
+		case *clr.RuntimeInformationRundown:
+			if strings.Contains(x.RuntimeDllPath, "coreclr") {
+				t.Errorf("runtime path is not redacted: %s", x.RuntimeDllPath)
+			}
+		case *clr.MethodLoadUnloadRundownVerboseV1:
+			if x.MethodName == "" {
+				t.Errorf("invalid method: %+v", x)
+			}
+			methods++
+		case *clr.DomainModuleLoadUnloadRundownV1:
+			if len(x.ModuleILPath) != 16 || strings.Contains(x.ModuleILPath, ".dll") {
+				t.Errorf("module path is not redacted: %s", x.ModuleILPath)
+			}
+			modules++
+		}
+		return nil
+	}
+	for {
+		err = stream.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if methods == 0 || modules == 0 {
+		t.Fatalf("methods: %d, modules: %d", methods, modules)
+	}
+}
+
+func TestParseVersions(t *testing.T) {
+	runtime := map[int32][]interface{}{
+		clr.RuntimeExceptionThrown:      {nil, new(clr.Exception)},
+		clr.RuntimeMethodLoad:           {new(clr.MethodLoadUnload), new(clr.MethodLoadUnloadV1), new(clr.MethodLoadUnloadV2)},
+		clr.RuntimeMethodUnload:         {new(clr.MethodLoadUnload), new(clr.MethodLoadUnloadV1), new(clr.MethodLoadUnloadV2)},
+		clr.RuntimeMethodLoadVerbose:    {new(clr.MethodLoadUnloadVerbose), new(clr.MethodLoadUnloadVerboseV1), new(clr.MethodLoadUnloadVerboseV2)},
+		clr.RuntimeMethodUnloadVerbose:  {new(clr.MethodLoadUnloadVerbose), new(clr.MethodLoadUnloadVerboseV1), new(clr.MethodLoadUnloadVerboseV2)},
+		clr.RuntimeMethodJittingStarted: {new(clr.MethodJittingStarted), new(clr.MethodJittingStartedV1)},
+		clr.RuntimeDomainModuleLoad:     {new(clr.DomainModuleLoadUnload), new(clr.DomainModuleLoadUnloadV1)},
+		clr.RuntimeModuleLoad:           {new(clr.ModuleLoadUnload), new(clr.ModuleLoadUnloadV1), new(clr.ModuleLoadUnloadV2)},
+		clr.RuntimeModuleUnload:         {new(clr.ModuleLoadUnload), new(clr.ModuleLoadUnloadV1), new(clr.ModuleLoadUnloadV2)},
+		clr.RuntimeAssemblyLoad:         {new(clr.AssemblyLoadUnload), new(clr.AssemblyLoadUnloadV1)},
+		clr.RuntimeAssemblyUnload:       {new(clr.AssemblyLoadUnload), new(clr.AssemblyLoadUnloadV1)},
+		clr.RuntimeAppDomainLoad:        {new(clr.AppDomainLoadUnload), new(clr.AppDomainLoadUnloadV1)},
+		clr.RuntimeAppDomainUnload:      {new(clr.AppDomainLoadUnload), new(clr.AppDomainLoadUnloadV1)},
+	}
+	rundown := map[int32][]interface{}{
+		clr.RundownMethodDCStart:             {new(clr.MethodLoadUnloadRundown), new(clr.MethodLoadUnloadRundownV1), new(clr.MethodLoadUnloadRundownV2)},
+		clr.RundownMethodDCEnd:               {new(clr.MethodLoadUnloadRundown), new(clr.MethodLoadUnloadRundownV1), new(clr.MethodLoadUnloadRundownV2)},
+		clr.RundownMethodDCStartVerbose:      {new(clr.MethodLoadUnloadRundownVerbose), new(clr.MethodLoadUnloadRundownVerboseV1), new(clr.MethodLoadUnloadRundownVerboseV2)},
+		clr.RundownMethodDCEndVerbose:        {new(clr.MethodLoadUnloadRundownVerbose), new(clr.MethodLoadUnloadRundownVerboseV1), new(clr.MethodLoadUnloadRundownVerboseV2)},
+		clr.RundownDCStartComplete:           {nil, new(clr.DCStartEnd)},
+		clr.RundownDCEndComplete:             {nil, new(clr.DCStartEnd)},
+		clr.RundownDomainModuleDCStart:       {new(clr.DomainModuleLoadUnloadRundown), new(clr.DomainModuleLoadUnloadRundownV1)},
+		clr.RundownDomainModuleDCEnd:         {new(clr.DomainModuleLoadUnloadRundown), new(clr.DomainModuleLoadUnloadRundownV1)},
+		clr.RundownModuleDCStart:             {new(clr.ModuleLoadUnloadRundown), new(clr.ModuleLoadUnloadRundownV1), new(clr.ModuleLoadUnloadRundownV2)},
+		clr.RundownModuleDCEnd:               {new(clr.ModuleLoadUnloadRundown), new(clr.ModuleLoadUnloadRundownV1), new(clr.ModuleLoadUnloadRundownV2)},
+		clr.RundownAssemblyDCStart:           {new(clr.AssemblyLoadUnloadRundown), new(clr.AssemblyLoadUnloadRundownV1)},
+		clr.RundownAssemblyDCEnd:             {new(clr.AssemblyLoadUnloadRundown), new(clr.AssemblyLoadUnloadRundownV1)},
+		clr.RundownAppDomainDCStart:          {new(clr.AppDomainLoadUnloadRundown), new(clr.AppDomainLoadUnloadRundownV1)},
+		clr.RundownAppDomainDCEnd:            {new(clr.AppDomainLoadUnloadRundown), new(clr.AppDomainLoadUnloadRundownV1)},
+		clr.RundownRuntimeInformationDCStart: {new(clr.RuntimeInformationRundown)},
+	}
+	trace := nettrace.Trace{PointerSize: 8}
+	for provider, events := range map[string]map[int32][]interface{}{
+		clr.RuntimeProvider: runtime,
+		clr.RundownProvider: rundown,
+	} {
+		for id, types := range events {
+			for version, expected := range types {
+				md := nettrace.Metadata{Header: nettrace.MetadataHeader{
+					ProviderName: provider,
+					EventID:      id,
+					Version:      nettrace.MetadataVersion(version),
+				}}
+				// Zero integers and empty strings.
+				blob := nettrace.Blob{Payload: bytes.NewBuffer(make([]byte, 256))}
+				v, ok, err := clr.Parse(&trace, &md, &blob)
+				if err != nil {
+					t.Fatalf("%s %d version %d: %v", provider, id, version, err)
+				}
+				if expected == nil {
+					if ok {
+						t.Fatalf("%s %d version %d: unexpected payload %T", provider, id, version, v)
+					}
+					continue
+				}
+				if reflect.TypeOf(v) != reflect.TypeOf(expected) {
+					t.Fatalf("%s %d version %d: expected %T, got %T", provider, id, version, expected, v)
+				}
+			}
+		}
+	}
+}
+
+func TestParsePointers(t *testing.T) {
+	md := nettrace.Metadata{Header: nettrace.MetadataHeader{
+		ProviderName: clr.RuntimeProvider,
+		EventID:      clr.RuntimeCLRStackWalk,
+	}}
+	var b bytes.Buffer
+	for _, v := range []interface{}{uint16(1), [2]uint8{}, uint32(2), []uint32{0x10, 0x20}} {
+		_ = binary.Write(&b, binary.LittleEndian, v)
+	}
+	for _, x := range []struct {
+		size  int32
+		stack []uint64
+	}{
+		{4, []uint64{0x10, 0x20}},
+		{8, nil},
+	} {
+		trace := nettrace.Trace{PointerSize: x.size}
+		v, ok, err := clr.Parse(&trace, &md, &nettrace.Blob{Payload: bytes.NewBuffer(b.Bytes())})
+		if x.stack == nil {
+			// Two 4 byte pointers can not be read as 8 byte ones.
+			if !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+			}
+			continue
+		}
+		if err != nil || !ok {
+			t.Fatalf("unexpected result: %v, %v", ok, err)
+		}
+		if s := v.(*clr.ClrStackWalk); !reflect.DeepEqual(s.Stack, x.stack) {
+			t.Fatalf("expected stack %x, got %x", x.stack, s.Stack)
+		}
+	}
+}
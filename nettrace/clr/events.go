@@ -0,0 +1,566 @@
+// Code generated by clrgen from testdata/ClrEtwAll.man. DO NOT EDIT.
+
+package clr
+
+// Provider names.
+const (
+	RuntimeProvider = "Microsoft-Windows-DotNETRuntime"
+	RundownProvider = "Microsoft-Windows-DotNETRuntimeRundown"
+)
+
+// Microsoft-Windows-DotNETRuntime event IDs.
+const (
+	RuntimeGCStart              = 1
+	RuntimeGCEnd                = 2
+	RuntimeExceptionThrown      = 80
+	RuntimeCLRStackWalk         = 82
+	RuntimeMethodLoad           = 141
+	RuntimeMethodUnload         = 142
+	RuntimeMethodLoadVerbose    = 143
+	RuntimeMethodUnloadVerbose  = 144
+	RuntimeMethodJittingStarted = 145
+	RuntimeDomainModuleLoad     = 151
+	RuntimeModuleLoad           = 152
+	RuntimeModuleUnload         = 153
+	RuntimeAssemblyLoad         = 154
+	RuntimeAssemblyUnload       = 155
+	RuntimeAppDomainLoad        = 156
+	RuntimeAppDomainUnload      = 157
+)
+
+// Microsoft-Windows-DotNETRuntimeRundown event IDs.
+const (
+	RundownMethodDCStart             = 141
+	RundownMethodDCEnd               = 142
+	RundownMethodDCStartVerbose      = 143
+	RundownMethodDCEndVerbose        = 144
+	RundownDCStartComplete           = 145
+	RundownDCEndComplete             = 146
+	RundownDomainModuleDCStart       = 151
+	RundownDomainModuleDCEnd         = 152
+	RundownModuleDCStart             = 153
+	RundownModuleDCEnd               = 154
+	RundownAssemblyDCStart           = 155
+	RundownAssemblyDCEnd             = 156
+	RundownAppDomainDCStart          = 157
+	RundownAppDomainDCEnd            = 158
+	RundownRuntimeInformationDCStart = 187
+)
+
+// Event payload constructors by provider, event ID and version.
+var payloads = map[eventKey]func() interface{}{
+	{RuntimeProvider, 1, 0}:   func() interface{} { return new(GCStart) },
+	{RuntimeProvider, 1, 1}:   func() interface{} { return new(GCStartV1) },
+	{RuntimeProvider, 1, 2}:   func() interface{} { return new(GCStartV2) },
+	{RuntimeProvider, 2, 0}:   func() interface{} { return new(GCEnd) },
+	{RuntimeProvider, 2, 1}:   func() interface{} { return new(GCEndV1) },
+	{RuntimeProvider, 80, 1}:  func() interface{} { return new(Exception) },
+	{RuntimeProvider, 82, 0}:  func() interface{} { return new(ClrStackWalk) },
+	{RuntimeProvider, 141, 0}: func() interface{} { return new(MethodLoadUnload) },
+	{RuntimeProvider, 141, 1}: func() interface{} { return new(MethodLoadUnloadV1) },
+	{RuntimeProvider, 141, 2}: func() interface{} { return new(MethodLoadUnloadV2) },
+	{RuntimeProvider, 142, 0}: func() interface{} { return new(MethodLoadUnload) },
+	{RuntimeProvider, 142, 1}: func() interface{} { return new(MethodLoadUnloadV1) },
+	{RuntimeProvider, 142, 2}: func() interface{} { return new(MethodLoadUnloadV2) },
+	{RuntimeProvider, 143, 0}: func() interface{} { return new(MethodLoadUnloadVerbose) },
+	{RuntimeProvider, 143, 1}: func() interface{} { return new(MethodLoadUnloadVerboseV1) },
+	{RuntimeProvider, 143, 2}: func() interface{} { return new(MethodLoadUnloadVerboseV2) },
+	{RuntimeProvider, 144, 0}: func() interface{} { return new(MethodLoadUnloadVerbose) },
+	{RuntimeProvider, 144, 1}: func() interface{} { return new(MethodLoadUnloadVerboseV1) },
+	{RuntimeProvider, 144, 2}: func() interface{} { return new(MethodLoadUnloadVerboseV2) },
+	{RuntimeProvider, 145, 0}: func() interface{} { return new(MethodJittingStarted) },
+	{RuntimeProvider, 145, 1}: func() interface{} { return new(MethodJittingStartedV1) },
+	{RuntimeProvider, 151, 0}: func() interface{} { return new(DomainModuleLoadUnload) },
+	{RuntimeProvider, 151, 1}: func() interface{} { return new(DomainModuleLoadUnloadV1) },
+	{RuntimeProvider, 152, 0}: func() interface{} { return new(ModuleLoadUnload) },
+	{RuntimeProvider, 152, 1}: func() interface{} { return new(ModuleLoadUnloadV1) },
+	{RuntimeProvider, 152, 2}: func() interface{} { return new(ModuleLoadUnloadV2) },
+	{RuntimeProvider, 153, 0}: func() interface{} { return new(ModuleLoadUnload) },
+	{RuntimeProvider, 153, 1}: func() interface{} { return new(ModuleLoadUnloadV1) },
+	{RuntimeProvider, 153, 2}: func() interface{} { return new(ModuleLoadUnloadV2) },
+	{RuntimeProvider, 154, 0}: func() interface{} { return new(AssemblyLoadUnload) },
+	{RuntimeProvider, 154, 1}: func() interface{} { return new(AssemblyLoadUnloadV1) },
+	{RuntimeProvider, 155, 0}: func() interface{} { return new(AssemblyLoadUnload) },
+	{RuntimeProvider, 155, 1}: func() interface{} { return new(AssemblyLoadUnloadV1) },
+	{RuntimeProvider, 156, 0}: func() interface{} { return new(AppDomainLoadUnload) },
+	{RuntimeProvider, 156, 1}: func() interface{} { return new(AppDomainLoadUnloadV1) },
+	{RuntimeProvider, 157, 0}: func() interface{} { return new(AppDomainLoadUnload) },
+	{RuntimeProvider, 157, 1}: func() interface{} { return new(AppDomainLoadUnloadV1) },
+	{RundownProvider, 141, 0}: func() interface{} { return new(MethodLoadUnloadRundown) },
+	{RundownProvider, 141, 1}: func() interface{} { return new(MethodLoadUnloadRundownV1) },
+	{RundownProvider, 141, 2}: func() interface{} { return new(MethodLoadUnloadRundownV2) },
+	{RundownProvider, 142, 0}: func() interface{} { return new(MethodLoadUnloadRundown) },
+	{RundownProvider, 142, 1}: func() interface{} { return new(MethodLoadUnloadRundownV1) },
+	{RundownProvider, 142, 2}: func() interface{} { return new(MethodLoadUnloadRundownV2) },
+	{RundownProvider, 143, 0}: func() interface{} { return new(MethodLoadUnloadRundownVerbose) },
+	{RundownProvider, 143, 1}: func() interface{} { return new(MethodLoadUnloadRundownVerboseV1) },
+	{RundownProvider, 143, 2}: func() interface{} { return new(MethodLoadUnloadRundownVerboseV2) },
+	{RundownProvider, 144, 0}: func() interface{} { return new(MethodLoadUnloadRundownVerbose) },
+	{RundownProvider, 144, 1}: func() interface{} { return new(MethodLoadUnloadRundownVerboseV1) },
+	{RundownProvider, 144, 2}: func() interface{} { return new(MethodLoadUnloadRundownVerboseV2) },
+	{RundownProvider, 145, 1}: func() interface{} { return new(DCStartEnd) },
+	{RundownProvider, 146, 1}: func() interface{} { return new(DCStartEnd) },
+	{RundownProvider, 151, 0}: func() interface{} { return new(DomainModuleLoadUnloadRundown) },
+	{RundownProvider, 151, 1}: func() interface{} { return new(DomainModuleLoadUnloadRundownV1) },
+	{RundownProvider, 152, 0}: func() interface{} { return new(DomainModuleLoadUnloadRundown) },
+	{RundownProvider, 152, 1}: func() interface{} { return new(DomainModuleLoadUnloadRundownV1) },
+	{RundownProvider, 153, 0}: func() interface{} { return new(ModuleLoadUnloadRundown) },
+	{RundownProvider, 153, 1}: func() interface{} { return new(ModuleLoadUnloadRundownV1) },
+	{RundownProvider, 153, 2}: func() interface{} { return new(ModuleLoadUnloadRundownV2) },
+	{RundownProvider, 154, 0}: func() interface{} { return new(ModuleLoadUnloadRundown) },
+	{RundownProvider, 154, 1}: func() interface{} { return new(ModuleLoadUnloadRundownV1) },
+	{RundownProvider, 154, 2}: func() interface{} { return new(ModuleLoadUnloadRundownV2) },
+	{RundownProvider, 155, 0}: func() interface{} { return new(AssemblyLoadUnloadRundown) },
+	{RundownProvider, 155, 1}: func() interface{} { return new(AssemblyLoadUnloadRundownV1) },
+	{RundownProvider, 156, 0}: func() interface{} { return new(AssemblyLoadUnloadRundown) },
+	{RundownProvider, 156, 1}: func() interface{} { return new(AssemblyLoadUnloadRundownV1) },
+	{RundownProvider, 157, 0}: func() interface{} { return new(AppDomainLoadUnloadRundown) },
+	{RundownProvider, 157, 1}: func() interface{} { return new(AppDomainLoadUnloadRundownV1) },
+	{RundownProvider, 158, 0}: func() interface{} { return new(AppDomainLoadUnloadRundown) },
+	{RundownProvider, 158, 1}: func() interface{} { return new(AppDomainLoadUnloadRundownV1) },
+	{RundownProvider, 187, 0}: func() interface{} { return new(RuntimeInformationRundown) },
+}
+
+// GCStart is the payload of GCStart event.
+type GCStart struct {
+	Count  uint32
+	Reason uint32
+}
+
+// GCStartV1 is the payload of GCStart_V1 event.
+type GCStartV1 struct {
+	Count         uint32
+	Depth         uint32
+	Reason        uint32
+	Type          uint32
+	ClrInstanceID uint16
+}
+
+// GCStartV2 is the payload of GCStart_V2 event.
+type GCStartV2 struct {
+	Count                uint32
+	Depth                uint32
+	Reason               uint32
+	Type                 uint32
+	ClrInstanceID        uint16
+	ClientSequenceNumber uint64
+}
+
+// GCEnd is the payload of GCEnd event.
+type GCEnd struct {
+	Count uint32
+	Depth uint16
+}
+
+// GCEndV1 is the payload of GCEnd_V1 event.
+type GCEndV1 struct {
+	Count         uint32
+	Depth         uint32
+	ClrInstanceID uint16
+}
+
+// Exception is the payload of ExceptionThrown_V1 event.
+type Exception struct {
+	ExceptionType    string
+	ExceptionMessage string
+	ExceptionEIP     uint64 `nettrace:"pointer"`
+	ExceptionHRESULT uint32
+	ExceptionFlags   uint16
+	ClrInstanceID    uint16
+}
+
+// ClrStackWalk is the payload of CLRStackWalk event.
+type ClrStackWalk struct {
+	ClrInstanceID uint16
+	Reserved1     uint8
+	Reserved2     uint8
+	FrameCount    uint32
+	Stack         []uint64 `nettrace:"count=FrameCount,pointer"`
+}
+
+// MethodLoadUnload is the payload of MethodLoad, MethodUnload events.
+type MethodLoadUnload struct {
+	MethodID           uint64
+	ModuleID           uint64
+	MethodStartAddress uint64
+	MethodSize         uint32
+	MethodToken        uint32
+	MethodFlags        uint32
+}
+
+// MethodLoadUnloadV1 is the payload of MethodLoad_V1, MethodUnload_V1 events.
+type MethodLoadUnloadV1 struct {
+	MethodID           uint64
+	ModuleID           uint64
+	MethodStartAddress uint64
+	MethodSize         uint32
+	MethodToken        uint32
+	MethodFlags        uint32
+	ClrInstanceID      uint16
+}
+
+// MethodLoadUnloadV2 is the payload of MethodLoad_V2, MethodUnload_V2 events.
+type MethodLoadUnloadV2 struct {
+	MethodID           uint64
+	ModuleID           uint64
+	MethodStartAddress uint64
+	MethodSize         uint32
+	MethodToken        uint32
+	MethodFlags        uint32
+	ClrInstanceID      uint16
+	ReJITID            uint64
+}
+
+// MethodJittingStarted is the payload of MethodJittingStarted event.
+type MethodJittingStarted struct {
+	MethodID        uint64
+	ModuleID        uint64
+	MethodToken     uint32
+	MethodILSize    uint32
+	MethodNamespace string
+	MethodName      string
+	MethodSignature string
+}
+
+// MethodJittingStartedV1 is the payload of MethodJittingStarted_V1 event.
+type MethodJittingStartedV1 struct {
+	MethodID        uint64
+	ModuleID        uint64
+	MethodToken     uint32
+	MethodILSize    uint32
+	MethodNamespace string
+	MethodName      string
+	MethodSignature string
+	ClrInstanceID   uint16
+}
+
+// AssemblyLoadUnload is the payload of AssemblyLoad, AssemblyUnload events.
+type AssemblyLoadUnload struct {
+	AssemblyID                 uint64
+	AppDomainID                uint64
+	AssemblyFlags              uint32
+	FullyQualifiedAssemblyName string
+}
+
+// AssemblyLoadUnloadV1 is the payload of AssemblyLoad_V1, AssemblyUnload_V1 events.
+type AssemblyLoadUnloadV1 struct {
+	AssemblyID                 uint64
+	AppDomainID                uint64
+	BindingID                  uint64
+	AssemblyFlags              uint32
+	FullyQualifiedAssemblyName string
+	ClrInstanceID              uint16
+}
+
+// AppDomainLoadUnload is the payload of AppDomainLoad, AppDomainUnload events.
+type AppDomainLoadUnload struct {
+	AppDomainID    uint64
+	AppDomainFlags uint32
+	AppDomainName  string
+}
+
+// AppDomainLoadUnloadV1 is the payload of AppDomainLoad_V1, AppDomainUnload_V1 events.
+type AppDomainLoadUnloadV1 struct {
+	AppDomainID    uint64
+	AppDomainFlags uint32
+	AppDomainName  string
+	AppDomainIndex uint32
+	ClrInstanceID  uint16
+}
+
+// MethodLoadUnloadVerbose is the payload of MethodLoadVerbose, MethodUnloadVerbose events.
+type MethodLoadUnloadVerbose struct {
+	MethodID           uint64
+	ModuleID           uint64
+	MethodStartAddress uint64
+	MethodSize         uint32
+	MethodToken        uint32
+	MethodFlags        uint32
+	MethodNamespace    string
+	MethodName         string
+	MethodSignature    string
+}
+
+// MethodLoadUnloadVerboseV1 is the payload of MethodLoadVerbose_V1, MethodUnloadVerbose_V1 events.
+type MethodLoadUnloadVerboseV1 struct {
+	MethodID           uint64
+	ModuleID           uint64
+	MethodStartAddress uint64
+	MethodSize         uint32
+	MethodToken        uint32
+	MethodFlags        uint32
+	MethodNamespace    string
+	MethodName         string
+	MethodSignature    string
+	ClrInstanceID      uint16
+}
+
+// MethodLoadUnloadVerboseV2 is the payload of MethodLoadVerbose_V2, MethodUnloadVerbose_V2 events.
+type MethodLoadUnloadVerboseV2 struct {
+	MethodID           uint64
+	ModuleID           uint64
+	MethodStartAddress uint64
+	MethodSize         uint32
+	MethodToken        uint32
+	MethodFlags        uint32
+	MethodNamespace    string
+	MethodName         string
+	MethodSignature    string
+	ClrInstanceID      uint16
+	ReJITID            uint64
+}
+
+// DomainModuleLoadUnload is the payload of DomainModuleLoad event.
+type DomainModuleLoadUnload struct {
+	ModuleID         uint64
+	AssemblyID       uint64
+	AppDomainID      uint64
+	ModuleFlags      uint32
+	Reserved1        uint32
+	ModuleILPath     string
+	ModuleNativePath string
+}
+
+// DomainModuleLoadUnloadV1 is the payload of DomainModuleLoad_V1 event.
+type DomainModuleLoadUnloadV1 struct {
+	ModuleID         uint64
+	AssemblyID       uint64
+	AppDomainID      uint64
+	ModuleFlags      uint32
+	Reserved1        uint32
+	ModuleILPath     string
+	ModuleNativePath string
+	ClrInstanceID    uint16
+}
+
+// ModuleLoadUnload is the payload of ModuleLoad, ModuleUnload events.
+type ModuleLoadUnload struct {
+	ModuleID         uint64
+	AssemblyID       uint64
+	ModuleFlags      uint32
+	Reserved1        uint32
+	ModuleILPath     string
+	ModuleNativePath string
+}
+
+// ModuleLoadUnloadV1 is the payload of ModuleLoad_V1, ModuleUnload_V1 events.
+type ModuleLoadUnloadV1 struct {
+	ModuleID         uint64
+	AssemblyID       uint64
+	ModuleFlags      uint32
+	Reserved1        uint32
+	ModuleILPath     string
+	ModuleNativePath string
+	ClrInstanceID    uint16
+}
+
+// ModuleLoadUnloadV2 is the payload of ModuleLoad_V2, ModuleUnload_V2 events.
+type ModuleLoadUnloadV2 struct {
+	ModuleID            uint64
+	AssemblyID          uint64
+	ModuleFlags         uint32
+	Reserved1           uint32
+	ModuleILPath        string
+	ModuleNativePath    string
+	ClrInstanceID       uint16
+	ManagedPdbSignature [16]byte
+	ManagedPdbAge       uint32
+	ManagedPdbBuildPath string
+	NativePdbSignature  [16]byte
+	NativePdbAge        uint32
+	NativePdbBuildPath  string
+}
+
+// DCStartEnd is the payload of DCStartComplete_V1, DCEndComplete_V1 events.
+type DCStartEnd struct {
+	ClrInstanceID uint16
+}
+
+// MethodLoadUnloadRundown is the payload of MethodDCStart, MethodDCEnd events.
+type MethodLoadUnloadRundown struct {
+	MethodID           uint64
+	ModuleID           uint64
+	MethodStartAddress uint64
+	MethodSize         uint32
+	MethodToken        uint32
+	MethodFlags        uint32
+}
+
+// MethodLoadUnloadRundownV1 is the payload of MethodDCStart_V1, MethodDCEnd_V1 events.
+type MethodLoadUnloadRundownV1 struct {
+	MethodID           uint64
+	ModuleID           uint64
+	MethodStartAddress uint64
+	MethodSize         uint32
+	MethodToken        uint32
+	MethodFlags        uint32
+	ClrInstanceID      uint16
+}
+
+// MethodLoadUnloadRundownV2 is the payload of MethodDCStart_V2, MethodDCEnd_V2 events.
+type MethodLoadUnloadRundownV2 struct {
+	MethodID           uint64
+	ModuleID           uint64
+	MethodStartAddress uint64
+	MethodSize         uint32
+	MethodToken        uint32
+	MethodFlags        uint32
+	ClrInstanceID      uint16
+	ReJITID            uint64
+}
+
+// MethodLoadUnloadRundownVerbose is the payload of MethodDCStartVerbose, MethodDCEndVerbose events.
+type MethodLoadUnloadRundownVerbose struct {
+	MethodID           uint64
+	ModuleID           uint64
+	MethodStartAddress uint64
+	MethodSize         uint32
+	MethodToken        uint32
+	MethodFlags        uint32
+	MethodNamespace    string
+	MethodName         string
+	MethodSignature    string
+}
+
+// MethodLoadUnloadRundownVerboseV1 is the payload of MethodDCStartVerbose_V1, MethodDCEndVerbose_V1 events.
+type MethodLoadUnloadRundownVerboseV1 struct {
+	MethodID           uint64
+	ModuleID           uint64
+	MethodStartAddress uint64
+	MethodSize         uint32
+	MethodToken        uint32
+	MethodFlags        uint32
+	MethodNamespace    string
+	MethodName         string
+	MethodSignature    string
+	ClrInstanceID      uint16
+}
+
+// MethodLoadUnloadRundownVerboseV2 is the payload of MethodDCStartVerbose_V2, MethodDCEndVerbose_V2 events.
+type MethodLoadUnloadRundownVerboseV2 struct {
+	MethodID           uint64
+	ModuleID           uint64
+	MethodStartAddress uint64
+	MethodSize         uint32
+	MethodToken        uint32
+	MethodFlags        uint32
+	MethodNamespace    string
+	MethodName         string
+	MethodSignature    string
+	ClrInstanceID      uint16
+	ReJITID            uint64
+}
+
+// DomainModuleLoadUnloadRundown is the payload of DomainModuleDCStart, DomainModuleDCEnd events.
+type DomainModuleLoadUnloadRundown struct {
+	ModuleID         uint64
+	AssemblyID       uint64
+	AppDomainID      uint64
+	ModuleFlags      uint32
+	Reserved1        uint32
+	ModuleILPath     string
+	ModuleNativePath string
+}
+
+// DomainModuleLoadUnloadRundownV1 is the payload of DomainModuleDCStart_V1, DomainModuleDCEnd_V1 events.
+type DomainModuleLoadUnloadRundownV1 struct {
+	ModuleID         uint64
+	AssemblyID       uint64
+	AppDomainID      uint64
+	ModuleFlags      uint32
+	Reserved1        uint32
+	ModuleILPath     string
+	ModuleNativePath string
+	ClrInstanceID    uint16
+}
+
+// ModuleLoadUnloadRundown is the payload of ModuleDCStart, ModuleDCEnd events.
+type ModuleLoadUnloadRundown struct {
+	ModuleID         uint64
+	AssemblyID       uint64
+	ModuleFlags      uint32
+	Reserved1        uint32
+	ModuleILPath     string
+	ModuleNativePath string
+}
+
+// ModuleLoadUnloadRundownV1 is the payload of ModuleDCStart_V1, ModuleDCEnd_V1 events.
+type ModuleLoadUnloadRundownV1 struct {
+	ModuleID         uint64
+	AssemblyID       uint64
+	ModuleFlags      uint32
+	Reserved1        uint32
+	ModuleILPath     string
+	ModuleNativePath string
+	ClrInstanceID    uint16
+}
+
+// ModuleLoadUnloadRundownV2 is the payload of ModuleDCStart_V2, ModuleDCEnd_V2 events.
+type ModuleLoadUnloadRundownV2 struct {
+	ModuleID            uint64
+	AssemblyID          uint64
+	ModuleFlags         uint32
+	Reserved1           uint32
+	ModuleILPath        string
+	ModuleNativePath    string
+	ClrInstanceID       uint16
+	ManagedPdbSignature [16]byte
+	ManagedPdbAge       uint32
+	ManagedPdbBuildPath string
+	NativePdbSignature  [16]byte
+	NativePdbAge        uint32
+	NativePdbBuildPath  string
+}
+
+// AssemblyLoadUnloadRundown is the payload of AssemblyDCStart, AssemblyDCEnd events.
+type AssemblyLoadUnloadRundown struct {
+	AssemblyID                 uint64
+	AppDomainID                uint64
+	AssemblyFlags              uint32
+	FullyQualifiedAssemblyName string
+}
+
+// AssemblyLoadUnloadRundownV1 is the payload of AssemblyDCStart_V1, AssemblyDCEnd_V1 events.
+type AssemblyLoadUnloadRundownV1 struct {
+	AssemblyID                 uint64
+	AppDomainID                uint64
+	BindingID                  uint64
+	AssemblyFlags              uint32
+	FullyQualifiedAssemblyName string
+	ClrInstanceID              uint16
+}
+
+// AppDomainLoadUnloadRundown is the payload of AppDomainDCStart, AppDomainDCEnd events.
+type AppDomainLoadUnloadRundown struct {
+	AppDomainID    uint64
+	AppDomainFlags uint32
+	AppDomainName  string
+}
+
+// AppDomainLoadUnloadRundownV1 is the payload of AppDomainDCStart_V1, AppDomainDCEnd_V1 events.
+type AppDomainLoadUnloadRundownV1 struct {
+	AppDomainID    uint64
+	AppDomainFlags uint32
+	AppDomainName  string
+	AppDomainIndex uint32
+	ClrInstanceID  uint16
+}
+
+// RuntimeInformationRundown is the payload of RuntimeInformationDCStart event.
+type RuntimeInformationRundown struct {
+	ClrInstanceID   uint16
+	Sku             uint16
+	BclMajorVersion uint16
+	BclMinorVersion uint16
+	BclBuildNumber  uint16
+	BclQfeNumber    uint16
+	VMMajorVersion  uint16
+	VMMinorVersion  uint16
+	VMBuildNumber   uint16
+	VMQfeNumber     uint16
+	StartupFlags    uint32
+	StartupMode     uint8
+	CommandLine     string
+	ComObjectGuid   [16]byte
+	RuntimeDllPath  string
+}
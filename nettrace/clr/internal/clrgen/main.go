@@ -0,0 +1,321 @@
+// Command clrgen generates Go types of the runtime event payloads from
+// the CLR ETW manifest (ClrEtwAll.man).
+//
+// Usage:
+//
+//	clrgen -o events.go -provider Name=Prefix [-provider ...] ClrEtwAll.man
+//
+// For every template of the providers a struct is generated; the structs
+// are decoded with nettrace.Unmarshal, pointer fields are tagged "pointer". Event ID constants are prefixed with
+// the provider prefix, and named after the event symbol without the version
+// suffix.
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type manifest struct {
+	Providers []provider `xml:"instrumentation>events>provider"`
+}
+
+type provider struct {
+	Name      string     `xml:"name,attr"`
+	Templates []template `xml:"templates>template"`
+	Events    []event    `xml:"events>event"`
+}
+
+type template struct {
+	ID     string  `xml:"tid,attr"`
+	Fields []field `xml:",any"`
+}
+
+// field is either data, or struct element.
+type field struct {
+	XMLName xml.Name
+	Name    string  `xml:"name,attr"`
+	InType  string  `xml:"inType,attr"`
+	Count   string  `xml:"count,attr"`
+	Length  string  `xml:"length,attr"`
+	Fields  []field `xml:",any"`
+}
+
+type event struct {
+	Value    int32  `xml:"value,attr"`
+	Version  int32  `xml:"version,attr"`
+	Symbol   string `xml:"symbol,attr"`
+	Template string `xml:"template,attr"`
+}
+
+var goTypes = map[string]string{
+	"win:UnicodeString": "string",
+	"win:Boolean":       "bool",
+	"win:Int8":          "int8",
+	"win:UInt8":         "uint8",
+	"win:Int16":         "int16",
+	"win:UInt16":        "uint16",
+	"win:Int32":         "int32",
+	"win:UInt32":        "uint32",
+	"win:HexInt32":      "uint32",
+	"win:Int64":         "int64",
+	"win:UInt64":        "uint64",
+	"win:HexInt64":      "uint64",
+	// Read according to the trace pointer size, see nettrace.Unmarshal.
+	"win:Pointer":    "uint64",
+	"win:Float":      "float32",
+	"win:Double":     "float64",
+	"win:GUID":       "[16]byte",
+	"win:FILETIME":   "time.Time",
+	"win:SYSTEMTIME": "[8]uint16",
+	"win:Binary":     "byte",
+}
+
+type providerFlags map[string]string
+
+func (p providerFlags) String() string { return fmt.Sprint(map[string]string(p)) }
+
+func (p providerFlags) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i < 0 {
+		return fmt.Errorf("invalid provider %q: Name=Prefix expected", s)
+	}
+	p[s[:i]] = s[i+1:]
+	return nil
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("clrgen: ")
+	out := flag.String("o", "events.go", "output file")
+	pkg := flag.String("package", "clr", "package name")
+	providers := make(providerFlags)
+	flag.Var(providers, "provider", "provider to generate the types for, in Name=Prefix format")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		log.Fatal("manifest file expected")
+	}
+
+	b, err := ioutil.ReadFile(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	var m manifest
+	if err = xml.Unmarshal(b, &m); err != nil {
+		log.Fatal(err)
+	}
+	g := generator{
+		pkg:       *pkg,
+		source:    filepath.ToSlash(flag.Arg(0)),
+		providers: providers,
+		types:     make(map[string]string),
+	}
+	src, err := g.generate(m)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = ioutil.WriteFile(*out, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+type generator struct {
+	pkg       string
+	source    string
+	providers providerFlags
+
+	// Type name -> declaration.
+	types    map[string]string
+	decls    bytes.Buffer
+	usesTime bool
+}
+
+func (g *generator) generate(m manifest) ([]byte, error) {
+	var providers, ids, payloads bytes.Buffer
+	for _, p := range m.Providers {
+		prefix, ok := g.providers[p.Name]
+		if !ok {
+			continue
+		}
+		delete(g.providers, p.Name)
+		fmt.Fprintf(&providers, "%sProvider = %q\n", prefix, p.Name)
+
+		// Template ID -> Go type name.
+		templates := make(map[string]string, len(p.Templates))
+		symbols := make(map[string][]string)
+		for _, e := range p.Events {
+			if e.Template != "" {
+				symbols[e.Template] = append(symbols[e.Template], e.Symbol)
+			}
+		}
+		for _, t := range p.Templates {
+			name, err := g.template(prefix, t, symbols[t.ID])
+			if err != nil {
+				return nil, fmt.Errorf("template %s: %w", t.ID, err)
+			}
+			templates[t.ID] = name
+		}
+
+		fmt.Fprintf(&ids, "\n// %s event IDs.\nconst (\n", p.Name)
+		values := make(map[string]int32)
+		for _, e := range p.Events {
+			name := prefix + goName(versionSuffix.ReplaceAllString(e.Symbol, ""))
+			if id, ok := values[name]; ok {
+				if id != e.Value {
+					return nil, fmt.Errorf("event %s: conflicting IDs %d and %d", name, id, e.Value)
+				}
+			} else {
+				values[name] = e.Value
+				fmt.Fprintf(&ids, "%s = %d\n", name, e.Value)
+			}
+			if e.Template == "" {
+				continue
+			}
+			t, ok := templates[e.Template]
+			if !ok {
+				return nil, fmt.Errorf("event %s: template %s not found", e.Symbol, e.Template)
+			}
+			fmt.Fprintf(&payloads, "{%sProvider, %d, %d}: func() interface{} { return new(%s) },\n", prefix, e.Value, e.Version, t)
+		}
+		fmt.Fprint(&ids, ")\n")
+	}
+	for name := range g.providers {
+		return nil, fmt.Errorf("provider %s not found", name)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by clrgen from %s. DO NOT EDIT.\n\n", g.source)
+	fmt.Fprintf(&b, "package %s\n\n", g.pkg)
+	if g.usesTime {
+		fmt.Fprint(&b, "import \"time\"\n\n")
+	}
+	fmt.Fprintf(&b, "// Provider names.\nconst (\n%s)\n", providers.String())
+	b.Write(ids.Bytes())
+	fmt.Fprintf(&b, "\n// Event payload constructors by provider, event ID and version.\nvar payloads = map[eventKey]func() interface{}{\n%s}\n", payloads.String())
+	b.Write(g.decls.Bytes())
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %w\n%s", err, b.String())
+	}
+	return src, nil
+}
+
+var versionSuffix = regexp.MustCompile(`_V[0-9]+$`)
+
+// template generates the struct of the template, and returns the type name.
+// Templates of different providers that have the same ID and layout share
+// the type, including the types of struct elements.
+func (g *generator) template(prefix string, t template, symbols []string) (string, error) {
+	name := goName(t.ID)
+	var body, nested bytes.Buffer
+	if err := g.fields(&body, &nested, name, t.Fields); err != nil {
+		return "", err
+	}
+	if d, ok := g.types[name]; ok {
+		if d == body.String() {
+			return name, nil
+		}
+		name = prefix + name
+		body.Reset()
+		nested.Reset()
+		if err := g.fields(&body, &nested, name, t.Fields); err != nil {
+			return "", err
+		}
+	}
+	decl := body.String()
+	var doc string
+	switch len(symbols) {
+	case 0:
+		doc = fmt.Sprintf("// %s is the %s template payload.\n", name, t.ID)
+	case 1:
+		doc = fmt.Sprintf("// %s is the payload of %s event.\n", name, symbols[0])
+	default:
+		doc = fmt.Sprintf("// %s is the payload of %s events.\n", name, strings.Join(symbols, ", "))
+	}
+	g.types[name] = decl
+	g.decls.Write(nested.Bytes())
+	fmt.Fprintf(&g.decls, "\n%stype %s struct {\n%s}\n", doc, name, decl)
+	return name, nil
+}
+
+// fields writes the struct fields to w, and declarations of the struct
+// elements to decls.
+func (g *generator) fields(w, decls *bytes.Buffer, parent string, fields []field) error {
+	for _, f := range fields {
+		var typ string
+		switch f.XMLName.Local {
+		case "data":
+			var ok bool
+			if typ, ok = goTypes[f.InType]; !ok {
+				return fmt.Errorf("field %s: unsupported type %s", f.Name, f.InType)
+			}
+			if typ == "time.Time" {
+				g.usesTime = true
+			}
+			if f.InType == "win:Binary" {
+				if f.Length == "" {
+					return fmt.Errorf("field %s: binary length is not specified", f.Name)
+				}
+				f.Count = f.Length
+			} else if f.Length != "" {
+				return fmt.Errorf("field %s: length is not supported for %s", f.Name, f.InType)
+			}
+		case "struct":
+			typ = parent + goName(f.Name)
+			var body bytes.Buffer
+			if err := g.fields(&body, decls, typ, f.Fields); err != nil {
+				return fmt.Errorf("struct %s: %w", f.Name, err)
+			}
+			fmt.Fprintf(decls, "\n// %s is an element of %s.%s.\ntype %s struct {\n%s}\n",
+				typ, parent, goName(f.Name), typ, body.String())
+		default:
+			// UserData and other elements that do not describe the layout.
+			continue
+		}
+		var options []string
+		switch n, err := strconv.Atoi(f.Count); {
+		case f.Count == "":
+		case err == nil:
+			typ = fmt.Sprintf("[%d]%s", n, typ)
+		default:
+			typ = "[]" + typ
+			options = append(options, "count="+goName(f.Count))
+		}
+		if f.InType == "win:Pointer" {
+			options = append(options, "pointer")
+		}
+		var tag string
+		if len(options) > 0 {
+			tag = fmt.Sprintf(" `nettrace:%q`", strings.Join(options, ","))
+		}
+		fmt.Fprintf(w, "%s %s%s\n", goName(f.Name), typ, tag)
+	}
+	return nil
+}
+
+// goName returns exported Go identifier for the manifest name.
+func goName(s string) string {
+	parts := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, p := range parts {
+		r := []rune(p)
+		r[0] = unicode.ToUpper(r[0])
+		parts[i] = string(r)
+	}
+	name := strings.Join(parts, "")
+	if name == "" || unicode.IsDigit([]rune(name)[0]) {
+		name = "X" + name
+	}
+	return name
+}
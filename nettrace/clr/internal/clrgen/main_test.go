@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestGenerateSharedTemplates(t *testing.T) {
+	const template = `
+<template tid="Bulk">
+  <data name="Count" inType="win:UInt32"/>
+  <struct name="Values" count="Count">
+    <data name="Address" inType="win:Pointer"/>
+  </struct>
+</template>`
+	var m manifest
+	err := xml.Unmarshal([]byte(`<instrumentationManifest><instrumentation><events>
+<provider name="A"><templates>`+template+`</templates><events><event value="1" symbol="BulkA" template="Bulk"/></events></provider>
+<provider name="B"><templates>`+template+`</templates><events><event value="1" symbol="BulkB" template="Bulk"/></events></provider>
+</events></instrumentation></instrumentationManifest>`), &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := generator{
+		pkg:       "clr",
+		source:    "test.man",
+		providers: providerFlags{"A": "A", "B": "B"},
+		types:     make(map[string]string),
+	}
+	src, err := g.generate(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, decl := range []string{"type Bulk struct", "type BulkValues struct"} {
+		if n := strings.Count(string(src), decl); n != 1 {
+			t.Fatalf("%q declared %d times:\n%s", decl, n, src)
+		}
+	}
+	if !strings.Contains(string(src), "Address uint64 `nettrace:\"pointer\"`") {
+		t.Fatalf("pointer field is not tagged:\n%s", src)
+	}
+}
@@ -1,11 +1,13 @@
 package nettrace
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"time"
 )
 
 var (
@@ -21,27 +23,43 @@ var (
 	ErrInvalidObjectType        = errors.New("invalid object type")
 	ErrUnexpectedObjectType     = errors.New("unexpected object type")
 	ErrUnexpectedTag            = errors.New("unexpected tag")
+	ErrUnsupportedFormatVersion = errors.New("unsupported format version")
 )
 
-const Version int32 = 4
+const Version int32 = 6
 
-type Decoder struct{ r *netTraceReader }
+// netTraceFormatVersion is the first NetTrace format version:
+// earlier versions are NetPerf.
+const netTraceFormatVersion = 4
+
+type Decoder struct {
+	r *netTraceReader
+	// Format version of the stream: NetPerf (1-3) or NetTrace (4+).
+	version int32
+	// Observer, if specified, is notified about objects decoded and errors.
+	Observer Observer
+}
 
 func NewDecoder(r io.Reader) *Decoder {
 	return &Decoder{
 		r: &netTraceReader{
 			buf:   bytes.NewBuffer(make([]byte, 0, 4<<10)),
-			inner: r,
+			inner: bufio.NewReader(r),
 		},
 	}
 }
 
 type netTraceReader struct {
 	offset uint64
-	inner  io.Reader
+	inner  *bufio.Reader
 	buf    *bytes.Buffer
 }
 
+// peek returns the next n bytes without advancing the reader.
+func (c *netTraceReader) peek(n int) ([]byte, error) {
+	return c.inner.Peek(n)
+}
+
 func (c *netTraceReader) Read(b []byte) (int, error) {
 	n, err := io.CopyN(c.buf, c.inner, int64(len(b)))
 	copy(b, c.buf.Bytes()[:n])
@@ -67,12 +85,18 @@ const (
 	ObjectTypeSPBlock       ObjectType = "SPBlock"
 )
 
+// NetPerf (format versions 1-3) trace object type name.
+const objectTypeEventPipeFile ObjectType = "Microsoft.DotNet.Runtime.EventPipeFile"
+
 var knownObjectTypes = []ObjectType{
 	ObjectTypeTrace,
 	ObjectTypeEventBlock,
 	ObjectTypeMetadataBlock,
 	ObjectTypeStackBlock,
 	ObjectTypeSPBlock,
+	ObjectTypeThreadBlock,
+	ObjectTypeRemoveThreadBlock,
+	ObjectTypeLabelListBlock,
 }
 
 func (t ObjectType) IsValid() bool {
@@ -98,6 +122,10 @@ var objectHeaderTags = [3]Tag{
 	NullReference,
 }
 
+// Trace is the trace object that follows the stream header. NetPerf versions
+// 1 and 2 do not specify PointerSize, ProcessID, NumberOfProcessors and
+// ExpectedCPUSamplingRate: the pointer size is assumed to be 8, and the
+// rest are zero.
 type Trace struct {
 	Year                    int16
 	Month                   int16
@@ -113,17 +141,78 @@ type Trace struct {
 	ProcessID               int32
 	NumberOfProcessors      int32
 	ExpectedCPUSamplingRate int32
+	// KeyValues contains the trace header key/value pairs. Format version
+	// 6 and newer only; the well-known keys are mapped to the fields above.
+	KeyValues map[string]string
 }
 
-// unsafe.Sizeof(Trace{})
+// Size of the serialized Trace fields.
 const traceLen = 48
 
+// fields returns pointers to the serialized Trace fields.
+func (t *Trace) fields() []interface{} {
+	return []interface{}{
+		&t.Year, &t.Month, &t.DayOfWeek, &t.Day,
+		&t.Hour, &t.Minute, &t.Second, &t.Millisecond,
+		&t.SyncTimeQPC, &t.QPCFrequency, &t.PointerSize,
+		&t.ProcessID, &t.NumberOfProcessors, &t.ExpectedCPUSamplingRate,
+	}
+}
+
+// NetPerf versions 1 and 2 trace object starts with a forward reference to
+// the end of the event stream, followed by the start time and QPC timestamp
+// and frequency. Pointer size is not specified, and can not be inferred from
+// the stream reliably: stacks are stored inline as byte arrays. 64-bit
+// process is assumed, therefore stacks of 32-bit processes (.NET Core 2.x
+// on x86 or ARM) are decoded incorrectly.
+const (
+	legacyTraceLen         = 36
+	legacyTracePointerSize = 8
+)
+
+// SyncTime returns the wall clock time (UTC) the trace was started at,
+// that corresponds to SyncTimeQPC.
+func (t *Trace) SyncTime() time.Time {
+	return time.Date(int(t.Year), time.Month(t.Month), int(t.Day),
+		int(t.Hour), int(t.Minute), int(t.Second),
+		int(t.Millisecond)*int(time.Millisecond), time.UTC)
+}
+
+// Time converts QPC timestamp to the wall clock time.
+func (t *Trace) Time(timestamp int64) time.Time {
+	return t.SyncTime().Add(t.Duration(timestamp - t.SyncTimeQPC))
+}
+
+// Duration converts the QPC ticks to time.Duration.
+func (t *Trace) Duration(ticks int64) time.Duration {
+	if t.QPCFrequency == 0 {
+		return 0
+	}
+	s := ticks / t.QPCFrequency
+	r := ticks % t.QPCFrequency
+	return time.Duration(s)*time.Second + time.Duration(r*int64(time.Second)/t.QPCFrequency)
+}
+
 type netTraceHeader struct {
 	NetTraceMagic          [8]byte
 	Len                    int32
 	FastSerializationMagic [20]byte
 }
 
+// netPerfHeader is the stream header of NetPerf format (versions 1-3).
+type netPerfHeader struct {
+	Len                    int32
+	FastSerializationMagic [20]byte
+}
+
+func (h netPerfHeader) validate() error {
+	if !(h.Len == int32(len(fastSerializationMagic)) &&
+		h.FastSerializationMagic == fastSerializationMagic) {
+		return ErrInvalidNetTraceHeader
+	}
+	return nil
+}
+
 func (h netTraceHeader) validate() error {
 	if !(h.NetTraceMagic == netTraceMagic &&
 		h.FastSerializationMagic == fastSerializationMagic) {
@@ -155,13 +244,24 @@ func (h objectHeader) validate() error {
 const objectHeaderSize = 15
 
 func (d *Decoder) OpenTrace() (*Trace, error) {
-	var header netTraceHeader
+	t, err := d.openTrace()
+	if d.Observer != nil {
+		if err != nil {
+			d.Observer.Error(err)
+		} else {
+			d.Observer.ObjectDecoded(ObjectTypeTrace, int(d.r.offset))
+		}
+	}
+	return t, err
+}
+
+func (d *Decoder) openTrace() (*Trace, error) {
 	var err error
-	if err = d.read(&header); err != nil {
+	if err = d.readStreamHeader(); err != nil {
 		return nil, err
 	}
-	if err = header.validate(); err != nil {
-		return nil, ErrInvalidNetTraceHeader
+	if d.version >= netTraceV6 {
+		return d.readTraceBlock()
 	}
 	var o Object
 	if err = d.readObject(&o); err != nil {
@@ -170,18 +270,115 @@ func (d *Decoder) OpenTrace() (*Trace, error) {
 	if o.Type != ObjectTypeTrace {
 		return nil, fmt.Errorf("%w: %s", ErrUnexpectedObjectType, o.Type)
 	}
+	d.version = o.Version
 	var trace Trace
-	if err = d.readFrom(o.Payload, &trace); err != nil {
+	if o.Version < 3 {
+		err = d.readLegacyTrace(o.Payload, &trace)
+	} else {
+		for _, f := range trace.fields() {
+			if err = d.readFrom(o.Payload, f); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
 		return nil, fmt.Errorf("invalid trace object: %w", err)
 	}
 	return &trace, nil
 }
 
+// readStreamHeader reads NetTrace or NetPerf stream header.
+func (d *Decoder) readStreamHeader() error {
+	b, _ := d.r.peek(len(netTraceMagic) + 4)
+	if len(b) == len(netTraceMagic)+4 && bytes.Equal(b[:len(netTraceMagic)], netTraceMagic[:]) &&
+		binary.LittleEndian.Uint32(b[len(netTraceMagic):]) == 0 {
+		var header netTraceV6Header
+		if err := d.read(&header); err != nil {
+			return err
+		}
+		if header.MajorVersion != netTraceV6 {
+			return fmt.Errorf("%w: %d.%d", ErrUnsupportedFormatVersion, header.MajorVersion, header.MinorVersion)
+		}
+		d.version = int32(header.MajorVersion)
+		return nil
+	}
+	if len(b) >= len(netTraceMagic) && !bytes.Equal(b[:len(netTraceMagic)], netTraceMagic[:]) {
+		var header netPerfHeader
+		if err := d.read(&header); err != nil {
+			return err
+		}
+		return header.validate()
+	}
+	var header netTraceHeader
+	if err := d.read(&header); err != nil {
+		return err
+	}
+	return header.validate()
+}
+
+func (d *Decoder) readLegacyTrace(r *bytes.Buffer, trace *Trace) error {
+	// Skip the forward reference.
+	r.Next(4)
+	// Year through QPCFrequency.
+	var t struct {
+		Time         [8]int16
+		SyncTimeQPC  int64
+		QPCFrequency int64
+	}
+	if err := d.readFrom(r, &t); err != nil {
+		return err
+	}
+	*trace = Trace{
+		Year:         t.Time[0],
+		Month:        t.Time[1],
+		DayOfWeek:    t.Time[2],
+		Day:          t.Time[3],
+		Hour:         t.Time[4],
+		Minute:       t.Time[5],
+		Second:       t.Time[6],
+		Millisecond:  t.Time[7],
+		SyncTimeQPC:  t.SyncTimeQPC,
+		QPCFrequency: t.QPCFrequency,
+		PointerSize:  legacyTracePointerSize,
+	}
+	return nil
+}
+
+// FormatVersion returns the stream format version: 1-3 for NetPerf, and
+// 4 or higher for NetTrace. The version is only known after OpenTrace.
+//
+// In NetPerf versions 1-3, event blocks have no header and include both
+// events and metadata records, and the stacks are stored inline. Versions 1
+// and 2 store the events outside of any object: Decode returns them in
+// synthetic EventBlock objects. Stream handles the differences.
+func (d *Decoder) FormatVersion() int32 {
+	return d.version
+}
+
 // Decode deserializes next NetTrace Object from stream to o.
 // The call returns io.EOF when the stream is properly terminated,
 // any further attempts to decode will return io.ErrUnexpectedEOF.
 func (d *Decoder) Decode(o *Object) error {
-	return d.readObject(o)
+	offset := d.r.offset
+	var err error
+	switch {
+	case d.version >= netTraceV6:
+		err = d.readBlock(o)
+	case d.version > 0 && d.version < 3:
+		err = d.readLegacyEvents(o)
+	default:
+		err = d.readObject(o)
+	}
+	if d.Observer == nil {
+		return err
+	}
+	switch {
+	case err == nil:
+		d.Observer.ObjectDecoded(o.Type, int(d.r.offset-offset))
+	case !errors.Is(err, io.EOF):
+		d.Observer.Error(err)
+	}
+	return err
 }
 
 func (d *Decoder) Offset() uint64 {
@@ -236,6 +433,9 @@ func (d *Decoder) readObject(o *Object) error {
 		return fmt.Errorf("reading type name: %w", err)
 	}
 	objectType := ObjectType(typeName)
+	if objectType == objectTypeEventPipeFile {
+		objectType = ObjectTypeTrace
+	}
 	if !objectType.IsValid() {
 		return fmt.Errorf("%w: %s", ErrInvalidObjectType, objectType)
 	}
@@ -245,7 +445,7 @@ func (d *Decoder) readObject(o *Object) error {
 		return fmt.Errorf("reading type object: %w", err)
 	}
 
-	o.Payload, err = d.objectPayload(objectType)
+	o.Payload, err = d.objectPayload(objectType, header.Version)
 	if err != nil {
 		return fmt.Errorf("reading object payload: %w", err)
 	}
@@ -273,10 +473,14 @@ func (d *Decoder) expectEndTag() error {
 	return nil
 }
 
-func (d *Decoder) objectPayload(t ObjectType) (*bytes.Buffer, error) {
+func (d *Decoder) objectPayload(t ObjectType, version int32) (*bytes.Buffer, error) {
 	switch t {
 	case ObjectTypeTrace:
-		s := make([]byte, traceLen)
+		n := traceLen
+		if version < 3 {
+			n = legacyTraceLen
+		}
+		s := make([]byte, n)
 		if _, err := io.ReadFull(d.r, s); err != nil {
 			return nil, err
 		}
@@ -314,3 +518,61 @@ func (d *Decoder) blockPayload() (*bytes.Buffer, error) {
 	}
 	return bytes.NewBuffer(blockData), nil
 }
+
+// legacyEventBlockSize limits the size of a synthetic event block
+// of NetPerf version 1 or 2 stream.
+const legacyEventBlockSize = 64 << 10
+
+// readLegacyEvents reads events of NetPerf version 1 or 2 to a synthetic
+// EventBlock object. The events are followed by NullReference tag and the
+// forward reference table, which specifies the end of the event stream.
+func (d *Decoder) readLegacyEvents(o *Object) error {
+	var b bytes.Buffer
+	for b.Len() < legacyEventBlockSize {
+		end, err := d.legacyEventsEnd()
+		if err != nil {
+			return err
+		}
+		if end {
+			break
+		}
+		var size int32
+		if err = d.read(&size); err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		_ = binary.Write(&b, binary.LittleEndian, size)
+		if _, err = io.CopyN(&b, d.r, int64(size)); err != nil {
+			return io.ErrUnexpectedEOF
+		}
+	}
+	if b.Len() == 0 {
+		// NullReference tag, count, the reference, and the table offset.
+		if _, err := io.ReadFull(d.r, make([]byte, 13)); err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		return io.EOF
+	}
+	*o = Object{
+		Type:                 ObjectTypeEventBlock,
+		Version:              d.version,
+		MinimumReaderVersion: d.version,
+		Payload:              &b,
+	}
+	return nil
+}
+
+// legacyEventsEnd reports whether the end of NetPerf version 1 or 2 event
+// stream is reached: the stream ends with NullReference tag followed by
+// the forward reference table of a single entry pointing to the tag.
+func (d *Decoder) legacyEventsEnd() (bool, error) {
+	b, err := d.r.peek(9)
+	if len(b) < 9 {
+		if errors.Is(err, io.EOF) {
+			return false, io.ErrUnexpectedEOF
+		}
+		return false, err
+	}
+	return Tag(b[0]) == NullReference &&
+		binary.LittleEndian.Uint32(b[1:5]) == 1 &&
+		uint64(binary.LittleEndian.Uint32(b[5:9])) == d.r.offset, nil
+}
@@ -0,0 +1,329 @@
+package nettrace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf16"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace/typecode"
+)
+
+var ErrTraceNotEncoded = errors.New("trace object must be encoded first")
+
+// Encoder writes NetTrace format version 4 stream: the header and the
+// trace object are followed by block objects, and the stream is terminated
+// with Close.
+type Encoder struct {
+	w     objectWriter
+	trace bool
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: objectWriter{w: w}}
+}
+
+// EncodeTrace writes the stream header and the trace object.
+func (e *Encoder) EncodeTrace(t *Trace) error {
+	if !e.trace {
+		e.w.writeHeader()
+		e.w.writeTrace(t)
+		e.trace = true
+	}
+	return e.w.err
+}
+
+// EncodeObject writes the block object as is. The payload is not consumed.
+// Objects of format version 6 streams can not be written.
+func (e *Encoder) EncodeObject(o Object) error {
+	if !e.trace {
+		return ErrTraceNotEncoded
+	}
+	if o.Version >= netTraceV6 {
+		return fmt.Errorf("%w: %d", ErrUnsupportedFormatVersion, o.Version)
+	}
+	e.w.writeObject(o)
+	return e.w.err
+}
+
+// EncodeMetadataBlock writes MetadataBlock describing the events.
+func (e *Encoder) EncodeMetadataBlock(md []*Metadata, compressed bool) error {
+	blobs := make([]Blob, len(md))
+	for i, m := range md {
+		payload, err := EncodeMetadata(m)
+		if err != nil {
+			return err
+		}
+		blobs[i] = Blob{
+			Header:  BlobHeader{PayloadSize: int32(len(payload))},
+			Payload: bytes.NewBuffer(payload),
+		}
+	}
+	return e.encodeBlobBlock(ObjectTypeMetadataBlock, blobs, compressed)
+}
+
+// EncodeEventBlock writes EventBlock of the blobs. Blob payloads are not
+// consumed; PayloadSize of the headers is ignored.
+func (e *Encoder) EncodeEventBlock(blobs []Blob, compressed bool) error {
+	return e.encodeBlobBlock(ObjectTypeEventBlock, blobs, compressed)
+}
+
+func (e *Encoder) encodeBlobBlock(t ObjectType, blobs []Blob, compressed bool) error {
+	var w blobWriter
+	w.header.Size = int16(blockHeaderSize)
+	if compressed {
+		w.header.Flags = 1
+	}
+	for i := range blobs {
+		w.writeBlob(&blobs[i], compressed)
+	}
+	var b bytes.Buffer
+	_ = binary.Write(&b, binary.LittleEndian, w.header)
+	b.Write(w.buf.Bytes())
+	return e.encodeBlock(t, &b)
+}
+
+// EncodeStackBlock writes StackBlock. Stack IDs must be consecutive.
+func (e *Encoder) EncodeStackBlock(b *StackBlock) error {
+	var w blobWriter
+	var first int32
+	if len(b.Stacks) > 0 {
+		first = b.Stacks[0].ID
+	}
+	w.writeValue(first)
+	w.writeValue(int32(len(b.Stacks)))
+	for i, s := range b.Stacks {
+		if s.ID != first+int32(i) {
+			return fmt.Errorf("stack IDs are not consecutive: %d follows %d", s.ID, first+int32(i)-1)
+		}
+		w.writeValue(int32(len(s.Data)))
+		w.buf.Write(s.Data)
+	}
+	return e.encodeBlock(ObjectTypeStackBlock, &w.buf)
+}
+
+// EncodeSequencePointBlock writes SPBlock.
+func (e *Encoder) EncodeSequencePointBlock(b *SequencePointBlock) error {
+	var w blobWriter
+	w.writeValue(b.TimeStamp)
+	w.writeValue(int32(len(b.Threads)))
+	for _, t := range b.Threads {
+		w.writeValue(t)
+	}
+	return e.encodeBlock(ObjectTypeSPBlock, &w.buf)
+}
+
+func (e *Encoder) encodeBlock(t ObjectType, payload *bytes.Buffer) error {
+	return e.EncodeObject(Object{
+		Type:                 t,
+		Version:              blockObjectVersion,
+		MinimumReaderVersion: blockObjectVersion,
+		Payload:              payload,
+	})
+}
+
+// Close terminates the stream. The underlying writer is not closed.
+func (e *Encoder) Close() error {
+	if !e.trace {
+		return ErrTraceNotEncoded
+	}
+	e.w.writeEnd()
+	return e.w.err
+}
+
+// blobWriter serializes block payloads.
+type blobWriter struct {
+	buf    bytes.Buffer
+	header BlobBlockHeader
+	last   BlobHeader
+	// Set once the first blob is written.
+	first bool
+}
+
+func (w *blobWriter) writeValue(v interface{}) {
+	_ = binary.Write(&w.buf, binary.LittleEndian, v)
+}
+
+func (w *blobWriter) uvarint(x uint64) {
+	for x >= 0x80 {
+		w.buf.WriteByte(byte(x) | 0x80)
+		x >>= 7
+	}
+	w.buf.WriteByte(byte(x))
+}
+
+func (w *blobWriter) writeBlob(blob *Blob, compressed bool) {
+	payload := blob.Payload.Bytes()
+	h := blob.Header
+	h.PayloadSize = int32(len(payload))
+	if !w.first || h.TimeStamp < w.header.MinTimestamp {
+		w.header.MinTimestamp = h.TimeStamp
+	}
+	if !w.first || h.TimeStamp > w.header.MaxTimestamp {
+		w.header.MaxTimestamp = h.TimeStamp
+	}
+	w.first = true
+	if !compressed {
+		metadataID := h.MetadataID
+		if blob.sorted {
+			metadataID |= -0x80000000
+		}
+		w.writeValue(uncompressedBlobHeader{
+			Size:              uncompressedBlobHeaderSize - 4 + h.PayloadSize,
+			MetadataID:        metadataID,
+			SequenceNumber:    h.SequenceNumber,
+			ThreadID:          h.ThreadID,
+			CaptureThreadID:   h.CaptureThreadID,
+			CaptureProcNumber: h.CaptureProcNumber,
+			StackID:           h.StackID,
+			TimeStamp:         h.TimeStamp,
+			ActivityID:        h.ActivityID,
+			RelatedActivityID: h.RelatedActivityID,
+			PayloadSize:       h.PayloadSize,
+		})
+		w.buf.Write(payload)
+		w.buf.Write(make([]byte, alignmentPadding(len(payload))))
+		return
+	}
+
+	// The inverse of BlobBlock.readHeaderCompressed.
+	last := w.last
+	var flags compressedHeaderFlag
+	if h.MetadataID != last.MetadataID {
+		flags |= flagMetadataID
+	}
+	expectedSequence := last.SequenceNumber
+	if h.MetadataID != 0 {
+		expectedSequence++
+	}
+	if h.SequenceNumber != expectedSequence || h.CaptureThreadID != last.CaptureThreadID ||
+		h.CaptureProcNumber != last.CaptureProcNumber {
+		flags |= flagCaptureThreadAndSequence
+	}
+	if h.ThreadID != last.ThreadID {
+		flags |= flagThreadID
+	}
+	if h.StackID != last.StackID {
+		flags |= flagStackID
+	}
+	if h.ActivityID != last.ActivityID {
+		flags |= flagActivityID
+	}
+	if h.RelatedActivityID != last.RelatedActivityID {
+		flags |= flagRelatedActivityID
+	}
+	if blob.sorted {
+		flags |= flagIsSorted
+	}
+	if h.PayloadSize != last.PayloadSize {
+		flags |= flagPayloadSize
+	}
+	w.buf.WriteByte(byte(flags))
+	if flags&flagMetadataID != 0 {
+		w.uvarint(uint64(uint32(h.MetadataID)))
+	}
+	if flags&flagCaptureThreadAndSequence != 0 {
+		w.uvarint(uint64(uint32(h.SequenceNumber - last.SequenceNumber - 1)))
+		w.uvarint(uint64(h.CaptureThreadID))
+		w.uvarint(uint64(uint32(h.CaptureProcNumber)))
+	}
+	if flags&flagThreadID != 0 {
+		w.uvarint(uint64(h.ThreadID))
+	}
+	if flags&flagStackID != 0 {
+		w.uvarint(uint64(uint32(h.StackID)))
+	}
+	w.uvarint(uint64(h.TimeStamp - last.TimeStamp))
+	if flags&flagActivityID != 0 {
+		w.buf.Write(h.ActivityID[:])
+	}
+	if flags&flagRelatedActivityID != 0 {
+		w.buf.Write(h.RelatedActivityID[:])
+	}
+	if flags&flagPayloadSize != 0 {
+		w.uvarint(uint64(h.PayloadSize))
+	}
+	w.buf.Write(payload)
+	w.last = h
+}
+
+// EncodeMetadata returns the metadata record payload in the NetTrace format
+// (versions 4-5). The opcode is written as a metadata tag, if specified.
+// Fields are written as V2 parameters, if the metadata was decoded from
+// them, or if any of the fields is an array, like the runtime does.
+func EncodeMetadata(md *Metadata) ([]byte, error) {
+	var w blobWriter
+	h := md.Header
+	w.writeValue(h.MetaDataID)
+	w.writeUTF16NTS(h.ProviderName)
+	w.writeValue(h.EventID)
+	w.writeUTF16NTS(h.EventName)
+	w.writeValue(h.Keywords)
+	w.writeValue(h.Version)
+	w.writeValue(h.Level)
+	v2 := md.v2Params || hasArrays(md.Payload)
+	if v2 {
+		// V1 field count.
+		w.writeValue(int32(0))
+	} else {
+		w.writeMetadataPayload(md.Payload, false)
+	}
+	if h.Opcode != 0 {
+		w.writeValue(int32(1))
+		w.writeValue(TagKindOpcode)
+		w.writeValue(h.Opcode)
+	}
+	if v2 {
+		var params blobWriter
+		params.writeMetadataPayload(md.Payload, true)
+		w.writeValue(int32(params.buf.Len()))
+		w.writeValue(TagKindV2Params)
+		w.buf.Write(params.buf.Bytes())
+	}
+	return w.buf.Bytes(), nil
+}
+
+func hasArrays(p MetadataPayload) bool {
+	for _, f := range p.Fields {
+		if f.TypeCode == typecode.Array || hasArrays(f.Payload) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeMetadataPayload writes field definitions: V1 definition is the type
+// followed by the name; V2 definition is prefixed with its size (including
+// the size itself), and the name is followed by the type.
+func (w *blobWriter) writeMetadataPayload(p MetadataPayload, v2 bool) {
+	w.writeValue(int32(len(p.Fields)))
+	for _, f := range p.Fields {
+		if !v2 {
+			w.writeType(f, false)
+			w.writeUTF16NTS(f.Name)
+			continue
+		}
+		var d blobWriter
+		d.writeUTF16NTS(f.Name)
+		d.writeType(f, true)
+		w.writeValue(int32(4 + d.buf.Len()))
+		w.buf.Write(d.buf.Bytes())
+	}
+}
+
+// writeType is the inverse of Metadata.readType.
+func (w *blobWriter) writeType(f MetadataField, v2 bool) {
+	w.writeValue(f.TypeCode)
+	switch f.TypeCode {
+	case typecode.Array:
+		w.writeType(f.element(), v2)
+	case typecode.Object:
+		w.writeMetadataPayload(f.Payload, v2)
+	}
+}
+
+func (w *blobWriter) writeUTF16NTS(s string) {
+	w.writeValue(append(utf16.Encode([]rune(s)), 0))
+}
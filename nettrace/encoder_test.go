@@ -0,0 +1,182 @@
+package nettrace_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+	"github.com/pyroscope-io/dotnetdiag/nettrace/typecode"
+)
+
+func TestBuilderRoundTrip(t *testing.T) {
+	for _, compressed := range []bool{false, true} {
+		compressed := compressed
+		name := "Uncompressed"
+		if compressed {
+			name = "Compressed"
+		}
+		t.Run(name, func(t *testing.T) {
+			b := nettrace.NewBuilder()
+			b.Compressed = compressed
+			id := b.Metadata("Test-Provider", 1, "Request",
+				nettrace.MetadataField{TypeCode: typecode.String, Name: "Url"},
+				nettrace.MetadataField{TypeCode: typecode.Int32, Name: "Status"})
+			stack := b.Stack(0x1000, 0x2000)
+			var p rawBuilder
+			p.write(utf16NTS("/a"), int32(200))
+			headers := []nettrace.BlobHeader{
+				{MetadataID: id, ThreadID: 1, StackID: stack, TimeStamp: 100},
+				{MetadataID: id, ThreadID: 2, TimeStamp: 150, ActivityID: [16]byte{1}},
+				{MetadataID: id, ThreadID: 1, StackID: stack, TimeStamp: 120, SequenceNumber: 5},
+			}
+			for _, h := range headers {
+				b.Event(h, p.Bytes())
+			}
+			var buf bytes.Buffer
+			n, err := b.WriteTo(&buf)
+			requireNoError(t, err)
+			if n != int64(buf.Len()) {
+				t.Fatalf("written %d bytes, reported %d", buf.Len(), n)
+			}
+
+			stream := nettrace.NewStream(&buf)
+			trace, err := stream.Open()
+			requireNoError(t, err)
+			if trace.PointerSize != 8 {
+				t.Fatalf("unexpected trace: %+v", trace)
+			}
+			var md *nettrace.Metadata
+			stream.MetadataHandler = func(m *nettrace.Metadata) error {
+				md = m
+				return nil
+			}
+			var stacks []nettrace.Stack
+			stream.StackBlockHandler = func(sb *nettrace.StackBlock) error {
+				stacks = append(stacks, sb.Stacks...)
+				return nil
+			}
+			var sp *nettrace.SequencePointBlock
+			stream.SequencePointBlockHandler = func(b *nettrace.SequencePointBlock) error {
+				sp = b
+				return nil
+			}
+			var events []nettrace.BlobHeader
+			stream.EventHandler = func(blob *nettrace.Blob) error {
+				fields, err := nettrace.DecodePayloadMap(blob, md)
+				if err != nil {
+					return err
+				}
+				if fields["Url"] != "/a" || fields["Status"] != int32(200) {
+					t.Fatalf("unexpected fields: %+v", fields)
+				}
+				events = append(events, blob.Header)
+				return nil
+			}
+			for {
+				err = stream.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				requireNoError(t, err)
+			}
+
+			if md == nil || md.Header.ProviderName != "Test-Provider" || md.Header.EventName != "Request" || len(md.Payload.Fields) != 2 {
+				t.Fatalf("unexpected metadata: %+v", md)
+			}
+			if len(stacks) != 1 || stacks[0].ID != stack || len(stacks[0].Data) != 16 {
+				t.Fatalf("unexpected stacks: %+v", stacks)
+			}
+			expected := []nettrace.BlobHeader{
+				{MetadataID: id, SequenceNumber: 1, ThreadID: 1, CaptureThreadID: 1, StackID: stack, TimeStamp: 100},
+				{MetadataID: id, SequenceNumber: 1, ThreadID: 2, CaptureThreadID: 2, TimeStamp: 150, ActivityID: [16]byte{1}},
+				{MetadataID: id, SequenceNumber: 5, ThreadID: 1, CaptureThreadID: 1, StackID: stack, TimeStamp: 120},
+			}
+			for i := range expected {
+				expected[i].PayloadSize = int32(p.Len())
+			}
+			if !reflect.DeepEqual(events, expected) {
+				t.Fatalf("unexpected events:\n%+v\nexpected:\n%+v", events, expected)
+			}
+			threads := []nettrace.Thread{{ThreadID: 1, SequenceNumber: 5}, {ThreadID: 2, SequenceNumber: 1}}
+			if sp == nil || sp.TimeStamp != 150 || !reflect.DeepEqual(sp.Threads, threads) {
+				t.Fatalf("unexpected sequence point: %+v", sp)
+			}
+		})
+	}
+}
+
+func TestEncoderRewrite(t *testing.T) {
+	const sample = "testdata/dotnet-5.0-SampleProfiler-single-thread.golden.nettrace"
+	b, err := os.ReadFile(sample)
+	requireNoError(t, err)
+	dec := nettrace.NewDecoder(bytes.NewReader(b))
+	trace, err := dec.OpenTrace()
+	requireNoError(t, err)
+
+	var out bytes.Buffer
+	enc := nettrace.NewEncoder(&out)
+	requireNoError(t, enc.EncodeTrace(trace))
+	var o nettrace.Object
+	for {
+		err = dec.Decode(&o)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		requireNoError(t, err)
+		requireNoError(t, enc.EncodeObject(o))
+	}
+	requireNoError(t, enc.Close())
+	if !bytes.Equal(out.Bytes(), b) {
+		t.Fatalf("rewritten trace differs from the original: %d bytes, expected %d", out.Len(), len(b))
+	}
+}
+
+func TestEncodeUncompressedBlob(t *testing.T) {
+	h := nettrace.BlobHeader{
+		MetadataID:        1,
+		SequenceNumber:    2,
+		ThreadID:          3,
+		CaptureThreadID:   3,
+		CaptureProcNumber: 4,
+		StackID:           5,
+		TimeStamp:         6,
+		ActivityID:        [16]byte{7},
+		RelatedActivityID: [16]byte{8},
+	}
+	payload := []byte{1, 2, 3}
+	var buf bytes.Buffer
+	e := nettrace.NewEncoder(&buf)
+	requireNoError(t, e.EncodeTrace(&nettrace.NewBuilder().Trace))
+	requireNoError(t, e.EncodeEventBlock([]nettrace.Blob{{Header: h, Payload: bytes.NewBuffer(payload)}}, false))
+	requireNoError(t, e.Close())
+
+	dec := nettrace.NewDecoder(&buf)
+	_, err := dec.OpenTrace()
+	requireNoError(t, err)
+	var o nettrace.Object
+	requireNoError(t, dec.Decode(&o))
+
+	// The size does not count the size field itself and the padding.
+	var expected rawBuilder
+	expected.write(int32(76+len(payload)), h.MetadataID, h.SequenceNumber, h.ThreadID, h.CaptureThreadID,
+		h.CaptureProcNumber, h.StackID, h.TimeStamp, h.ActivityID, h.RelatedActivityID, int32(len(payload)), payload,
+		byte(0))
+	if !bytes.HasSuffix(o.Payload.Bytes(), expected.Bytes()) {
+		t.Fatalf("unexpected blob:\n%x\n%x", o.Payload.Bytes(), expected.Bytes())
+	}
+
+	var invalid rawBuilder
+	invalid.write(o.Payload.Bytes())
+	b := invalid.Bytes()
+	b[len(b)-len(expected.Bytes())]++
+	o.Payload = bytes.NewBuffer(b)
+	block, err := nettrace.BlobBlockFromObject(o)
+	requireNoError(t, err)
+	if err = block.Next(new(nettrace.Blob)); err == nil {
+		t.Fatal("expected invalid blob size error")
+	}
+}
@@ -0,0 +1,115 @@
+package nettrace
+
+// Record is a value returned by Iterator: *Event, *Metadata, *StackBlock,
+// *SequencePointBlock, or *LostEvents.
+type Record interface{}
+
+// Event is an event with the metadata and the stack it refers to resolved.
+type Event struct {
+	Blob
+	// Metadata is nil, if the event metadata is unknown.
+	Metadata *Metadata
+	// Stack contains instruction pointers of the event stack, as returned
+	// by Stack.InstructionPointers; nil, if the event has no stack.
+	Stack []uint64
+}
+
+// Iterator returns records of the stream one at a time. Unlike the Stream
+// handlers, it allows consuming the stream in a plain loop:
+//
+//	it := stream.Records()
+//	for {
+//		r, err := it.Next()
+//		if err != nil {
+//			break // io.EOF at the end of the stream.
+//		}
+//		switch x := r.(type) {
+//		case *nettrace.Event:
+//			...
+//		}
+//	}
+//
+// Records are read from the stream in batches, block by block. Stream
+// options, such as Ordered and Manifests, apply to the records.
+type Iterator struct {
+	s       *Stream
+	queue   []Record
+	md      map[int32]*Metadata
+	stacks  map[int32][]uint64
+	stackID int32
+	// err is returned once the queue is drained.
+	err error
+}
+
+// Records returns an Iterator of the stream records. The Iterator replaces
+// the stream handlers: the stream must not be used directly afterwards.
+// The stream must be opened before the first Next call.
+func (s *Stream) Records() *Iterator {
+	it := Iterator{
+		s:      s,
+		md:     make(map[int32]*Metadata),
+		stacks: make(map[int32][]uint64),
+	}
+	s.EventHandler = it.handleEvent
+	s.MetadataHandler = it.handleMetadata
+	s.StackBlockHandler = it.handleStackBlock
+	s.SequencePointBlockHandler = it.handleSequencePointBlock
+	s.LostEventsHandler = it.handleLostEvents
+	return &it
+}
+
+// Next returns the next record of the stream. At the end of the stream
+// io.EOF is returned. If the stream fails, the records read before the
+// error are returned first.
+func (it *Iterator) Next() (Record, error) {
+	for len(it.queue) == 0 {
+		if it.err != nil {
+			return nil, it.err
+		}
+		it.err = it.s.Next()
+	}
+	r := it.queue[0]
+	it.queue[0] = nil
+	it.queue = it.queue[1:]
+	return r, nil
+}
+
+func (it *Iterator) handleEvent(blob *Blob) error {
+	e := Event{
+		Blob:     *blob,
+		Metadata: it.md[blob.Header.MetadataID],
+		Stack:    it.stacks[blob.Header.StackID],
+	}
+	it.queue = append(it.queue, &e)
+	return nil
+}
+
+func (it *Iterator) handleMetadata(md *Metadata) error {
+	it.md[md.Header.MetaDataID] = md
+	it.queue = append(it.queue, md)
+	return nil
+}
+
+func (it *Iterator) handleStackBlock(b *StackBlock) error {
+	size := int32(8)
+	if it.s.trace != nil {
+		size = it.s.trace.PointerSize
+	}
+	for _, s := range b.Stacks {
+		it.stacks[s.ID] = s.InstructionPointers(size)
+	}
+	it.queue = append(it.queue, b)
+	return nil
+}
+
+func (it *Iterator) handleSequencePointBlock(b *SequencePointBlock) error {
+	// Stack IDs are only valid until the next sequence point.
+	it.stacks = make(map[int32][]uint64)
+	it.queue = append(it.queue, b)
+	return nil
+}
+
+func (it *Iterator) handleLostEvents(l *LostEvents) error {
+	it.queue = append(it.queue, l)
+	return nil
+}
@@ -0,0 +1,96 @@
+package nettrace_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+)
+
+func TestIterator(t *testing.T) {
+	b := nettrace.NewBuilder()
+	request := b.Metadata("Test-Provider", 1, "Request")
+	stack := b.Stack(0x1, 0x2)
+	b.Event(nettrace.BlobHeader{MetadataID: request, ThreadID: 1, TimeStamp: 100, StackID: stack}, nil)
+	b.Event(nettrace.BlobHeader{MetadataID: request, ThreadID: 1, TimeStamp: 200}, nil)
+	var buf bytes.Buffer
+	_, err := b.WriteTo(&buf)
+	requireNoError(t, err)
+
+	stream := nettrace.NewStream(&buf)
+	_, err = stream.Open()
+	requireNoError(t, err)
+	it := stream.Records()
+	var records []string
+	for {
+		r, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		requireNoError(t, err)
+		switch x := r.(type) {
+		case *nettrace.Metadata:
+			records = append(records, "metadata "+x.Header.EventName)
+		case *nettrace.StackBlock:
+			records = append(records, fmt.Sprintf("stacks %d", len(x.Stacks)))
+		case *nettrace.Event:
+			records = append(records, fmt.Sprintf("event %s ts=%d stack=%x",
+				x.Metadata.Header.EventName, x.Header.TimeStamp, x.Stack))
+		case *nettrace.SequencePointBlock:
+			records = append(records, "sequence point")
+		default:
+			t.Fatalf("unexpected record: %T", r)
+		}
+	}
+
+	expected := []string{
+		"metadata Request",
+		"stacks 1",
+		"event Request ts=100 stack=[2 1]",
+		"event Request ts=200 stack=[]",
+		"sequence point",
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Fatalf("unexpected records:\n%q", records)
+	}
+}
+
+func TestIteratorOrderedWithoutSequencePoint(t *testing.T) {
+	var b bytes.Buffer
+	enc := nettrace.NewEncoder(&b)
+	requireNoError(t, enc.EncodeTrace(&nettrace.Trace{PointerSize: 8, QPCFrequency: 1e7}))
+	md := &nettrace.Metadata{Header: nettrace.MetadataHeader{MetaDataID: 1, ProviderName: "Test-Provider", EventID: 1}}
+	requireNoError(t, enc.EncodeMetadataBlock([]*nettrace.Metadata{md}, true))
+	requireNoError(t, enc.EncodeEventBlock([]nettrace.Blob{{
+		Header:  nettrace.BlobHeader{MetadataID: 1, ThreadID: 1, CaptureThreadID: 1, SequenceNumber: 1, TimeStamp: 100},
+		Payload: new(bytes.Buffer),
+	}}, true))
+	requireNoError(t, enc.Close())
+
+	stream := nettrace.NewStream(&b)
+	stream.Ordered = true
+	_, err := stream.Open()
+	requireNoError(t, err)
+	it := stream.Records()
+	// The events are released at the end of the stream.
+	var events int
+	for {
+		r, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		requireNoError(t, err)
+		if _, ok := r.(*nettrace.Event); ok {
+			events++
+		}
+	}
+	if events != 1 {
+		t.Fatalf("expected 1 event, got %d", events)
+	}
+	if _, err = it.Next(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package nettrace
+
+// LostEvents describes a gap in the sequence numbers of the events written
+// by a capture thread.
+type LostEvents struct {
+	CaptureThreadID int64
+	// Count of the events lost, if Overflow is true.
+	Count int32
+	// Timestamps of the last event or sequence point observed before the
+	// gap, and of the event or sequence point that revealed it: the events
+	// were lost in between.
+	From int64
+	To   int64
+	// Overflow reports whether the events were dropped by the runtime
+	// because the session buffer was full: the runtime increments the
+	// sequence number of the thread for every event it fails to write.
+	Overflow bool
+	// Restarted reports whether the sequence number of the thread went
+	// backwards, e.g. the stream is a concatenation of streams: the number
+	// of events lost, if any, can not be determined, and Count is zero.
+	Restarted bool
+}
+
+// sequenceTracker detects gaps in sequence numbers of the capture threads.
+type sequenceTracker struct {
+	threads map[int64]sequenceState
+}
+
+type sequenceState struct {
+	seq int32
+	ts  int64
+}
+
+// event returns lost events revealed by the event, if any.
+func (t *sequenceTracker) event(h *BlobHeader) *LostEvents {
+	return t.observe(h.CaptureThreadID, h.SequenceNumber-1, sequenceState{h.SequenceNumber, h.TimeStamp})
+}
+
+// sequencePoint returns lost events revealed by the sequence point block,
+// which specifies the last sequence number of every thread.
+func (t *sequenceTracker) sequencePoint(b *SequencePointBlock) []*LostEvents {
+	var lost []*LostEvents
+	for _, thread := range b.Threads {
+		s := sequenceState{thread.SequenceNumber, b.TimeStamp}
+		if l := t.observe(thread.ThreadID, thread.SequenceNumber, s); l != nil {
+			lost = append(lost, l)
+		}
+	}
+	return lost
+}
+
+// observe updates the thread state, and checks whether the last sequence
+// number observed matches the expected one.
+func (t *sequenceTracker) observe(thread int64, expected int32, s sequenceState) *LostEvents {
+	if t.threads == nil {
+		t.threads = make(map[int64]sequenceState)
+	}
+	last, ok := t.threads[thread]
+	t.threads[thread] = s
+	// Events of a thread that is seen for the first time can not
+	// be checked: the stream may start in the middle of a session.
+	if !ok || last.seq == expected {
+		return nil
+	}
+	l := LostEvents{
+		CaptureThreadID: thread,
+		From:            last.ts,
+		To:              s.ts,
+	}
+	// Sequence numbers wrap around: the difference is a forward gap,
+	// unless it is negative.
+	if d := expected - last.seq; d > 0 {
+		l.Count = d
+		l.Overflow = true
+	} else {
+		l.Restarted = true
+	}
+	return &l
+}
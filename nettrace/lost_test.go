@@ -0,0 +1,71 @@
+package nettrace_test
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+)
+
+func TestStreamLostEvents(t *testing.T) {
+	var b bytes.Buffer
+	enc := nettrace.NewEncoder(&b)
+	requireNoError(t, enc.EncodeTrace(&nettrace.Trace{PointerSize: 8, QPCFrequency: 1e7}))
+	md := &nettrace.Metadata{Header: nettrace.MetadataHeader{MetaDataID: 1, ProviderName: "Test-Provider", EventID: 1}}
+	requireNoError(t, enc.EncodeMetadataBlock([]*nettrace.Metadata{md}, true))
+	event := func(thread int64, seq int32, ts int64) nettrace.Blob {
+		return nettrace.Blob{
+			Header:  nettrace.BlobHeader{MetadataID: 1, ThreadID: thread, CaptureThreadID: thread, SequenceNumber: seq, TimeStamp: ts},
+			Payload: new(bytes.Buffer),
+		}
+	}
+	requireNoError(t, enc.EncodeEventBlock([]nettrace.Blob{
+		// The first event of a thread may have any sequence number.
+		event(1, 10, 100),
+		event(1, 11, 110),
+		// 3 events dropped.
+		event(1, 15, 150),
+		event(2, 1, 160),
+	}, true))
+	// 2 events of the thread 2 dropped after the last one.
+	requireNoError(t, enc.EncodeSequencePointBlock(&nettrace.SequencePointBlock{
+		TimeStamp: 200,
+		Threads:   []nettrace.Thread{{ThreadID: 1, SequenceNumber: 15}, {ThreadID: 2, SequenceNumber: 3}},
+	}))
+	requireNoError(t, enc.EncodeEventBlock([]nettrace.Blob{
+		event(2, 4, 210),
+		// Sequence number went backwards.
+		event(1, 2, 220),
+		// Sequence number wrapped around: 2 events dropped.
+		event(3, math.MaxInt32-1, 230),
+		event(3, math.MinInt32+1, 240),
+	}, true))
+	requireNoError(t, enc.Close())
+
+	stream := nettrace.NewStream(&b)
+	_, err := stream.Open()
+	requireNoError(t, err)
+	var lost []nettrace.LostEvents
+	stream.LostEventsHandler = func(l *nettrace.LostEvents) error {
+		lost = append(lost, *l)
+		return nil
+	}
+	for {
+		if err = stream.Next(); err == io.EOF {
+			break
+		}
+		requireNoError(t, err)
+	}
+	expected := []nettrace.LostEvents{
+		{CaptureThreadID: 1, Count: 3, From: 110, To: 150, Overflow: true},
+		{CaptureThreadID: 2, Count: 2, From: 160, To: 200, Overflow: true},
+		{CaptureThreadID: 1, From: 200, To: 220, Restarted: true},
+		{CaptureThreadID: 3, Count: 2, From: 230, To: 240, Overflow: true},
+	}
+	if !reflect.DeepEqual(lost, expected) {
+		t.Fatalf("unexpected lost events:\n%+v\nexpected:\n%+v", lost, expected)
+	}
+}
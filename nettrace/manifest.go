@@ -0,0 +1,457 @@
+package nettrace
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace/typecode"
+)
+
+// ManifestDataEventID is the ID of EventSource events that carry chunks of
+// the EventSource manifest.
+const ManifestDataEventID = 0xFFFE
+
+var ErrInvalidManifest = errors.New("invalid EventSource manifest")
+
+// Manifest describes an EventSource provider: the events, their payload
+// layout, and the names of tasks, opcodes, keywords and field values.
+type Manifest struct {
+	ProviderName string
+	ProviderGUID string
+	// Event ID -> event.
+	Events map[int32]*ManifestEvent
+	// Map name -> value map, referred by ManifestField.Map.
+	Maps map[string]*ManifestMap
+}
+
+type ManifestEvent struct {
+	ID           int32
+	Version      int32
+	Name         string
+	Level        int32
+	Opcode       uint8
+	OpcodeName   string
+	Task         int32
+	TaskName     string
+	Keywords     int64
+	KeywordNames []string
+	Fields       []ManifestField
+}
+
+type ManifestField struct {
+	Name string
+	// InType is the manifest type, e.g. "win:UnicodeString".
+	InType string
+	// Map is the name of the value map, if any.
+	Map string
+}
+
+// ManifestMap maps field values to names.
+type ManifestMap struct {
+	// Bitmap maps individual bits rather than values.
+	Bitmap bool
+	Values map[uint64]string
+}
+
+// Name returns the name of the value. For bitmaps, names of the set bits
+// are joined with "|". If the value is not mapped, it is formatted as is.
+func (m *ManifestMap) Name(v uint64) string {
+	if !m.Bitmap {
+		if n, ok := m.Values[v]; ok {
+			return n
+		}
+		return strconv.FormatUint(v, 10)
+	}
+	var names []string
+	for b := uint64(1); b != 0 && v != 0; b <<= 1 {
+		if v&b == 0 {
+			continue
+		}
+		n, ok := m.Values[b]
+		if !ok {
+			n = "0x" + strconv.FormatUint(b, 16)
+		}
+		names = append(names, n)
+		v &^= b
+	}
+	return strings.Join(names, "|")
+}
+
+// MetadataFields returns metadata field definitions of the event. If a field
+// type has no metadata counterpart (e.g. win:Binary), ok is false.
+func (e *ManifestEvent) MetadataFields() (fields []MetadataField, ok bool) {
+	for _, f := range e.Fields {
+		t, ok := manifestTypeCodes[f.InType]
+		if !ok {
+			return nil, false
+		}
+		fields = append(fields, MetadataField{Name: f.Name, TypeCode: t})
+	}
+	return fields, true
+}
+
+var manifestTypeCodes = map[string]typecode.TypeCode{
+	"win:UnicodeString": typecode.String,
+	"win:Boolean":       typecode.Boolean,
+	"win:Int8":          typecode.SByte,
+	"win:UInt8":         typecode.Byte,
+	"win:Int16":         typecode.Int16,
+	"win:UInt16":        typecode.UInt16,
+	"win:Int32":         typecode.Int32,
+	"win:UInt32":        typecode.UInt32,
+	"win:HexInt32":      typecode.UInt32,
+	"win:Int64":         typecode.Int64,
+	"win:UInt64":        typecode.UInt64,
+	"win:HexInt64":      typecode.UInt64,
+	"win:Pointer":       typecode.UInt64,
+	"win:Float":         typecode.Single,
+	"win:Double":        typecode.Double,
+	"win:GUID":          typecode.Guid,
+	"win:FILETIME":      typecode.DateTime,
+}
+
+// Apply completes the metadata with the manifest event description:
+// only the members that are not specified are set.
+func (e *ManifestEvent) Apply(md *Metadata) {
+	h := &md.Header
+	if h.EventName == "" {
+		h.EventName = e.Name
+	}
+	if h.Keywords == 0 {
+		h.Keywords = e.Keywords
+	}
+	if h.Level == 0 {
+		h.Level = e.Level
+	}
+	if h.Opcode == 0 {
+		h.Opcode = e.Opcode
+	}
+	if h.Version == 0 {
+		h.Version = MetadataVersion(e.Version)
+	}
+	if len(md.Payload.Fields) == 0 {
+		if fields, ok := e.MetadataFields(); ok {
+			md.Payload.Fields = fields
+		}
+	}
+}
+
+// Standard opcodes and levels, defined in winmeta.xml.
+var (
+	manifestOpcodes = map[string]uint8{
+		"win:Info":      0,
+		"win:Start":     1,
+		"win:Stop":      2,
+		"win:DC_Start":  3,
+		"win:DC_Stop":   4,
+		"win:Extension": 5,
+		"win:Reply":     6,
+		"win:Resume":    7,
+		"win:Suspend":   8,
+		"win:Send":      9,
+		"win:Receive":   240,
+	}
+	manifestLevels = map[string]int32{
+		"win:LogAlways":     0,
+		"win:Critical":      1,
+		"win:Error":         2,
+		"win:Warning":       3,
+		"win:Informational": 4,
+		"win:Verbose":       5,
+	}
+)
+
+type manifestXML struct {
+	Providers []struct {
+		Name  string `xml:"name,attr"`
+		GUID  string `xml:"guid,attr"`
+		Tasks []struct {
+			manifestValueXML
+			Opcodes []manifestValueXML `xml:"opcodes>opcode"`
+		} `xml:"tasks>task"`
+		Opcodes  []manifestValueXML `xml:"opcodes>opcode"`
+		Keywords []struct {
+			Name string `xml:"name,attr"`
+			Mask string `xml:"mask,attr"`
+		} `xml:"keywords>keyword"`
+		Events []struct {
+			Value    string `xml:"value,attr"`
+			Version  string `xml:"version,attr"`
+			Level    string `xml:"level,attr"`
+			Symbol   string `xml:"symbol,attr"`
+			Task     string `xml:"task,attr"`
+			Opcode   string `xml:"opcode,attr"`
+			Keywords string `xml:"keywords,attr"`
+			Template string `xml:"template,attr"`
+		} `xml:"events>event"`
+		Templates []struct {
+			ID   string `xml:"tid,attr"`
+			Data []struct {
+				Name   string `xml:"name,attr"`
+				InType string `xml:"inType,attr"`
+				Map    string `xml:"map,attr"`
+			} `xml:"data"`
+		} `xml:"templates>template"`
+		ValueMaps []manifestMapXML `xml:"maps>valueMap"`
+		BitMaps   []manifestMapXML `xml:"maps>bitMap"`
+	} `xml:"instrumentation>events>provider"`
+	Strings []struct {
+		ID    string `xml:"id,attr"`
+		Value string `xml:"value,attr"`
+	} `xml:"localization>resources>stringTable>string"`
+}
+
+type manifestValueXML struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type manifestMapXML struct {
+	Name string `xml:"name,attr"`
+	Maps []struct {
+		Value   string `xml:"value,attr"`
+		Message string `xml:"message,attr"`
+	} `xml:"map"`
+}
+
+// ParseManifest parses EventSource manifest XML. Only the first provider
+// is taken into account.
+func ParseManifest(data []byte) (*Manifest, error) {
+	var x manifestXML
+	if err := xml.Unmarshal(data, &x); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidManifest, err)
+	}
+	if len(x.Providers) == 0 {
+		return nil, fmt.Errorf("%w: no providers", ErrInvalidManifest)
+	}
+	strs := make(map[string]string, len(x.Strings))
+	for _, s := range x.Strings {
+		strs[s.ID] = s.Value
+	}
+	message := func(s string) string {
+		if strings.HasPrefix(s, "$(string.") && strings.HasSuffix(s, ")") {
+			if v, ok := strs[s[9:len(s)-1]]; ok {
+				return v
+			}
+		}
+		return s
+	}
+
+	p := x.Providers[0]
+	m := Manifest{
+		ProviderName: p.Name,
+		ProviderGUID: p.GUID,
+		Events:       make(map[int32]*ManifestEvent, len(p.Events)),
+		Maps:         make(map[string]*ManifestMap),
+	}
+	tasks := make(map[string]int32)
+	// Opcodes defined within a task are only visible to the task events:
+	// different tasks may define the same opcode name with different values.
+	type opcodeKey struct{ task, name string }
+	opcodes := make(map[opcodeKey]uint8)
+	for k, v := range manifestOpcodes {
+		opcodes[opcodeKey{name: k}] = v
+	}
+	for _, t := range p.Tasks {
+		tasks[t.Name] = int32(parseManifestNumber(t.Value))
+		for _, o := range t.Opcodes {
+			opcodes[opcodeKey{t.Name, o.Name}] = uint8(parseManifestNumber(o.Value))
+		}
+	}
+	for _, o := range p.Opcodes {
+		opcodes[opcodeKey{name: o.Name}] = uint8(parseManifestNumber(o.Value))
+	}
+	keywords := make(map[string]int64)
+	for _, k := range p.Keywords {
+		keywords[k.Name] = int64(parseManifestNumber(k.Mask))
+	}
+	templates := make(map[string][]ManifestField)
+	for _, t := range p.Templates {
+		fields := make([]ManifestField, len(t.Data))
+		for i, d := range t.Data {
+			fields[i] = ManifestField{Name: d.Name, InType: d.InType, Map: d.Map}
+		}
+		templates[t.ID] = fields
+	}
+	for _, maps := range []struct {
+		maps   []manifestMapXML
+		bitmap bool
+	}{{p.ValueMaps, false}, {p.BitMaps, true}} {
+		for _, x := range maps.maps {
+			vm := ManifestMap{Bitmap: maps.bitmap, Values: make(map[uint64]string, len(x.Maps))}
+			for _, v := range x.Maps {
+				vm.Values[parseManifestNumber(v.Value)] = message(v.Message)
+			}
+			m.Maps[x.Name] = &vm
+		}
+	}
+
+	for _, x := range p.Events {
+		opcode, ok := opcodes[opcodeKey{x.Task, x.Opcode}]
+		if !ok {
+			opcode = opcodes[opcodeKey{name: x.Opcode}]
+		}
+		e := ManifestEvent{
+			ID:         int32(parseManifestNumber(x.Value)),
+			Version:    int32(parseManifestNumber(x.Version)),
+			Name:       x.Symbol,
+			Opcode:     opcode,
+			OpcodeName: strings.TrimPrefix(x.Opcode, "win:"),
+			Task:       tasks[x.Task],
+			TaskName:   x.Task,
+			Fields:     templates[x.Template],
+		}
+		if l, ok := manifestLevels[x.Level]; ok {
+			e.Level = l
+		} else {
+			e.Level = int32(parseManifestNumber(x.Level))
+		}
+		for _, k := range strings.Fields(x.Keywords) {
+			e.Keywords |= keywords[k]
+			e.KeywordNames = append(e.KeywordNames, k)
+		}
+		sort.Strings(e.KeywordNames)
+		if e.Name == "" {
+			// EventSource names events after the task and opcode.
+			e.Name = e.TaskName
+			if e.Opcode != 0 {
+				e.Name += e.OpcodeName
+			}
+		}
+		m.Events[e.ID] = &e
+	}
+	return &m, nil
+}
+
+func parseManifestNumber(s string) uint64 {
+	v, _ := strconv.ParseUint(s, 0, 64)
+	return v
+}
+
+// Manifests collects EventSource manifests from ManifestData events
+// and completes metadata of the providers with them.
+type Manifests struct {
+	// Provider name -> manifest.
+	manifests map[string]*Manifest
+	// Provider name -> manifest chunks received so far.
+	chunks map[string]*manifestChunks
+	// MetadataID -> metadata.
+	md map[int32]*Metadata
+}
+
+type manifestChunks struct {
+	next int
+	data bytes.Buffer
+}
+
+// manifestEnvelope precedes every manifest chunk.
+type manifestEnvelope struct {
+	Format       uint8 // 1 - XML.
+	MajorVersion uint8
+	MinorVersion uint8
+	Magic        uint8 // 0x5B.
+	TotalChunks  uint16
+	ChunkNumber  uint16
+}
+
+func NewManifests() *Manifests {
+	return &Manifests{
+		manifests: make(map[string]*Manifest),
+		chunks:    make(map[string]*manifestChunks),
+		md:        make(map[int32]*Metadata),
+	}
+}
+
+// Manifest returns the manifest of the provider, if it has been received.
+func (m *Manifests) Manifest(provider string) (*Manifest, bool) {
+	x, ok := m.manifests[provider]
+	return x, ok
+}
+
+// Event returns the manifest description of the event, if any.
+func (m *Manifests) Event(md *Metadata) (*ManifestEvent, bool) {
+	x, ok := m.manifests[md.Header.ProviderName]
+	if !ok {
+		return nil, false
+	}
+	e, ok := x.Events[md.Header.EventID]
+	return e, ok
+}
+
+// AddMetadata registers the metadata and completes it with the provider
+// manifest, if it has been received.
+func (m *Manifests) AddMetadata(md *Metadata) {
+	m.md[md.Header.MetaDataID] = md
+	if e, ok := m.Event(md); ok {
+		e.Apply(md)
+	}
+}
+
+// manifestMetadata returns the metadata of the event, if it is
+// a ManifestData event.
+func (m *Manifests) manifestMetadata(blob *Blob) (*Metadata, bool) {
+	md, ok := m.md[blob.Header.MetadataID]
+	if !ok || md.Header.EventID != ManifestDataEventID {
+		return nil, false
+	}
+	return md, true
+}
+
+// AddEvent handles ManifestData events: once the manifest is complete,
+// it is parsed and applied to the provider metadata registered. The
+// function returns the metadata records that have been updated.
+func (m *Manifests) AddEvent(blob *Blob) ([]*Metadata, error) {
+	md, ok := m.manifestMetadata(blob)
+	if !ok {
+		return nil, nil
+	}
+	provider := md.Header.ProviderName
+	var h manifestEnvelope
+	p := Parser{Buffer: bytes.NewBuffer(blob.Payload.Bytes())}
+	p.Read(&h)
+	if err := p.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidManifest, err)
+	}
+	if h.Format != 1 || h.Magic != 0x5B {
+		return nil, fmt.Errorf("%w: unsupported format %d", ErrInvalidManifest, h.Format)
+	}
+	c, ok := m.chunks[provider]
+	if !ok || h.ChunkNumber == 0 {
+		c = new(manifestChunks)
+		m.chunks[provider] = c
+	}
+	if int(h.ChunkNumber) != c.next {
+		delete(m.chunks, provider)
+		return nil, fmt.Errorf("%w: chunk %d is missing", ErrInvalidManifest, c.next)
+	}
+	c.next++
+	c.data.Write(p.Bytes())
+	if c.next < int(h.TotalChunks) {
+		return nil, nil
+	}
+	delete(m.chunks, provider)
+	x, err := ParseManifest(c.data.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	m.manifests[provider] = x
+	var updated []*Metadata
+	for _, md := range m.md {
+		if md.Header.ProviderName != provider {
+			continue
+		}
+		if e, ok := x.Events[md.Header.EventID]; ok {
+			e.Apply(md)
+			updated = append(updated, md)
+		}
+	}
+	sort.Slice(updated, func(i, j int) bool {
+		return updated[i].Header.MetaDataID < updated[j].Header.MetaDataID
+	})
+	return updated, nil
+}
@@ -0,0 +1,192 @@
+package nettrace_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+)
+
+const testManifest = `<instrumentationManifest xmlns="http://schemas.microsoft.com/win/2004/08/events">
+ <instrumentation xmlns:win="http://manifests.microsoft.com/win/2004/08/windows/events">
+  <events xmlns="http://schemas.microsoft.com/win/2004/08/events">
+   <provider name="Test-Provider" guid="{e13c0d23-ccbc-4e12-931b-d9cc2eee27e4}" symbol="TestProvider">
+    <tasks>
+     <task name="Request" message="$(string.task_Request)" value="1"/>
+    </tasks>
+    <keywords>
+     <keyword name="Http" message="$(string.keyword_Http)" mask="0x1"/>
+    </keywords>
+    <maps>
+     <valueMap name="Status">
+      <map value="0" message="$(string.map_Status.OK)"/>
+      <map value="1" message="$(string.map_Status.Failed)"/>
+     </valueMap>
+    </maps>
+    <events>
+     <event value="1" version="2" level="win:Informational" task="Request" opcode="win:Start" keywords="Http" template="RequestStartArgs"/>
+    </events>
+    <templates>
+     <template tid="RequestStartArgs">
+      <data name="Url" inType="win:UnicodeString"/>
+      <data name="Status" inType="win:Int32" map="Status"/>
+     </template>
+    </templates>
+   </provider>
+  </events>
+ </instrumentation>
+ <localization>
+  <resources culture="en-US">
+   <stringTable>
+    <string id="map_Status.OK" value="OK"/>
+    <string id="map_Status.Failed" value="Failed"/>
+   </stringTable>
+  </resources>
+ </localization>
+</instrumentationManifest>`
+
+func TestStreamManifests(t *testing.T) {
+	b := nettrace.NewBuilder()
+	manifestID := b.Metadata("Test-Provider", 0xFFFE, "ManifestData")
+	requestID := b.Metadata("Test-Provider", 1, "")
+	m := []byte(testManifest)
+	for i, chunk := range [][]byte{m[:100], m[100:]} {
+		var p rawBuilder
+		p.write([4]byte{1, 1, 0, 0x5B}, uint16(2), uint16(i), chunk)
+		b.Event(nettrace.BlobHeader{MetadataID: manifestID, ThreadID: 1, TimeStamp: 1001}, p.Bytes())
+	}
+	var payload rawBuilder
+	payload.write(utf16NTS("/api"), int32(1))
+	b.Event(nettrace.BlobHeader{MetadataID: requestID, ThreadID: 1, TimeStamp: 1002}, payload.Bytes())
+	var buf bytes.Buffer
+	_, err := b.WriteTo(&buf)
+	requireNoError(t, err)
+
+	stream := nettrace.NewStream(&buf)
+	stream.Manifests = nettrace.NewManifests()
+	_, err = stream.Open()
+	requireNoError(t, err)
+	md := make(map[int32]*nettrace.Metadata)
+	var calls int
+	stream.MetadataHandler = func(m *nettrace.Metadata) error {
+		md[m.Header.MetaDataID] = m
+		calls++
+		return nil
+	}
+	var fields map[string]interface{}
+	stream.EventHandler = func(blob *nettrace.Blob) error {
+		if blob.Header.MetadataID != 2 {
+			return nil
+		}
+		fields, err = nettrace.DecodePayloadMap(blob, md[blob.Header.MetadataID])
+		return err
+	}
+	for {
+		err = stream.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		requireNoError(t, err)
+	}
+
+	h := md[2].Header
+	if calls != 3 || h.EventName != "RequestStart" || h.Keywords != 1 || h.Level != 4 || h.Opcode != 1 || h.Version != 2 {
+		t.Fatalf("unexpected metadata: %d calls, %+v", calls, h)
+	}
+	if fields["Url"] != "/api" || fields["Status"] != int32(1) {
+		t.Fatalf("unexpected fields: %+v", fields)
+	}
+	e, ok := stream.Manifests.Event(md[2])
+	if !ok || e.TaskName != "Request" || e.OpcodeName != "Start" || len(e.KeywordNames) != 1 {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+	manifest, _ := stream.Manifests.Manifest("Test-Provider")
+	if s := manifest.Maps[e.Fields[1].Map].Name(1); s != "Failed" {
+		t.Fatalf("unexpected status: %s", s)
+	}
+}
+
+func TestStreamManifestsOrdered(t *testing.T) {
+	b := nettrace.NewBuilder()
+	manifestID := b.Metadata("Test-Provider", 0xFFFE, "ManifestData")
+	requestID := b.Metadata("Test-Provider", 1, "")
+	var payload rawBuilder
+	payload.write(utf16NTS("/api"), int32(1))
+	b.Event(nettrace.BlobHeader{MetadataID: requestID, ThreadID: 1, TimeStamp: 1000}, payload.Bytes())
+	m := []byte(testManifest)
+	for i, chunk := range [][]byte{m[:100], m[100:]} {
+		var p rawBuilder
+		p.write([4]byte{1, 1, 0, 0x5B}, uint16(2), uint16(i), chunk)
+		b.Event(nettrace.BlobHeader{MetadataID: manifestID, ThreadID: 1, TimeStamp: 1001}, p.Bytes())
+	}
+	b.Event(nettrace.BlobHeader{MetadataID: requestID, ThreadID: 1, TimeStamp: 1002}, payload.Bytes())
+	var buf bytes.Buffer
+	_, err := b.WriteTo(&buf)
+	requireNoError(t, err)
+
+	stream := nettrace.NewStream(&buf)
+	stream.Manifests = nettrace.NewManifests()
+	stream.Ordered = true
+	_, err = stream.Open()
+	requireNoError(t, err)
+	md := make(map[int32]*nettrace.Metadata)
+	stream.MetadataHandler = func(m *nettrace.Metadata) error {
+		md[m.Header.MetaDataID] = m
+		return nil
+	}
+	var names []string
+	stream.EventHandler = func(blob *nettrace.Blob) error {
+		if blob.Header.MetadataID == requestID {
+			names = append(names, md[requestID].Header.EventName)
+		}
+		return nil
+	}
+	for {
+		err = stream.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		requireNoError(t, err)
+	}
+	// The event read before the manifest is handled before the metadata is completed.
+	if !reflect.DeepEqual(names, []string{"", "RequestStart"}) {
+		t.Fatalf("unexpected event names: %q", names)
+	}
+}
+
+func TestParseManifestTaskOpcodes(t *testing.T) {
+	m, err := nettrace.ParseManifest([]byte(`<instrumentationManifest>
+ <instrumentation>
+  <events>
+   <provider name="Test-Provider" guid="{e13c0d23-ccbc-4e12-931b-d9cc2eee27e4}">
+    <tasks>
+     <task name="Request" value="1">
+      <opcodes>
+       <opcode name="Send" value="10"/>
+      </opcodes>
+     </task>
+     <task name="Response" value="2">
+      <opcodes>
+       <opcode name="Send" value="11"/>
+      </opcodes>
+     </task>
+    </tasks>
+    <events>
+     <event value="1" task="Request" opcode="Send"/>
+     <event value="2" task="Response" opcode="Send"/>
+     <event value="3" task="Response" opcode="win:Stop"/>
+    </events>
+   </provider>
+  </events>
+ </instrumentation>
+</instrumentationManifest>`))
+	requireNoError(t, err)
+	for id, opcode := range map[int32]uint8{1: 10, 2: 11, 3: 2} {
+		if e := m.Events[id]; e.Opcode != opcode {
+			t.Fatalf("event %d: expected opcode %d, got %d", id, opcode, e.Opcode)
+		}
+	}
+}
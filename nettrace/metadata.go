@@ -1,7 +1,9 @@
 package nettrace
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 
 	"github.com/pyroscope-io/dotnetdiag/nettrace/typecode"
 )
@@ -12,16 +14,25 @@ type Metadata struct {
 	Header  MetadataHeader
 	Payload MetadataPayload
 	p       *Parser
+	// Strings are UTF8 encoded in format version 6.
+	utf8 bool
+	// Fields are specified with V2 parameters metadata tag.
+	v2Params bool
 }
 
 type MetadataHeader struct {
 	MetaDataID   int32
 	ProviderName string
-	EventID      int32
-	EventName    string
-	Keywords     int64
-	Version      MetadataVersion
-	Level        int32
+	// ProviderID is only specified in MetadataLegacyV1 format,
+	// which does not include the provider name.
+	ProviderID [16]byte
+	EventID    int32
+	EventName  string
+	Keywords   int64
+	Version    MetadataVersion
+	Level      int32
+	// Opcode is specified with a metadata tag (format version 5 and newer).
+	Opcode uint8
 }
 
 type MetadataVersion int32
@@ -29,58 +40,163 @@ type MetadataVersion int32
 const (
 	_ MetadataVersion = iota
 
-	MetadataLegacyV1 // Used by NetPerf version 1
-	MetadataLegacyV2 // Used by NetPerf version 2
-	MetadataNetTrace // Used by NetPerf (version 3) and NetTrace (version 4+)
+	MetadataLegacyV1   // Used by NetPerf version 1
+	MetadataLegacyV2   // Used by NetPerf version 2
+	MetadataNetTrace   // Used by NetPerf (version 3) and NetTrace (versions 4-5)
+	MetadataNetTraceV6 // Used by NetTrace (version 6+)
 )
 
 type MetadataPayload struct {
 	Fields []MetadataField
 }
 
+// MetadataTagKind identifies an optional metadata tag that follows the
+// field definitions, in format version 5 and newer.
+type MetadataTagKind byte
+
+const (
+	_ MetadataTagKind = iota
+
+	// TagKindOpcode tag payload is a single byte opcode.
+	TagKindOpcode
+	// TagKindV2Params tag payload contains V2 field definitions, that
+	// replace V1 ones: the field count in the metadata must be zero.
+	TagKindV2Params
+)
+
 type MetadataField struct {
 	TypeCode typecode.TypeCode
 	// ArrayTypeCode is an optional field only appears when TypeCode is Array.
 	ArrayTypeCode typecode.TypeCode
 	// For primitive types and strings Payload is not present, however if TypeCode is Object (1)
 	// then Payload is another payload description (that is a field count, followed by a list of
-	// field definitions). These can be nested to arbitrary depth.
+	// field definitions). These can be nested to arbitrary depth. Payload of an array describes
+	// the elements, if ArrayTypeCode is Object.
 	Payload MetadataPayload
+	// Element describes the elements of an array of arrays: it is only
+	// present if both TypeCode and ArrayTypeCode are Array.
+	Element *MetadataField
 	Name    string
 }
 
+// element returns the description of the array elements.
+func (f *MetadataField) element() MetadataField {
+	if f.Element != nil {
+		return *f.Element
+	}
+	return MetadataField{TypeCode: f.ArrayTypeCode, Payload: f.Payload, Name: f.Name}
+}
+
 func MetadataFromBlob(blob Blob) (*Metadata, error) {
+	return metadataFromBlob(blob, MetadataNetTrace)
+}
+
+// metadataVersion returns metadata format used by the given stream
+// format version.
+func metadataVersion(formatVersion int32) MetadataVersion {
+	switch {
+	case formatVersion == 1:
+		return MetadataLegacyV1
+	case formatVersion == 2:
+		return MetadataLegacyV2
+	case formatVersion >= netTraceV6:
+		return MetadataNetTraceV6
+	default:
+		return MetadataNetTrace
+	}
+}
+
+func metadataFromBlob(blob Blob, v MetadataVersion) (*Metadata, error) {
+	if v == MetadataNetTraceV6 {
+		return metadataV6FromBlob(blob)
+	}
 	md := Metadata{p: &Parser{Buffer: blob.Payload}}
 	md.p.Read(&md.Header.MetaDataID)
-	md.Header.ProviderName = md.p.UTF16NTS()
+	switch v {
+	case MetadataLegacyV1, MetadataLegacyV2:
+		// Provider, event ID and version are followed by the event
+		// metadata of the NetTrace layout, which may be omitted.
+		if v == MetadataLegacyV1 {
+			md.p.Read(&md.Header.ProviderID)
+		} else {
+			md.Header.ProviderName = md.p.UTF16NTS()
+		}
+		md.p.Read(&md.Header.EventID)
+		md.p.Read(&md.Header.Version)
+		var size int32
+		md.p.Read(&size)
+		if size == 0 {
+			return &md, md.p.Err()
+		}
+	default:
+		md.Header.ProviderName = md.p.UTF16NTS()
+	}
 	md.p.Read(&md.Header.EventID)
 	md.Header.EventName = md.p.UTF16NTS()
 	md.p.Read(&md.Header.Keywords)
 	md.p.Read(&md.Header.Version)
 	md.p.Read(&md.Header.Level)
 
-	if err := md.readPayload(&md.Payload); err != nil {
+	if err := md.readPayload(&md.Payload, false); err != nil {
+		return nil, err
+	}
+	if err := md.readTags(); err != nil {
 		return nil, err
 	}
-
-	// Version 5 specifies that following the FieldCount number of fields
-	// there are an optional set of metadata tags, and if the metadata event
-	// specifies a TagKindV2Params tag, the event must have an empty V1
-	// parameter FieldCount and no field definitions. Version 5 is not
-	// supported yet, therefore we expect the buffer is read to the end.
-	// 	if _, err := md.p.ReadByte(); err != io.EOF {
-	// 		return nil, ErrNotImplemented
-	// 	}
 
 	return &md, md.p.Err()
 }
 
-func (md *Metadata) readPayload(mp *MetadataPayload) error {
+// readTags reads metadata tags that may follow the field definitions:
+//   - TagPayloadBytes int32
+//   - TagKind byte
+//   - TagPayload of TagPayloadBytes
+//
+// Unknown tags are ignored.
+func (md *Metadata) readTags() error {
+	for md.p.Len() > 0 {
+		var size int32
+		var kind MetadataTagKind
+		md.p.Read(&size)
+		md.p.Read(&kind)
+		if err := md.p.Err(); err != nil {
+			return err
+		}
+		if size < 0 || int(size) > md.p.Len() {
+			return fmt.Errorf("invalid metadata tag size: %d", size)
+		}
+		payload := md.p.Next(int(size))
+		switch kind {
+		case TagKindOpcode:
+			if len(payload) > 0 {
+				md.Header.Opcode = payload[0]
+			}
+		case TagKindV2Params:
+			if len(md.Payload.Fields) != 0 {
+				return fmt.Errorf("metadata specifies both V1 and V2 parameters")
+			}
+			t := Metadata{p: &Parser{Buffer: bytes.NewBuffer(payload)}}
+			if err := t.readPayload(&md.Payload, true); err != nil {
+				return err
+			}
+			md.v2Params = true
+		}
+	}
+	return md.p.Err()
+}
+
+func (md *Metadata) readPayload(mp *MetadataPayload, v2 bool) error {
 	var count int32
 	md.p.Read(&count)
 	for i := int32(0); i < count; i++ {
 		var f MetadataField
-		if err := md.readField(&f); err != nil {
+		var err error
+		if v2 {
+			err = md.readFieldV2(&f)
+		} else {
+			err = md.readField(&f)
+		}
+		if err != nil {
 			return err
 		}
 		mp.Fields = append(mp.Fields, f)
@@ -88,20 +204,104 @@ func (md *Metadata) readPayload(mp *MetadataPayload) error {
 	return md.p.Err()
 }
 
+// readField reads V1 field definition: type followed by the name.
 func (md *Metadata) readField(f *MetadataField) error {
+	if err := md.readType(f, false); err != nil {
+		return err
+	}
+	f.Name = md.p.UTF16NTS()
+	return md.p.Err()
+}
+
+// readFieldV2 reads V2 field definition: the size of the definition,
+// the name, and the type.
+func (md *Metadata) readFieldV2(f *MetadataField) error {
+	var size int32
+	md.p.Read(&size)
+	f.Name = md.string()
+	return md.readType(f, true)
+}
+
+func (md *Metadata) string() string {
+	if md.utf8 {
+		return md.p.UTF8()
+	}
+	return md.p.UTF16NTS()
+}
+
+// Format version 6 optional metadata kinds.
+const (
+	_ byte = iota
+	metadataOpcode
+	metadataKeywords
+	metadataMessageTemplate
+	metadataDescription
+	metadataKeyValue
+	metadataProviderID
+	metadataLevel
+	metadataEventVersion
+)
+
+// metadataV6FromBlob reads format version 6 metadata: the header
+// is followed by V2 field definitions and optional metadata, which
+// specifies opcode, keywords, level and other attributes.
+func metadataV6FromBlob(blob Blob) (*Metadata, error) {
+	md := Metadata{p: &Parser{Buffer: blob.Payload}, utf8: true}
+	md.Header.MetaDataID = int32(md.p.Uvarint())
+	md.Header.ProviderName = md.p.UTF8()
+	md.Header.EventID = int32(md.p.Uvarint())
+	md.Header.EventName = md.p.UTF8()
+	if err := md.readPayload(&md.Payload, true); err != nil {
+		return nil, err
+	}
+	for md.p.Len() > 0 && md.p.Err() == nil {
+		var kind, b byte
+		md.p.Read(&kind)
+		switch kind {
+		case metadataOpcode:
+			md.p.Read(&md.Header.Opcode)
+		case metadataKeywords:
+			md.p.Read(&md.Header.Keywords)
+		case metadataMessageTemplate, metadataDescription:
+			_ = md.p.UTF8()
+		case metadataKeyValue:
+			_, _ = md.p.UTF8(), md.p.UTF8()
+		case metadataProviderID:
+			md.p.Read(&md.Header.ProviderID)
+		case metadataLevel:
+			md.p.Read(&b)
+			md.Header.Level = int32(b)
+		case metadataEventVersion:
+			md.p.Read(&b)
+			md.Header.Version = MetadataVersion(b)
+		default:
+			return nil, fmt.Errorf("unknown optional metadata kind: %d", kind)
+		}
+	}
+	return &md, md.p.Err()
+}
+
+func (md *Metadata) readType(f *MetadataField, v2 bool) error {
 	md.p.Read(&f.TypeCode)
 	switch f.TypeCode {
 	default:
 		// Built-in types do not have payload.
 	case typecode.Array:
-		return ErrNotImplemented
-	case typecode.Object:
-		var p MetadataPayload
-		if err := md.readPayload(&p); err != nil {
+		// Element type, followed by the element fields, if the type
+		// is Object, or the element type of the nested array.
+		var e MetadataField
+		if err := md.readType(&e, v2); err != nil {
 			return err
 		}
-		f.Payload = p
+		f.ArrayTypeCode = e.TypeCode
+		switch e.TypeCode {
+		case typecode.Array:
+			f.Element = &e
+		case typecode.Object:
+			f.Payload = e.Payload
+		}
+	case typecode.Object:
+		return md.readPayload(&f.Payload, v2)
 	}
-	f.Name = md.p.UTF16NTS()
 	return md.p.Err()
 }
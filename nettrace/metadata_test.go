@@ -0,0 +1,198 @@
+package nettrace_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+	"github.com/pyroscope-io/dotnetdiag/nettrace/typecode"
+)
+
+func v2Field(name string, tc typecode.TypeCode, rest ...interface{}) []byte {
+	var f rawBuilder
+	f.write(utf16NTS(name), tc)
+	f.write(rest...)
+	var b rawBuilder
+	b.write(int32(f.Len()+4), f.Bytes())
+	return b.Bytes()
+}
+
+func TestMetadataTags(t *testing.T) {
+	var params rawBuilder
+	params.write(int32(3),
+		v2Field("Count", typecode.Int32),
+		v2Field("Items", typecode.Array, typecode.Object, int32(2),
+			v2Field("Key", typecode.String),
+			v2Field("Value", typecode.Double)),
+		v2Field("Nested", typecode.Object, int32(1),
+			v2Field("Flag", typecode.Boolean)))
+
+	var b rawBuilder
+	b.write(int32(1), utf16NTS("Test-Provider"), int32(7), utf16NTS("TestEvent"), int64(0), int32(0), int32(4))
+	// No V1 fields.
+	b.write(int32(0))
+	// Opcode tag, unknown tag, V2 params tag.
+	b.write(int32(1), byte(1), byte(10))
+	b.write(int32(2), byte(0xFF), []byte{1, 2})
+	b.write(int32(params.Len()), byte(2), params.Bytes())
+
+	md, err := nettrace.MetadataFromBlob(nettrace.Blob{Payload: bytes.NewBuffer(b.Bytes())})
+	requireNoError(t, err)
+	if md.Header.Opcode != 10 || md.Header.EventName != "TestEvent" {
+		t.Fatalf("unexpected header: %+v", md.Header)
+	}
+	f := md.Payload.Fields
+	if len(f) != 3 ||
+		f[0].Name != "Count" || f[0].TypeCode != typecode.Int32 ||
+		f[1].Name != "Items" || f[1].TypeCode != typecode.Array || f[1].ArrayTypeCode != typecode.Object ||
+		len(f[1].Payload.Fields) != 2 || f[1].Payload.Fields[1].Name != "Value" ||
+		f[2].Name != "Nested" || len(f[2].Payload.Fields) != 1 || f[2].Payload.Fields[0].TypeCode != typecode.Boolean {
+		t.Fatalf("unexpected fields: %+v", f)
+	}
+}
+
+func TestMetadataArrayFields(t *testing.T) {
+	var b rawBuilder
+	b.write(int32(1), utf16NTS("Test-Provider"), int32(7), utf16NTS("TestEvent"), int64(0), int32(0), int32(4))
+	b.write(int32(2),
+		typecode.Array, typecode.Int64, utf16NTS("Values"),
+		typecode.Array, typecode.Object, int32(1), typecode.String, utf16NTS("Name"), utf16NTS("Items"))
+
+	md, err := nettrace.MetadataFromBlob(nettrace.Blob{Payload: bytes.NewBuffer(b.Bytes())})
+	requireNoError(t, err)
+	f := md.Payload.Fields
+	if len(f) != 2 ||
+		f[0].Name != "Values" || f[0].TypeCode != typecode.Array || f[0].ArrayTypeCode != typecode.Int64 ||
+		f[1].Name != "Items" || f[1].ArrayTypeCode != typecode.Object ||
+		len(f[1].Payload.Fields) != 1 || f[1].Payload.Fields[0].Name != "Name" {
+		t.Fatalf("unexpected fields: %+v", f)
+	}
+}
+
+func TestMetadataNestedArrays(t *testing.T) {
+	var params rawBuilder
+	params.write(int32(2),
+		v2Field("Matrix", typecode.Array, typecode.Array, typecode.Int32),
+		v2Field("Groups", typecode.Array, typecode.Array, typecode.Object, int32(1),
+			v2Field("Name", typecode.String)))
+	var b rawBuilder
+	b.write(int32(1), utf16NTS("Test-Provider"), int32(7), utf16NTS("TestEvent"), int64(0), int32(0), int32(4))
+	b.write(int32(0), int32(params.Len()), byte(2), params.Bytes())
+
+	md, err := nettrace.MetadataFromBlob(nettrace.Blob{Payload: bytes.NewBuffer(b.Bytes())})
+	requireNoError(t, err)
+	f := md.Payload.Fields
+	if len(f) != 2 ||
+		f[0].ArrayTypeCode != typecode.Array || f[0].Element.ArrayTypeCode != typecode.Int32 ||
+		f[1].ArrayTypeCode != typecode.Array || f[1].Element.ArrayTypeCode != typecode.Object ||
+		len(f[1].Element.Payload.Fields) != 1 || f[1].Element.Payload.Fields[0].Name != "Name" {
+		t.Fatalf("unexpected fields: %+v", f)
+	}
+	encoded, err := nettrace.EncodeMetadata(md)
+	requireNoError(t, err)
+	if !bytes.Equal(encoded, b.Bytes()) {
+		t.Fatalf("metadata is not encoded back:\n%x\n%x", encoded, b.Bytes())
+	}
+
+	var p rawBuilder
+	p.write(uint16(2), uint16(2), int32(1), int32(2), uint16(1), int32(3))
+	p.write(uint16(1), uint16(1), utf16NTS("a"))
+	fields, err := nettrace.DecodePayload(&nettrace.Blob{Payload: bytes.NewBuffer(p.Bytes())}, md)
+	requireNoError(t, err)
+	expected := []nettrace.PayloadField{
+		{Name: "Matrix", Value: []interface{}{
+			[]interface{}{int32(1), int32(2)},
+			[]interface{}{int32(3)},
+		}},
+		{Name: "Groups", Value: []interface{}{
+			[]interface{}{[]nettrace.PayloadField{{Name: "Name", Value: "a"}}},
+		}},
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Fatalf("unexpected fields:\n%+v\n%+v", fields, expected)
+	}
+}
+
+type errorObserver struct {
+	nettrace.NopObserver
+	errs []error
+}
+
+func (o *errorObserver) Error(err error) { o.errs = append(o.errs, err) }
+
+func TestStreamSkipsInvalidMetadata(t *testing.T) {
+	b := nettrace.NewBuilder()
+	field := nettrace.MetadataField{TypeCode: typecode.Int32, Name: "A"}
+	invalidID := b.Metadata("Test-Provider", 8, "Invalid", field)
+	eventID := b.Metadata("Test-Provider", 7, "TestEvent")
+	b.Event(nettrace.BlobHeader{MetadataID: eventID, ThreadID: 1, TimeStamp: 1001}, nil)
+	b.Event(nettrace.BlobHeader{MetadataID: invalidID, ThreadID: 1, TimeStamp: 1002}, nil)
+	var buf bytes.Buffer
+	_, err := b.WriteTo(&buf)
+	requireNoError(t, err)
+
+	// The field count exceeds the record: the count precedes the field
+	// type code and the name.
+	valid, err := nettrace.EncodeMetadata(&nettrace.Metadata{
+		Header: nettrace.MetadataHeader{
+			MetaDataID:   invalidID,
+			ProviderName: "Test-Provider",
+			EventID:      8,
+			EventName:    "Invalid",
+		},
+		Payload: nettrace.MetadataPayload{Fields: []nettrace.MetadataField{field}},
+	})
+	requireNoError(t, err)
+	invalid := append([]byte(nil), valid...)
+	binary.LittleEndian.PutUint32(invalid[len(invalid)-12:], 100)
+	data := buf.Bytes()
+	i := bytes.Index(data, valid)
+	if i < 0 {
+		t.Fatal("metadata record not found")
+	}
+	copy(data[i:], invalid)
+
+	stream := nettrace.NewStream(bytes.NewReader(data))
+	o := new(errorObserver)
+	stream.Observer = o
+	_, err = stream.Open()
+	requireNoError(t, err)
+	var names []string
+	var events int
+	stream.MetadataHandler = func(md *nettrace.Metadata) error {
+		names = append(names, md.Header.EventName)
+		return nil
+	}
+	stream.EventHandler = func(*nettrace.Blob) error {
+		events++
+		return nil
+	}
+	var skipped [][]byte
+	stream.InvalidMetadataHandler = func(blob *nettrace.Blob, err error) error {
+		if err == nil {
+			t.Fatal("expected decoding error")
+		}
+		skipped = append(skipped, blob.Payload.Bytes())
+		return nil
+	}
+	for {
+		err = stream.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		requireNoError(t, err)
+	}
+	if len(names) != 1 || names[0] != "TestEvent" || events != 2 {
+		t.Fatalf("metadata: %v, events: %d", names, events)
+	}
+	if len(o.errs) != 1 {
+		t.Fatalf("unexpected errors: %v", o.errs)
+	}
+	if len(skipped) != 1 || !bytes.Equal(skipped[0], invalid) {
+		t.Fatalf("unexpected skipped metadata: %x", skipped)
+	}
+}
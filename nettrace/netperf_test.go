@@ -0,0 +1,156 @@
+package nettrace_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+)
+
+// rawBuilder writes little-endian values of the streams and records that
+// can not be produced with Builder: NetPerf, NetTrace version 6, and
+// malformed ones.
+type rawBuilder struct{ bytes.Buffer }
+
+func (b *rawBuilder) write(v ...interface{}) {
+	for _, x := range v {
+		_ = binary.Write(b, binary.LittleEndian, x)
+	}
+}
+
+func utf16NTS(s string) []uint16 {
+	return append(utf16.Encode([]rune(s)), 0)
+}
+
+func (b *rawBuilder) header(version int32) {
+	b.write(int32(20), []byte("!FastSerialization.1"))
+	name := "Microsoft.DotNet.Runtime.EventPipeFile"
+	b.write([3]byte{5, 5, 1}, version, int32(0), int32(len(name)), []byte(name), byte(6))
+	if version < 3 {
+		// Forward reference, start time, QPC timestamp and frequency.
+		b.write(int32(0), [8]int16{2021, 5, 2, 4}, int64(1000), int64(1000000))
+	} else {
+		// Start time, QPC timestamp and frequency, pointer size,
+		// process ID, number of processors and sampling rate.
+		b.write([8]int16{2021, 5, 2, 4}, int64(1000), int64(1000000), int32(8), [3]int32{})
+	}
+	b.write(byte(6))
+}
+
+// metadata returns a metadata record payload.
+func metadata(version int32, id int32, provider string, eventID int32, name string) []byte {
+	var b rawBuilder
+	b.write(id)
+	if version < 3 {
+		b.write(utf16NTS(provider), eventID, int32(0))
+		var md rawBuilder
+		md.write(eventID, utf16NTS(name), int64(0), int32(0), int32(4), int32(0))
+		b.write(int32(md.Len()), md.Bytes())
+		return b.Bytes()
+	}
+	b.write(utf16NTS(provider), eventID, utf16NTS(name), int64(0), int32(0), int32(4), int32(0))
+	return b.Bytes()
+}
+
+func (b *rawBuilder) record(metadataID int32, ts int64, payload []byte, stack []uint64) {
+	var r rawBuilder
+	r.write(metadataID, int32(1), ts, [16]byte{}, [16]byte{}, int32(len(payload)), payload)
+	r.write(int32(len(stack)*8), stack)
+	if pad := (r.Len() + 4) % 4; pad != 0 {
+		r.write(make([]byte, 4-pad))
+	}
+	b.write(int32(r.Len()), r.Bytes())
+}
+
+func (b *rawBuilder) records(version int32) {
+	b.record(0, 0, metadata(version, 1, "Test-Provider", 7, "TestEvent"), nil)
+	b.record(1, 1001, []byte{1, 2, 3}, []uint64{0x10, 0x20})
+	b.record(1, 1002, []byte{4}, []uint64{0x10, 0x20})
+	b.record(1, 1003, nil, nil)
+}
+
+// eventBlock writes the last NetPerf version 3 EventBlock object.
+func (b *rawBuilder) eventBlock(records []byte) {
+	name := "EventBlock"
+	b.write([3]byte{5, 5, 1}, int32(1), int32(0), int32(len(name)), []byte(name), byte(6))
+	b.write(int32(len(records)))
+	if pad := b.Len() % 4; pad != 0 {
+		b.write(make([]byte, 4-pad))
+	}
+	// EndObject and NullReference tags.
+	b.write(records, byte(6), byte(1))
+}
+
+func TestNetPerfDecoding(t *testing.T) {
+	t.Run("Version 3", func(t *testing.T) {
+		var b rawBuilder
+		b.header(3)
+		var records rawBuilder
+		records.records(3)
+		b.eventBlock(records.Bytes())
+		requireNetPerf(t, b.Bytes(), 3)
+	})
+
+	t.Run("Version 2", func(t *testing.T) {
+		var b rawBuilder
+		b.header(2)
+		b.records(2)
+		// NullReference tag, followed by the forward reference table.
+		end := int32(b.Len())
+		b.write(byte(1), int32(1), end, end+1)
+		requireNetPerf(t, b.Bytes(), 2)
+	})
+}
+
+func requireNetPerf(t *testing.T, data []byte, version int32) {
+	t.Helper()
+	stream := nettrace.NewStream(bytes.NewReader(data))
+	trace, err := stream.Open()
+	requireNoError(t, err)
+	if trace.QPCFrequency != 1000000 || trace.PointerSize != 8 {
+		t.Fatalf("unexpected trace: %+v", trace)
+	}
+
+	var events, stacks, sequencePoints int
+	stackIDs := make(map[int32]struct{})
+	var md *nettrace.Metadata
+	stream.MetadataHandler = func(m *nettrace.Metadata) error {
+		md = m
+		return nil
+	}
+	stream.StackBlockHandler = func(b *nettrace.StackBlock) error {
+		stacks += len(b.Stacks)
+		return nil
+	}
+	stream.SequencePointBlockHandler = func(*nettrace.SequencePointBlock) error {
+		sequencePoints++
+		return nil
+	}
+	stream.EventHandler = func(e *nettrace.Blob) error {
+		if md == nil || md.Header.MetaDataID != e.Header.MetadataID {
+			t.Fatal("metadata is not handled before the event")
+		}
+		if e.Header.StackID != 0 {
+			stackIDs[e.Header.StackID] = struct{}{}
+		}
+		events++
+		return nil
+	}
+	for {
+		err = stream.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		requireNoError(t, err)
+	}
+	if md.Header.ProviderName != "Test-Provider" || md.Header.EventID != 7 || md.Header.EventName != "TestEvent" {
+		t.Fatalf("unexpected metadata: %+v", md.Header)
+	}
+	if events != 3 || stacks != 1 || len(stackIDs) != 1 || sequencePoints != 1 {
+		t.Fatalf("version %d: events: %d, stacks: %d, sequence points: %d", version, events, stacks, sequencePoints)
+	}
+}
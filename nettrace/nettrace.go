@@ -1,17 +1,60 @@
 package nettrace
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"io"
+	"time"
 )
 
 type Stream struct {
-	dec *Decoder
+	dec   *Decoder
+	trace *Trace
 
 	EventHandler              func(*Blob) error
 	MetadataHandler           func(*Metadata) error
 	StackBlockHandler         func(*StackBlock) error
 	SequencePointBlockHandler func(*SequencePointBlock) error
+
+	// Format version 6 handlers: threads and label lists are defined
+	// before the events that refer to them.
+	ThreadBlockHandler       func(*ThreadBlock) error
+	RemoveThreadBlockHandler func(*RemoveThreadBlock) error
+	LabelListBlockHandler    func(*LabelListBlock) error
+
+	// Observer, if specified, is notified about objects decoded, events
+	// handled, and errors.
+	Observer Observer
+	// Manifests, if specified, collects EventSource manifests and completes
+	// metadata of the providers. Once a manifest is received, MetadataHandler
+	// is called again for the provider metadata records handled before.
+	Manifests *Manifests
+	// Ordered, if set, makes the stream deliver events to EventHandler in
+	// the timestamp order: events are buffered, and released before the
+	// sequence point block is handled, and at the end of the stream. Within
+	// a block, events are only sorted per thread; sequence points guarantee
+	// that all events preceding them have been written. NetPerf streams do
+	// not contain sequence points, and events are released at every block.
+	// Buffered events are also released before a manifest event is
+	// handled, as the manifest may complete their metadata (see Manifests).
+	Ordered bool
+	// LostEventsHandler, if specified, is called when a gap in sequence
+	// numbers of a capture thread is detected: before the event or the
+	// sequence point block that revealed it is handled. With Ordered set,
+	// a gap revealed by an event is reported when the event is released.
+	// NetPerf streams do not specify sequence numbers.
+	LostEventsHandler func(*LostEvents) error
+	// InvalidMetadataHandler, if specified, is called with a metadata record
+	// that can not be decoded, and the decoding error. By default, such
+	// records are skipped, and events that refer to them are still passed
+	// to EventHandler. The error is also reported to the observer.
+	InvalidMetadataHandler func(*Blob, error) error
+	// MetadataID -> metadata header, only maintained for the observer.
+	md  map[int32]MetadataHeader
+	seq sequenceTracker
+	// Events buffered in the ordered mode.
+	pending []pendingEvent
 }
 
 func NewStream(r io.Reader) *Stream {
@@ -19,24 +62,69 @@ func NewStream(r io.Reader) *Stream {
 }
 
 func (s *Stream) Open() (*Trace, error) {
-	return s.dec.OpenTrace()
+	s.dec.Observer = s.Observer
+	t, err := s.dec.OpenTrace()
+	s.trace = t
+	return t, err
 }
 
 func (s *Stream) Next() error {
+	s.dec.Observer = s.Observer
+	err := s.next()
+	var de *decodingError
+	switch {
+	case errors.As(err, &de):
+		// Decoding errors are reported by the decoder.
+		return de.err
+	case err != nil && s.Observer != nil:
+		s.Observer.Error(err)
+	}
+	return err
+}
+
+// decodingError wraps errors returned by the decoder.
+type decodingError struct{ err error }
+
+func (e *decodingError) Error() string { return e.err.Error() }
+
+func (e *decodingError) Unwrap() error { return e.err }
+
+func (s *Stream) next() error {
 	var o Object
 	if err := s.dec.Decode(&o); err != nil {
-		return err
+		if errors.Is(err, io.EOF) {
+			if rerr := s.releaseEvents(); rerr != nil {
+				return rerr
+			}
+		}
+		return &decodingError{err}
+	}
+	if s.dec.version < netTraceFormatVersion && (o.Type == ObjectTypeEventBlock || o.Type == ObjectTypeMetadataBlock) {
+		return s.nextLegacy(o)
 	}
 
 	switch o.Type {
 	case ObjectTypeSPBlock:
-		if s.SequencePointBlockHandler == nil {
+		if err := s.releaseEvents(); err != nil {
+			return err
+		}
+		if s.SequencePointBlockHandler == nil && s.LostEventsHandler == nil {
 			return nil
 		}
 		block, err := SequencePointBlockFromObject(o)
 		if err != nil {
 			return err
 		}
+		if s.LostEventsHandler != nil {
+			for _, l := range s.seq.sequencePoint(block) {
+				if err = s.LostEventsHandler(l); err != nil {
+					return err
+				}
+			}
+		}
+		if s.SequencePointBlockHandler == nil {
+			return nil
+		}
 		return s.SequencePointBlockHandler(block)
 
 	case ObjectTypeStackBlock:
@@ -50,7 +138,8 @@ func (s *Stream) Next() error {
 		return s.StackBlockHandler(block)
 
 	case ObjectTypeEventBlock:
-		if s.EventHandler == nil {
+		handle := s.eventHandler()
+		if handle == nil {
 			return nil
 		}
 		block, err := BlobBlockFromObject(o)
@@ -67,13 +156,13 @@ func (s *Stream) Next() error {
 			default:
 				return err
 			}
-			if err = s.EventHandler(&blob); err != nil {
+			if err = handle(&blob); err != nil {
 				return err
 			}
 		}
 
 	case ObjectTypeMetadataBlock:
-		if s.MetadataHandler == nil {
+		if s.MetadataHandler == nil && s.Observer == nil && s.Manifests == nil {
 			return nil
 		}
 		block, err := BlobBlockFromObject(o)
@@ -90,15 +179,41 @@ func (s *Stream) Next() error {
 			default:
 				return err
 			}
-			md, err := MetadataFromBlob(blob)
-			if err != nil {
-				return err
-			}
-			if err = s.MetadataHandler(md); err != nil {
+			if err = s.handleMetadata(blob, metadataVersion(s.dec.version)); err != nil {
 				return err
 			}
 		}
 
+	case ObjectTypeThreadBlock:
+		if s.ThreadBlockHandler == nil {
+			return nil
+		}
+		block, err := ThreadBlockFromObject(o)
+		if err != nil {
+			return err
+		}
+		return s.ThreadBlockHandler(block)
+
+	case ObjectTypeRemoveThreadBlock:
+		if s.RemoveThreadBlockHandler == nil {
+			return nil
+		}
+		block, err := RemoveThreadBlockFromObject(o)
+		if err != nil {
+			return err
+		}
+		return s.RemoveThreadBlockHandler(block)
+
+	case ObjectTypeLabelListBlock:
+		if s.LabelListBlockHandler == nil {
+			return nil
+		}
+		block, err := LabelListBlockFromObject(o)
+		if err != nil {
+			return err
+		}
+		return s.LabelListBlockHandler(block)
+
 	case ObjectTypeTrace:
 		return ErrUnexpectedObjectType
 
@@ -106,3 +221,184 @@ func (s *Stream) Next() error {
 		return ErrInvalidObjectType
 	}
 }
+
+// nextLegacy handles NetPerf block. Stacks are stored inline, therefore for
+// every block a stack block is created; the handlers are called in the same
+// order as for NetTrace: stacks first, then metadata and events, followed by
+// a sequence point which invalidates the stack IDs.
+func (s *Stream) nextLegacy(o Object) error {
+	blobs, err := legacyBlobsFromObject(o)
+	if err != nil {
+		return err
+	}
+	var (
+		stacks   StackBlock
+		stackIDs = make(map[string]int32)
+		sp       SequencePointBlock
+	)
+	for i := range blobs {
+		b := &blobs[i]
+		if b.Header.MetadataID == 0 || len(b.stack) == 0 {
+			continue
+		}
+		id, ok := stackIDs[string(b.stack)]
+		if !ok {
+			id = int32(len(stacks.Stacks) + 1)
+			stackIDs[string(b.stack)] = id
+			stacks.Stacks = append(stacks.Stacks, Stack{ID: id, Data: b.stack})
+		}
+		b.Header.StackID = id
+		if b.Header.TimeStamp > sp.TimeStamp {
+			sp.TimeStamp = b.Header.TimeStamp
+		}
+	}
+	if s.StackBlockHandler != nil && len(stacks.Stacks) > 0 {
+		if err = s.StackBlockHandler(&stacks); err != nil {
+			return err
+		}
+	}
+	handle := s.eventHandler()
+	mdVersion := metadataVersion(s.dec.version)
+	for i := range blobs {
+		b := &blobs[i].Blob
+		if b.Header.MetadataID != 0 {
+			if handle == nil {
+				continue
+			}
+			if err = handle(b); err != nil {
+				return err
+			}
+			continue
+		}
+		if s.MetadataHandler == nil && s.Observer == nil && s.Manifests == nil {
+			continue
+		}
+		if err = s.handleMetadata(*b, mdVersion); err != nil {
+			return err
+		}
+	}
+	if err = s.releaseEvents(); err != nil {
+		return err
+	}
+	if s.SequencePointBlockHandler != nil {
+		return s.SequencePointBlockHandler(&sp)
+	}
+	return nil
+}
+
+// handleMetadata decodes the metadata record and calls MetadataHandler.
+// A record that can not be decoded is passed to InvalidMetadataHandler,
+// and the error is reported to the observer: events that refer to the
+// metadata are still handled.
+func (s *Stream) handleMetadata(blob Blob, v MetadataVersion) error {
+	payload := blob.Payload.Bytes()
+	md, err := metadataFromBlob(blob, v)
+	if err != nil {
+		if s.Observer != nil {
+			s.Observer.Error(fmt.Errorf("skipping metadata record: %w", err))
+		}
+		if s.InvalidMetadataHandler == nil {
+			return nil
+		}
+		blob.Payload = bytes.NewBuffer(payload)
+		return s.InvalidMetadataHandler(&blob, err)
+	}
+	if s.Observer != nil {
+		s.observeMetadata(md)
+	}
+	if s.Manifests != nil {
+		s.Manifests.AddMetadata(md)
+	}
+	if s.MetadataHandler == nil {
+		return nil
+	}
+	return s.MetadataHandler(md)
+}
+
+// eventHandler returns the function that handles events, or nil,
+// if events are not to be handled.
+func (s *Stream) eventHandler() func(*Blob) error {
+	handle := s.EventHandler
+	if handle != nil && s.Observer != nil {
+		handle = s.observeEvent
+	}
+	// Buffered events are checked for gaps by bufferEvent.
+	ordered := handle != nil && s.Ordered
+	if ordered {
+		handle = s.bufferEvent
+	}
+	if s.Manifests != nil {
+		next := handle
+		handle = func(blob *Blob) error {
+			if err := s.handleManifest(blob); err != nil {
+				return err
+			}
+			if next == nil {
+				return nil
+			}
+			return next(blob)
+		}
+	}
+	if s.LostEventsHandler != nil && s.dec.version >= netTraceFormatVersion && !ordered {
+		next := handle
+		handle = func(blob *Blob) error {
+			if l := s.seq.event(&blob.Header); l != nil {
+				if err := s.LostEventsHandler(l); err != nil {
+					return err
+				}
+			}
+			if next == nil {
+				return nil
+			}
+			return next(blob)
+		}
+	}
+	return handle
+}
+
+// handleManifest passes the event to Manifests. Like undecodable metadata,
+// invalid manifests are reported to the observer and skipped.
+func (s *Stream) handleManifest(blob *Blob) error {
+	// Metadata is completed in place: in the ordered mode, events read
+	// before the manifest are released first, and are handled with the
+	// metadata they were read with, like in the unordered mode.
+	if _, ok := s.Manifests.manifestMetadata(blob); ok && s.Ordered {
+		if err := s.releaseEvents(); err != nil {
+			return err
+		}
+	}
+	updated, err := s.Manifests.AddEvent(blob)
+	if err != nil {
+		if s.Observer != nil {
+			s.Observer.Error(fmt.Errorf("skipping manifest: %w", err))
+		}
+		return nil
+	}
+	for _, md := range updated {
+		if s.Observer != nil {
+			s.observeMetadata(md)
+		}
+		if s.MetadataHandler == nil {
+			continue
+		}
+		if err = s.MetadataHandler(md); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Stream) observeMetadata(md *Metadata) {
+	if s.md == nil {
+		s.md = make(map[int32]MetadataHeader)
+	}
+	s.md[md.Header.MetaDataID] = md.Header
+}
+
+func (s *Stream) observeEvent(blob *Blob) error {
+	start := time.Now()
+	err := s.EventHandler(blob)
+	h := s.md[blob.Header.MetadataID]
+	s.Observer.EventHandled(h.ProviderName, h.EventID, s.trace.Time(blob.Header.TimeStamp), time.Since(start))
+	return err
+}
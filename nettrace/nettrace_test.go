@@ -99,3 +99,69 @@ func dump(w io.Writer, samples map[string]time.Duration) {
 		_, _ = fmt.Fprintln(w, n, samples[n].Nanoseconds())
 	}
 }
+
+func TestSampleProfilerDrain(t *testing.T) {
+	s, err := os.Open("testdata/dotnet-5.0-SampleProfiler-webapp.golden.nettrace")
+	requireNoError(t, err)
+	stream := nettrace.NewStream(s)
+	trace, err := stream.Open()
+	requireNoError(t, err)
+
+	p := profiler.NewSampleProfiler(trace)
+	stream.MetadataHandler = p.MetadataHandler
+	stream.StackBlockHandler = p.StackBlockHandler
+	stream.SequencePointBlockHandler = p.SequencePointBlockHandler
+	stream.EventHandler = p.EventHandler
+
+	var total time.Duration
+	drain := func() {
+		for _, x := range p.Drain() {
+			total += x.Value
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if err = stream.Next(); err != nil {
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			if err != io.EOF {
+				requireNoError(t, err)
+			}
+			drain()
+			var retained time.Duration
+			for _, v := range p.Samples() {
+				retained += v
+			}
+			if retained != 0 {
+				t.Fatal("drained samples are retained")
+			}
+			requireTotal(t, "testdata/dotnet-5.0-SampleProfiler-webapp.txt", total)
+			return
+		default:
+			drain()
+		}
+	}
+}
+
+func requireTotal(t *testing.T, expected string, total time.Duration) {
+	t.Helper()
+	e, err := os.ReadFile(expected)
+	requireNoError(t, err)
+	var want time.Duration
+	for _, line := range bytes.Split(bytes.TrimSpace(e), []byte("\n")) {
+		var v int64
+		_, err = fmt.Sscan(string(line[bytes.LastIndexByte(line, ' ')+1:]), &v)
+		requireNoError(t, err)
+		want += time.Duration(v)
+	}
+	if total != want {
+		t.Fatalf("total mismatch: want %v, got %v", want, total)
+	}
+}
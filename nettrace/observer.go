@@ -0,0 +1,28 @@
+package nettrace
+
+import "time"
+
+// Observer receives notifications about the decoding progress. Methods are
+// called synchronously, therefore implementations should not block.
+type Observer interface {
+	// ObjectDecoded is called when an object is decoded; n specifies
+	// the number of bytes read from the stream, including the framing.
+	ObjectDecoded(t ObjectType, n int)
+	// EventHandled is called when the Stream EventHandler returns;
+	// timestamp is the event time, and d is the handler duration.
+	// If metadata of the event is unknown, provider name is empty.
+	EventHandled(provider string, eventID int32, timestamp time.Time, d time.Duration)
+	// Error is called when decoding fails, or a handler returns an error.
+	// io.EOF is not reported.
+	Error(err error)
+}
+
+// NopObserver implements Observer interface and does nothing. It can be
+// embedded by an observer that is only interested in some notifications.
+type NopObserver struct{}
+
+func (NopObserver) ObjectDecoded(ObjectType, int) {}
+
+func (NopObserver) EventHandled(string, int32, time.Time, time.Duration) {}
+
+func (NopObserver) Error(error) {}
@@ -0,0 +1,70 @@
+package nettrace_test
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+)
+
+type countingObserver struct {
+	nettrace.NopObserver
+	objects map[nettrace.ObjectType]int
+	bytes   int
+	events  map[string]int
+}
+
+func (o *countingObserver) ObjectDecoded(t nettrace.ObjectType, n int) {
+	o.objects[t]++
+	o.bytes += n
+}
+
+func (o *countingObserver) EventHandled(provider string, _ int32, _ time.Time, _ time.Duration) {
+	o.events[provider]++
+}
+
+func TestStreamObserver(t *testing.T) {
+	const sample = "testdata/dotnet-5.0-SampleProfiler-single-thread.golden.nettrace"
+	s, err := os.Open(sample)
+	requireNoError(t, err)
+	defer s.Close()
+	fi, err := s.Stat()
+	requireNoError(t, err)
+
+	o := &countingObserver{
+		objects: make(map[nettrace.ObjectType]int),
+		events:  make(map[string]int),
+	}
+	stream := nettrace.NewStream(s)
+	stream.Observer = o
+	_, err = stream.Open()
+	requireNoError(t, err)
+	var events int
+	stream.EventHandler = func(*nettrace.Blob) error {
+		events++
+		return nil
+	}
+	for {
+		if err = stream.Next(); err == io.EOF {
+			break
+		}
+		requireNoError(t, err)
+	}
+
+	// The stream is terminated with a single NullReference tag.
+	if int64(o.bytes)+1 != fi.Size() {
+		t.Fatalf("expected %d bytes decoded, got %d", fi.Size()-1, o.bytes)
+	}
+	if o.objects[nettrace.ObjectTypeTrace] != 1 || o.objects[nettrace.ObjectTypeEventBlock] == 0 {
+		t.Fatalf("unexpected objects: %v", o.objects)
+	}
+	var observed int
+	for _, n := range o.events {
+		observed += n
+	}
+	if observed != events || o.events["Microsoft-DotNETCore-SampleProfiler"] == 0 {
+		t.Fatalf("unexpected events: %v", o.events)
+	}
+}
@@ -0,0 +1,50 @@
+package nettrace
+
+import "sort"
+
+// pendingEvent is an event buffered in the ordered mode, and the gap in
+// the sequence numbers it revealed, if any.
+type pendingEvent struct {
+	blob Blob
+	lost *LostEvents
+}
+
+// bufferEvent keeps the event until the next releaseEvents call. Gaps in
+// the sequence numbers are detected in the order the events are read, and
+// reported when the event that revealed them is released.
+func (s *Stream) bufferEvent(blob *Blob) error {
+	e := pendingEvent{blob: *blob}
+	if s.LostEventsHandler != nil && s.dec.version >= netTraceFormatVersion {
+		e.lost = s.seq.event(&blob.Header)
+	}
+	s.pending = append(s.pending, e)
+	return nil
+}
+
+// releaseEvents delivers buffered events in the timestamp order. Events
+// with equal timestamps are delivered in the order they were read.
+func (s *Stream) releaseEvents() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	sort.SliceStable(s.pending, func(i, j int) bool {
+		return s.pending[i].blob.Header.TimeStamp < s.pending[j].blob.Header.TimeStamp
+	})
+	handle := s.EventHandler
+	if s.Observer != nil {
+		handle = s.observeEvent
+	}
+	pending := s.pending
+	s.pending = nil
+	for i := range pending {
+		if l := pending[i].lost; l != nil {
+			if err := s.LostEventsHandler(l); err != nil {
+				return err
+			}
+		}
+		if err := handle(&pending[i].blob); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package nettrace_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+)
+
+func TestStreamOrdered(t *testing.T) {
+	b := nettrace.NewBuilder()
+	id := b.Metadata("Test-Provider", 1, "Event")
+	// Events are only sorted per thread.
+	for _, e := range []struct {
+		thread int64
+		ts     int64
+	}{{1, 100}, {1, 300}, {2, 200}, {2, 400}, {3, 300}} {
+		b.Event(nettrace.BlobHeader{MetadataID: id, ThreadID: e.thread, TimeStamp: e.ts}, nil)
+	}
+	var buf bytes.Buffer
+	_, err := b.WriteTo(&buf)
+	requireNoError(t, err)
+
+	stream := nettrace.NewStream(&buf)
+	stream.Ordered = true
+	_, err = stream.Open()
+	requireNoError(t, err)
+	var events [][2]int64
+	stream.EventHandler = func(blob *nettrace.Blob) error {
+		events = append(events, [2]int64{blob.Header.ThreadID, blob.Header.TimeStamp})
+		return nil
+	}
+	var released int
+	stream.SequencePointBlockHandler = func(*nettrace.SequencePointBlock) error {
+		released = len(events)
+		return nil
+	}
+	for {
+		if err = stream.Next(); err == io.EOF {
+			break
+		}
+		requireNoError(t, err)
+	}
+
+	expected := [][2]int64{{1, 100}, {2, 200}, {1, 300}, {3, 300}, {2, 400}}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("unexpected order: %v", events)
+	}
+	if released != len(expected) {
+		t.Fatalf("%d events released before the sequence point", released)
+	}
+}
+
+func TestStreamOrderedLostEvents(t *testing.T) {
+	var b bytes.Buffer
+	enc := nettrace.NewEncoder(&b)
+	requireNoError(t, enc.EncodeTrace(&nettrace.Trace{PointerSize: 8, QPCFrequency: 1e7}))
+	md := &nettrace.Metadata{Header: nettrace.MetadataHeader{MetaDataID: 1, ProviderName: "Test-Provider", EventID: 1}}
+	requireNoError(t, enc.EncodeMetadataBlock([]*nettrace.Metadata{md}, true))
+	event := func(thread int64, seq int32, ts int64) nettrace.Blob {
+		return nettrace.Blob{
+			Header:  nettrace.BlobHeader{MetadataID: 1, ThreadID: thread, CaptureThreadID: thread, SequenceNumber: seq, TimeStamp: ts},
+			Payload: new(bytes.Buffer),
+		}
+	}
+	requireNoError(t, enc.EncodeEventBlock([]nettrace.Blob{
+		event(1, 1, 100),
+		// 3 events dropped.
+		event(1, 5, 300),
+		event(2, 1, 200),
+	}, true))
+	requireNoError(t, enc.Close())
+
+	stream := nettrace.NewStream(&b)
+	stream.Ordered = true
+	_, err := stream.Open()
+	requireNoError(t, err)
+	var records []string
+	stream.EventHandler = func(blob *nettrace.Blob) error {
+		records = append(records, fmt.Sprintf("event %d", blob.Header.TimeStamp))
+		return nil
+	}
+	stream.LostEventsHandler = func(l *nettrace.LostEvents) error {
+		records = append(records, fmt.Sprintf("lost %d-%d", l.From, l.To))
+		return nil
+	}
+	for {
+		if err = stream.Next(); err == io.EOF {
+			break
+		}
+		requireNoError(t, err)
+	}
+	// The gap is reported right before the event that revealed it.
+	expected := []string{"event 100", "event 200", "lost 100-300", "event 300"}
+	if !reflect.DeepEqual(records, expected) {
+		t.Fatalf("unexpected records: %q", records)
+	}
+}
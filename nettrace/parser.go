@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"unicode/utf16"
 )
 
@@ -70,3 +71,14 @@ func (p *Parser) decodeUTF16NTS() string {
 	}
 	return string(utf16.Decode(s))
 }
+
+// UTF8 returns a string prefixed with the length in bytes encoded as
+// variable-length integer (format version 6).
+func (p *Parser) UTF8() string {
+	n := p.Uvarint()
+	if n > uint64(p.Len()) {
+		p.errs = append(p.errs, io.ErrUnexpectedEOF)
+		return ""
+	}
+	return string(p.Next(int(n)))
+}
@@ -0,0 +1,210 @@
+package nettrace
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace/typecode"
+)
+
+// PayloadField is a decoded event payload field.
+type PayloadField struct {
+	Name string
+	// Value type depends on the field type code:
+	//   - Empty and DBNull: nil
+	//   - Boolean: bool
+	//   - Char: rune
+	//   - SByte, Byte, Int16 ... UInt64, Single, Double: int8, uint8, int16 ... uint64, float32, float64
+	//   - Decimal: float64
+	//   - DateTime: time.Time (UTC)
+	//   - String: string
+	//   - Guid: [16]byte
+	//   - Object: []PayloadField, or map[string]interface{} if decoded with DecodePayloadMap
+	//   - Array: []interface{} of the element values
+	Value interface{}
+}
+
+// DecodePayload decodes the event payload according to the metadata field
+// definitions. The blob payload is not consumed.
+func DecodePayload(blob *Blob, md *Metadata) ([]PayloadField, error) {
+	d := payloadDecoder{p: &Parser{Buffer: bytes.NewBuffer(blob.Payload.Bytes())}}
+	v, err := d.object(md.Payload.Fields)
+	if err != nil {
+		return nil, err
+	}
+	return v.([]PayloadField), nil
+}
+
+// DecodePayloadMap decodes the event payload like DecodePayload, but
+// returns fields as a map, including nested objects.
+func DecodePayloadMap(blob *Blob, md *Metadata) (map[string]interface{}, error) {
+	d := payloadDecoder{p: &Parser{Buffer: bytes.NewBuffer(blob.Payload.Bytes())}, asMap: true}
+	v, err := d.object(md.Payload.Fields)
+	if err != nil {
+		return nil, err
+	}
+	return v.(map[string]interface{}), nil
+}
+
+type payloadDecoder struct {
+	p     *Parser
+	asMap bool
+}
+
+func (d *payloadDecoder) object(fields []MetadataField) (interface{}, error) {
+	var (
+		list []PayloadField
+		m    map[string]interface{}
+	)
+	if d.asMap {
+		m = make(map[string]interface{}, len(fields))
+	} else {
+		list = make([]PayloadField, 0, len(fields))
+	}
+	for _, f := range fields {
+		v, err := d.value(f)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", f.Name, err)
+		}
+		if d.asMap {
+			m[f.Name] = v
+		} else {
+			list = append(list, PayloadField{Name: f.Name, Value: v})
+		}
+	}
+	if err := d.p.Err(); err != nil {
+		return nil, err
+	}
+	if d.asMap {
+		return m, nil
+	}
+	return list, nil
+}
+
+// value reads a value of the field, or the array element f describes.
+func (d *payloadDecoder) value(f MetadataField) (interface{}, error) {
+	switch t := f.TypeCode; t {
+	case typecode.Empty, typecode.DBNull:
+		return nil, nil
+	case typecode.Object:
+		return d.object(f.Payload.Fields)
+	case typecode.Array:
+		// Arrays are prefixed with the number of elements.
+		var n uint16
+		d.p.Read(&n)
+		a := make([]interface{}, 0, n)
+		e := f.element()
+		for i := uint16(0); i < n && d.p.Err() == nil; i++ {
+			v, err := d.value(e)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+		return a, d.p.Err()
+	case typecode.Boolean:
+		// Win32 BOOL.
+		var x int32
+		d.p.Read(&x)
+		return x != 0, d.p.Err()
+	case typecode.Char:
+		var x uint16
+		d.p.Read(&x)
+		return rune(x), d.p.Err()
+	case typecode.SByte:
+		return d.read(new(int8))
+	case typecode.Byte:
+		return d.read(new(uint8))
+	case typecode.Int16:
+		return d.read(new(int16))
+	case typecode.UInt16:
+		return d.read(new(uint16))
+	case typecode.Int32:
+		return d.read(new(int32))
+	case typecode.UInt32:
+		return d.read(new(uint32))
+	case typecode.Int64:
+		return d.read(new(int64))
+	case typecode.UInt64:
+		return d.read(new(uint64))
+	case typecode.Single:
+		return d.read(new(float32))
+	case typecode.Double:
+		return d.read(new(float64))
+	case typecode.Decimal:
+		var x decimal
+		d.p.Read(&x)
+		return x.float64(), d.p.Err()
+	case typecode.DateTime:
+		// FILETIME: 100-nanosecond intervals since January 1, 1601 (UTC).
+		var x int64
+		d.p.Read(&x)
+		x -= fileTimeUnixEpoch
+		return time.Unix(x/1e7, x%1e7*100).UTC(), d.p.Err()
+	case typecode.String:
+		return d.p.UTF16NTS(), d.p.Err()
+	case typecode.Guid:
+		return d.read(new([16]byte))
+	default:
+		return nil, fmt.Errorf("unknown type code %d", t)
+	}
+}
+
+// read reads the value v points to, and returns the value.
+func (d *payloadDecoder) read(v interface{}) (interface{}, error) {
+	d.p.Read(v)
+	if err := d.p.Err(); err != nil {
+		return nil, err
+	}
+	switch x := v.(type) {
+	case *int8:
+		return *x, nil
+	case *uint8:
+		return *x, nil
+	case *int16:
+		return *x, nil
+	case *uint16:
+		return *x, nil
+	case *int32:
+		return *x, nil
+	case *uint32:
+		return *x, nil
+	case *int64:
+		return *x, nil
+	case *uint64:
+		return *x, nil
+	case *float32:
+		return *x, nil
+	case *float64:
+		return *x, nil
+	case *[16]byte:
+		return *x, nil
+	default:
+		panic("unexpected value type")
+	}
+}
+
+// FILETIME of the Unix epoch.
+const fileTimeUnixEpoch = 116444736000000000
+
+// decimal is the memory layout of System.Decimal.
+type decimal struct {
+	// Flags specify the scale (bits 16-23), and the sign (bit 31).
+	Flags uint32
+	Hi    uint32
+	Lo    uint64
+}
+
+func (x decimal) float64() float64 {
+	v := new(big.Int).SetUint64(uint64(x.Hi))
+	v.Lsh(v, 64).Or(v, new(big.Int).SetUint64(x.Lo))
+	f, _ := new(big.Float).SetInt(v).Float64()
+	f /= math.Pow10(int(x.Flags >> 16 & 0xFF))
+	if x.Flags&(1<<31) != 0 {
+		f = -f
+	}
+	return f
+}
@@ -0,0 +1,64 @@
+package nettrace_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+	"github.com/pyroscope-io/dotnetdiag/nettrace/typecode"
+)
+
+func TestDecodePayload(t *testing.T) {
+	var b rawBuilder
+	b.write(int32(1), utf16NTS("Test-Provider"), int32(7), utf16NTS("TestEvent"), int64(0), int32(0), int32(4))
+	b.write(int32(7),
+		typecode.Boolean, utf16NTS("Flag"),
+		typecode.String, utf16NTS("Name"),
+		typecode.Guid, utf16NTS("ID"),
+		typecode.DateTime, utf16NTS("Time"),
+		typecode.Decimal, utf16NTS("Amount"),
+		typecode.Array, typecode.Object, int32(2),
+		typecode.UInt16, utf16NTS("Key"),
+		typecode.Double, utf16NTS("Value"),
+		utf16NTS("Items"),
+		typecode.Object, int32(1), typecode.SByte, utf16NTS("Level"), utf16NTS("Nested"))
+	md, err := nettrace.MetadataFromBlob(nettrace.Blob{Payload: bytes.NewBuffer(b.Bytes())})
+	requireNoError(t, err)
+
+	ts := time.Date(2021, 5, 2, 4, 0, 0, 0, time.UTC)
+	var p rawBuilder
+	p.write(int32(1), utf16NTS("test"), [16]byte{1, 2}, ts.UnixNano()/100+116444736000000000)
+	// -12.5: the scale is 1.
+	p.write(uint32(1<<31|1<<16), uint32(0), uint64(125))
+	p.write(uint16(2), uint16(1), 0.5, uint16(2), 1.5)
+	p.write(int8(-1))
+	blob := nettrace.Blob{Payload: bytes.NewBuffer(p.Bytes())}
+
+	fields, err := nettrace.DecodePayload(&blob, md)
+	requireNoError(t, err)
+	expected := []nettrace.PayloadField{
+		{Name: "Flag", Value: true},
+		{Name: "Name", Value: "test"},
+		{Name: "ID", Value: [16]byte{1, 2}},
+		{Name: "Time", Value: ts},
+		{Name: "Amount", Value: -12.5},
+		{Name: "Items", Value: []interface{}{
+			[]nettrace.PayloadField{{Name: "Key", Value: uint16(1)}, {Name: "Value", Value: 0.5}},
+			[]nettrace.PayloadField{{Name: "Key", Value: uint16(2)}, {Name: "Value", Value: 1.5}},
+		}},
+		{Name: "Nested", Value: []nettrace.PayloadField{{Name: "Level", Value: int8(-1)}}},
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Fatalf("unexpected fields:\n%+v\n%+v", fields, expected)
+	}
+
+	m, err := nettrace.DecodePayloadMap(&blob, md)
+	requireNoError(t, err)
+	items := m["Items"].([]interface{})
+	if m["Name"] != "test" || items[1].(map[string]interface{})["Value"] != 1.5 ||
+		m["Nested"].(map[string]interface{})["Level"] != int8(-1) {
+		t.Fatalf("unexpected fields: %+v", m)
+	}
+}
@@ -0,0 +1,164 @@
+package profiler
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pyroscope-io/dotnetdiag"
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+)
+
+// Continuous profiler restarts SampleProfiler sessions every window and
+// emits one profile per window.
+//
+// The symbol table persists across the windows: in addition to the
+// rundown events, it is populated with MethodLoadVerbose and ModuleLoad
+// runtime events, therefore the rundown is only requested for the first
+// window, and when the previous window has encountered managed code
+// addresses which could not be resolved. Skipping the rundown requires
+// .NET 5.0 or newer.
+type Continuous struct {
+	client  *dotnetdiag.Client
+	window  time.Duration
+	handler func(Profile) error
+	options []Option
+	sym     *symbols
+
+	m sync.Mutex
+	// stop closes the session of the current window.
+	stop   func() error
+	closed bool
+}
+
+// Profile contains samples collected within a window.
+type Profile struct {
+	Start   time.Time
+	End     time.Time
+	Samples map[string]time.Duration
+}
+
+// NewContinuous creates a new continuous profiler for the client given.
+// The handler is called at the end of every window. Options are applied
+// to the SampleProfiler of every window.
+func NewContinuous(c *dotnetdiag.Client, window time.Duration, handler func(Profile) error, options ...Option) *Continuous {
+	sym := newSymbols()
+	return &Continuous{
+		client:  c,
+		window:  window,
+		handler: handler,
+		options: append(options[:len(options):len(options)], withSymbols(sym)),
+		sym:     sym,
+	}
+}
+
+// Run collects profiles until Close is called, or an error occurs.
+// The last window is completed when the profiler is closed.
+func (c *Continuous) Run() error {
+	rundown := true
+	for !c.isClosed() {
+		var err error
+		if rundown, err = c.collect(rundown); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops the profiler.
+func (c *Continuous) Close() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.closed = true
+	if c.stop != nil {
+		return c.stop()
+	}
+	return nil
+}
+
+func (c *Continuous) isClosed() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.closed
+}
+
+func (c *Continuous) providers() []dotnetdiag.ProviderConfig {
+	return []dotnetdiag.ProviderConfig{
+		{
+			ProviderName: dotnetdiag.ProviderSampleProfiler,
+			Keywords:     dotnetdiag.SampleProfilerKeywords,
+			LogLevel:     dotnetdiag.LevelInformational,
+		},
+		{
+			ProviderName: dotnetdiag.ProviderRuntime,
+			Keywords:     dotnetdiag.RuntimeKeywordJit | dotnetdiag.RuntimeKeywordLoader,
+			LogLevel:     dotnetdiag.LevelVerbose,
+		},
+	}
+}
+
+// collect runs a session for the window, and reports whether the next
+// window needs the rundown.
+func (c *Continuous) collect(rundown bool) (bool, error) {
+	s, err := c.client.CollectTracing(dotnetdiag.CollectTracingConfig{
+		CircularBufferSizeMB: 10,
+		Providers:            c.providers(),
+		DisableRundown:       !rundown,
+	})
+	if err != nil {
+		return false, err
+	}
+	// The session is closed either when the window ends, or when
+	// the profiler is closed, whichever happens first.
+	var once sync.Once
+	var closeErr error
+	stop := func() error {
+		once.Do(func() { closeErr = s.Close() })
+		return closeErr
+	}
+	defer func() { _ = stop() }()
+	c.m.Lock()
+	c.stop = stop
+	closed := c.closed
+	c.m.Unlock()
+	if closed {
+		_ = stop()
+	}
+	t := time.AfterFunc(c.window, func() { _ = stop() })
+	defer t.Stop()
+	return c.process(s, time.Now())
+}
+
+// process reads the window trace and calls the handler with the profile.
+// It reports whether the window has encountered managed code addresses
+// which could not be resolved.
+func (c *Continuous) process(r io.Reader, start time.Time) (bool, error) {
+	unresolved := c.sym.unresolved
+	stream := nettrace.NewStream(r)
+	trace, err := stream.Open()
+	if err != nil {
+		return false, err
+	}
+	p := NewSampleProfiler(trace, c.options...)
+	stream.EventHandler = p.EventHandler
+	stream.MetadataHandler = p.MetadataHandler
+	stream.StackBlockHandler = p.StackBlockHandler
+	stream.SequencePointBlockHandler = p.SequencePointBlockHandler
+	for {
+		err = stream.Next()
+		switch {
+		case err == nil:
+			continue
+		case errors.Is(err, io.EOF):
+			err = c.handler(Profile{
+				Start:   start,
+				End:     time.Now(),
+				Samples: p.Samples(),
+			})
+			return c.sym.unresolved > unresolved, err
+		default:
+			return false, err
+		}
+	}
+}
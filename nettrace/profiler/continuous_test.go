@@ -0,0 +1,66 @@
+package profiler
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pyroscope-io/dotnetdiag"
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+)
+
+func TestContinuousRundown(t *testing.T) {
+	full, err := os.ReadFile(webappTrace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The trace of a window without the rundown and runtime events.
+	var samples bytes.Buffer
+	err = nettrace.Rewrite(&samples, bytes.NewReader(full),
+		nettrace.RewriteProviders(dotnetdiag.ProviderSampleProfiler))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var profiles []Profile
+	c := NewContinuous(nil, time.Second, func(p Profile) error {
+		profiles = append(profiles, p)
+		return nil
+	}, WithManagedCodeOnly())
+	for i, x := range []struct {
+		trace   []byte
+		rundown bool
+	}{
+		// Native code addresses do not require the rundown.
+		{full, false},
+		// Symbols of the first window are used.
+		{samples.Bytes(), false},
+	} {
+		rundown, err := c.process(bytes.NewReader(x.trace), time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rundown != x.rundown {
+			t.Fatalf("window %d: expected rundown %v, got %v", i, x.rundown, rundown)
+		}
+	}
+	if len(profiles) != 2 {
+		t.Fatalf("expected 2 profiles, got %d", len(profiles))
+	}
+	for k, v := range profiles[0].Samples {
+		if profiles[1].Samples[k] != v {
+			t.Fatalf("%s: expected %v, got %v", k, v, profiles[1].Samples[k])
+		}
+	}
+
+	// Without the symbols, the rundown is required.
+	c = NewContinuous(nil, time.Second, func(Profile) error { return nil })
+	rundown, err := c.process(bytes.NewReader(samples.Bytes()), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rundown {
+		t.Fatal("expected rundown to be required")
+	}
+}
@@ -0,0 +1,96 @@
+package profiler
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WriteFolded writes the samples to w in the folded stacks format used by
+// flamegraph.pl and compatible tools: one line per call stack, followed by
+// the time spent in microseconds.
+func WriteFolded(w io.Writer, samples map[string]time.Duration) error {
+	bw := bufio.NewWriter(w)
+	for _, stack := range sortedStacks(samples) {
+		v := samples[stack].Microseconds()
+		if v == 0 {
+			continue
+		}
+		// Write errors are reported by Flush.
+		_, _ = fmt.Fprintf(bw, "%s %d\n", stack, v)
+	}
+	return bw.Flush()
+}
+
+// WriteSpeedscope writes the samples to w as a speedscope sampled profile
+// (https://www.speedscope.app/file-format-schema.json).
+func WriteSpeedscope(w io.Writer, name string, samples map[string]time.Duration) error {
+	type frame struct {
+		Name string `json:"name"`
+	}
+	type profile struct {
+		Type       string  `json:"type"`
+		Name       string  `json:"name"`
+		Unit       string  `json:"unit"`
+		StartValue int64   `json:"startValue"`
+		EndValue   int64   `json:"endValue"`
+		Samples    [][]int `json:"samples"`
+		Weights    []int64 `json:"weights"`
+	}
+	type file struct {
+		Schema string `json:"$schema"`
+		Shared struct {
+			Frames []frame `json:"frames"`
+		} `json:"shared"`
+		Profiles []profile `json:"profiles"`
+		Name     string    `json:"name"`
+		Exporter string    `json:"exporter"`
+	}
+
+	f := file{
+		Schema:   "https://www.speedscope.app/file-format-schema.json",
+		Name:     name,
+		Exporter: "dotnetdiag",
+	}
+	f.Shared.Frames = []frame{}
+	p := profile{
+		Type:    "sampled",
+		Name:    name,
+		Unit:    "nanoseconds",
+		Samples: [][]int{},
+		Weights: []int64{},
+	}
+	frames := make(map[string]int)
+	for _, stack := range sortedStacks(samples) {
+		names := strings.Split(stack, ";")
+		s := make([]int, len(names))
+		for i, n := range names {
+			idx, ok := frames[n]
+			if !ok {
+				idx = len(f.Shared.Frames)
+				frames[n] = idx
+				f.Shared.Frames = append(f.Shared.Frames, frame{Name: n})
+			}
+			s[i] = idx
+		}
+		v := samples[stack].Nanoseconds()
+		p.Samples = append(p.Samples, s)
+		p.Weights = append(p.Weights, v)
+		p.EndValue += v
+	}
+	f.Profiles = []profile{p}
+	return json.NewEncoder(w).Encode(f)
+}
+
+func sortedStacks(samples map[string]time.Duration) []string {
+	stacks := make([]string, 0, len(samples))
+	for stack := range samples {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+	return stacks
+}
@@ -0,0 +1,176 @@
+package profiler
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"strings"
+	"time"
+)
+
+// WritePprof writes the samples to w as a gzip-compressed pprof profile
+// (https://github.com/google/pprof/blob/main/proto/profile.proto).
+//
+// Samples are expected in the format returned by SampleProfiler: call
+// stack frames are separated by ';' and listed from the root to the leaf.
+func WritePprof(w io.Writer, samples map[string]time.Duration, start time.Time, d time.Duration) error {
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(buildPprof(samples, start, d)); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// samplingPeriod is the default SampleProfiler sampling interval.
+const samplingPeriod = int64(time.Millisecond)
+
+// Profile message field numbers.
+const (
+	pprofSampleType    = 1
+	pprofSample        = 2
+	pprofLocation      = 4
+	pprofFunction      = 5
+	pprofStringTable   = 6
+	pprofTimeNanos     = 9
+	pprofDurationNanos = 10
+	pprofPeriodType    = 11
+	pprofPeriod        = 12
+)
+
+func buildPprof(samples map[string]time.Duration, start time.Time, d time.Duration) []byte {
+	var b protoBuffer
+	strs := newStringTable()
+	valueType := func(typ, unit string) []byte {
+		var v protoBuffer
+		v.int64(1, int64(strs.index(typ)))
+		v.int64(2, int64(strs.index(unit)))
+		return v.b
+	}
+	// SampleProfiler samples all managed threads, including the waiting
+	// ones, therefore the values are wall clock time rather than CPU time.
+	b.bytes(pprofSampleType, valueType("wall", "nanoseconds"))
+
+	// Function and location IDs are the same: there is exactly one
+	// location per function as no line information is available.
+	functions := make(map[string]uint64)
+	var names []string
+	for _, stack := range sortedStacks(samples) {
+		frames := strings.Split(stack, ";")
+		ids := make([]uint64, len(frames))
+		for i, name := range frames {
+			id, ok := functions[name]
+			if !ok {
+				id = uint64(len(functions) + 1)
+				functions[name] = id
+				names = append(names, name)
+			}
+			// pprof expects the leaf first.
+			ids[len(frames)-1-i] = id
+		}
+		var s protoBuffer
+		s.packedUint64(1, ids)
+		s.packedUint64(2, []uint64{uint64(samples[stack].Nanoseconds())})
+		b.bytes(pprofSample, s.b)
+	}
+	for i := range names {
+		id := uint64(i + 1)
+		var line protoBuffer
+		line.uint64(1, id)
+		var loc protoBuffer
+		loc.uint64(1, id)
+		loc.bytes(4, line.b)
+		b.bytes(pprofLocation, loc.b)
+	}
+	for i, name := range names {
+		var fn protoBuffer
+		fn.uint64(1, uint64(i+1))
+		fn.int64(2, int64(strs.index(name)))
+		fn.int64(3, int64(strs.index(name)))
+		if p := strings.Index(name, "!"); p > 0 {
+			fn.int64(4, int64(strs.index(name[:p])))
+		}
+		b.bytes(pprofFunction, fn.b)
+	}
+	periodType := valueType("wall", "nanoseconds")
+	for _, s := range strs.strings {
+		b.string(pprofStringTable, s)
+	}
+	b.int64(pprofTimeNanos, start.UnixNano())
+	b.int64(pprofDurationNanos, d.Nanoseconds())
+	b.bytes(pprofPeriodType, periodType)
+	b.int64(pprofPeriod, samplingPeriod)
+	return b.b
+}
+
+type stringTable struct {
+	strings []string
+	indices map[string]int
+}
+
+func newStringTable() *stringTable {
+	// The first string must be empty.
+	return &stringTable{
+		strings: []string{""},
+		indices: map[string]int{"": 0},
+	}
+}
+
+func (t *stringTable) index(s string) int {
+	if i, ok := t.indices[s]; ok {
+		return i
+	}
+	i := len(t.strings)
+	t.strings = append(t.strings, s)
+	t.indices[s] = i
+	return i
+}
+
+// protoBuffer implements a minimal subset of protobuf wire format encoding.
+type protoBuffer struct{ b []byte }
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func (p *protoBuffer) varint(x uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], x)
+	p.b = append(p.b, tmp[:n]...)
+}
+
+func (p *protoBuffer) key(field, wireType int) {
+	p.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (p *protoBuffer) uint64(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	p.key(field, wireVarint)
+	p.varint(x)
+}
+
+func (p *protoBuffer) int64(field int, x int64) {
+	p.uint64(field, uint64(x))
+}
+
+func (p *protoBuffer) bytes(field int, b []byte) {
+	p.key(field, wireBytes)
+	p.varint(uint64(len(b)))
+	p.b = append(p.b, b...)
+}
+
+func (p *protoBuffer) string(field int, s string) {
+	p.key(field, wireBytes)
+	p.varint(uint64(len(s)))
+	p.b = append(p.b, s...)
+}
+
+func (p *protoBuffer) packedUint64(field int, x []uint64) {
+	var v protoBuffer
+	for _, n := range x {
+		v.varint(n)
+	}
+	p.bytes(field, v.b)
+}
@@ -5,14 +5,21 @@ import (
 	"encoding/binary"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pyroscope-io/dotnetdiag/nettrace"
+	"github.com/pyroscope-io/dotnetdiag/nettrace/clr"
 )
 
 // SampleProfiler processes event stream from Microsoft-DotNETCore-SampleProfiler
 // provider and calculates time for every call stack.
+//
+// SampleProfiler is safe for concurrent use: Samples and Drain can be called
+// while the stream is being processed. The stream does not have to be
+// Ordered: samples are sorted by the profiler until the sequence point.
 type SampleProfiler struct {
+	m     sync.Mutex
 	trace *nettrace.Trace
 	sym   *symbols
 
@@ -37,8 +44,17 @@ func WithManagedCodeOnly() Option {
 }
 
 type sample struct {
-	stack []uint64
-	value int64
+	threadID int64
+	stack    []uint64
+	value    int64
+}
+
+// Sample represents the time spent by a thread in a call stack. Stack frames
+// are listed from the root to the leaf.
+type Sample struct {
+	ThreadID int64
+	Stack    []string
+	Value    time.Duration
 }
 
 type event struct {
@@ -97,19 +113,64 @@ func NewSampleProfiler(trace *nettrace.Trace, options ...Option) *SampleProfiler
 	return p
 }
 
+// withSymbols makes SampleProfiler to use the given symbol table,
+// which may be shared by multiple profilers.
+func withSymbols(sym *symbols) Option {
+	return func(p *SampleProfiler) {
+		p.sym = sym
+	}
+}
+
+// WithSymbolsOf makes SampleProfiler to use the symbol table of the given
+// profiler, e.g. one that has processed a rundown. The profilers must not
+// be used concurrently.
+func WithSymbolsOf(x *SampleProfiler) Option {
+	return withSymbols(x.sym)
+}
+
+// Samples returns the time spent in every call stack, which frames are
+// separated by ';'.
 func (s *SampleProfiler) Samples() map[string]time.Duration {
+	s.m.Lock()
+	defer s.m.Unlock()
 	samples := make(map[string]time.Duration)
 	for _, x := range s.samples {
-		name := make([]string, len(x.stack))
-		for i := range x.stack {
-			name[i] = s.sym.resolve(x.stack[i])
+		samples[strings.Join(s.resolve(x.stack), ";")] += time.Duration(x.value)
+	}
+	return samples
+}
+
+// Drain returns samples collected since the previous call, and removes them
+// from the profiler. Unlike Samples, Drain does not wait for a sequence
+// point to process the events received, therefore it can be used to
+// observe a live session.
+func (s *SampleProfiler) Drain() []Sample {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.flush()
+	samples := make([]Sample, len(s.samples))
+	for i, x := range s.samples {
+		samples[i] = Sample{
+			ThreadID: x.threadID,
+			Stack:    s.resolve(x.stack),
+			Value:    time.Duration(x.value),
 		}
-		samples[strings.Join(name, ";")] += time.Duration(x.value)
 	}
+	s.samples = s.samples[:0]
 	return samples
 }
 
+func (s *SampleProfiler) resolve(stack []uint64) []string {
+	name := make([]string, len(stack))
+	for i := range stack {
+		name[i] = s.sym.resolve(stack[i])
+	}
+	return name
+}
+
 func (s *SampleProfiler) EventHandler(e *nettrace.Blob) error {
+	s.m.Lock()
+	defer s.m.Unlock()
 	md, ok := s.md[e.Header.MetadataID]
 	if !ok {
 		return fmt.Errorf("metadata not found")
@@ -119,12 +180,24 @@ func (s *SampleProfiler) EventHandler(e *nettrace.Blob) error {
 	case md.Header.ProviderName == "Microsoft-DotNETCore-SampleProfiler" && md.Header.EventID == 0:
 		return s.addSample(e)
 
-	case md.Header.ProviderName == "Microsoft-Windows-DotNETRuntimeRundown":
-		switch {
-		case md.Header.EventID == 144:
+	case md.Header.ProviderName == clr.RundownProvider:
+		switch md.Header.EventID {
+		case clr.RundownMethodDCEndVerbose:
 			return s.sym.addMethod(e)
 
-		case md.Header.EventID == 152:
+		case clr.RundownDomainModuleDCEnd:
+			return s.sym.addDomainModule(e)
+		}
+
+	// MethodLoadVerbose and ModuleLoad events describe methods and modules
+	// like the rundown events, but only emitted if the corresponding keywords
+	// (Jit and Loader) are enabled.
+	case md.Header.ProviderName == clr.RuntimeProvider:
+		switch md.Header.EventID {
+		case clr.RuntimeMethodLoadVerbose:
+			return s.sym.addMethod(e)
+
+		case clr.RuntimeModuleLoad:
 			return s.sym.addModule(e)
 		}
 	}
@@ -133,11 +206,15 @@ func (s *SampleProfiler) EventHandler(e *nettrace.Blob) error {
 }
 
 func (s *SampleProfiler) MetadataHandler(md *nettrace.Metadata) error {
+	s.m.Lock()
+	defer s.m.Unlock()
 	s.md[md.Header.MetaDataID] = md
 	return nil
 }
 
 func (s *SampleProfiler) StackBlockHandler(sb *nettrace.StackBlock) error {
+	s.m.Lock()
+	defer s.m.Unlock()
 	for _, stack := range sb.Stacks {
 		s.stacks[stack.ID] = stack.InstructionPointers(s.trace.PointerSize)
 	}
@@ -145,21 +222,33 @@ func (s *SampleProfiler) StackBlockHandler(sb *nettrace.StackBlock) error {
 }
 
 func (s *SampleProfiler) SequencePointBlockHandler(*nettrace.SequencePointBlock) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.flush()
+	s.stacks = make(map[int32][]uint64)
+	return nil
+}
+
+// flush processes pending events in the timestamp order and moves
+// the samples accumulated by threads to the profiler samples.
+func (s *SampleProfiler) flush() {
 	for s.events.Len() != 0 {
 		x := heap.Pop(&s.events).(*event)
 		s.thread(x.threadID).addSample(x.typ, x.relativeTime, x.stackID)
 	}
-	for _, t := range s.threads {
+	for tid, t := range s.threads {
+		if len(t.samples) == 0 {
+			continue
+		}
 		for stackID, value := range t.samples {
 			s.samples = append(s.samples, sample{
-				stack: s.stacks[stackID],
-				value: value,
+				threadID: tid,
+				stack:    s.stacks[stackID],
+				value:    value,
 			})
 		}
 		t.samples = make(map[int32]int64)
 	}
-	s.stacks = make(map[int32][]uint64)
-	return nil
 }
 
 // https://github.com/microsoft/perfview/blob/8a34d2d64bc958902b2fa8ea5799437df57d8de2/src/TraceEvent/TraceEvent.cs#L440-L460
@@ -0,0 +1,117 @@
+package profiler
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+)
+
+const webappTrace = "../testdata/dotnet-5.0-SampleProfiler-webapp.golden.nettrace"
+
+func profileFile(t *testing.T, onSequencePoint func(*SampleProfiler)) *SampleProfiler {
+	f, err := os.Open(webappTrace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stream := nettrace.NewStream(f)
+	trace, err := stream.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewSampleProfiler(trace)
+	stream.EventHandler = p.EventHandler
+	stream.MetadataHandler = p.MetadataHandler
+	stream.StackBlockHandler = p.StackBlockHandler
+	stream.SequencePointBlockHandler = func(sp *nettrace.SequencePointBlock) error {
+		if onSequencePoint != nil {
+			onSequencePoint(p)
+		}
+		return p.SequencePointBlockHandler(sp)
+	}
+	for {
+		if err = stream.Next(); errors.Is(err, io.EOF) {
+			return p
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSampleProfilerDrain(t *testing.T) {
+	var drained []Sample
+	p := profileFile(t, func(p *SampleProfiler) {
+		drained = append(drained, p.Drain()...)
+	})
+	drained = append(drained, p.Drain()...)
+	if len(p.Drain()) != 0 || len(p.Samples()) != 0 {
+		t.Fatal("expected no samples after drain")
+	}
+
+	var expected, total time.Duration
+	for _, v := range profileFile(t, nil).Samples() {
+		expected += v
+	}
+	for _, s := range drained {
+		if len(s.Stack) == 0 {
+			t.Fatal("empty stack")
+		}
+		total += s.Value
+	}
+	if total == 0 || total != expected {
+		t.Fatalf("expected %v total, got %v", expected, total)
+	}
+}
+
+func TestWritePprof(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	samples := map[string]time.Duration{
+		"m!Main;m!Foo;m!Bar": 3 * time.Millisecond,
+		"m!Main;m!Foo":       2 * time.Millisecond,
+		"?!?":                time.Millisecond,
+	}
+	var buf bytes.Buffer
+	if err := WritePprof(&buf, samples, start, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	p, err := profile.Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.TimeNanos != start.UnixNano() || p.DurationNanos != int64(time.Second) || p.Period != int64(time.Millisecond) {
+		t.Fatalf("unexpected profile time: %d, duration: %d, period: %d", p.TimeNanos, p.DurationNanos, p.Period)
+	}
+	if len(p.Function) != 4 {
+		t.Fatalf("expected 4 functions, got %d", len(p.Function))
+	}
+	actual := make(map[string]time.Duration)
+	for _, s := range p.Sample {
+		var stack string
+		// Locations are listed from the leaf to the root.
+		for i := len(s.Location) - 1; i >= 0; i-- {
+			if stack != "" {
+				stack += ";"
+			}
+			stack += s.Location[i].Line[0].Function.Name
+		}
+		actual[stack] = time.Duration(s.Value[0])
+	}
+	for k, v := range samples {
+		if actual[k] != v {
+			t.Fatalf("%s: expected %v, got %v", k, v, actual[k])
+		}
+	}
+	for _, fn := range p.Function {
+		if fn.Name != "?!?" && fn.Filename != "m" {
+			t.Fatalf("expected module name as the file name: %+v", fn)
+		}
+	}
+}
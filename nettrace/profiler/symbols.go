@@ -1,18 +1,20 @@
 package profiler
 
 import (
-	"bytes"
 	"fmt"
 	"path/filepath"
 	"sort"
 	"strings"
 
 	"github.com/pyroscope-io/dotnetdiag/nettrace"
+	"github.com/pyroscope-io/dotnetdiag/nettrace/clr"
 )
 
 type symbols struct {
 	// Instruction pointer -> formatted string that includes
-	// module name, namespace, method name and signature.
+	// module name, namespace, method name and signature. Only
+	// fully resolved names are cached; the cache is reset when
+	// a method or module is added.
 	resolved map[uint64]string
 	// Slice of method addresses for sort and search.
 	methodAddresses []uint64
@@ -20,7 +22,11 @@ type symbols struct {
 	// MethodStartAddress -> method.
 	methods map[uint64]*method
 	// ModuleID -> module.
-	modules map[int64]*module
+	modules map[uint64]*module
+	// The number of managed code addresses failed to resolve because the
+	// method is not known. Native code addresses can not be resolved with
+	// the runtime events, and therefore are not counted.
+	unresolved int
 }
 
 type addresses []uint64
@@ -31,19 +37,10 @@ func (x addresses) Less(i, j int) bool { return x[i] < x[j] }
 
 func (x addresses) Swap(i, j int) { x[i], x[j] = x[j], x[i] }
 
-// method describes MethodLoadUnloadTraceData event payload for
-// Microsoft-Windows-DotNETRuntimeRundown Event ID 144.
-type method struct {
-	MethodID           int64
-	ModuleID           int64
-	MethodStartAddress uint64
-	MethodSize         int32
-	MethodToken        int32
-	MethodFlags        int32
-	MethodNamespace    string
-	MethodName         string
-	MethodSignature    string
-}
+// method describes MethodLoadVerbose and MethodDCEndVerbose event
+// payloads (runtime and rundown providers): the events share the layout,
+// and later versions only append fields.
+type method clr.MethodLoadUnloadVerbose
 
 func (d method) String() string {
 	p := strings.Index(d.MethodSignature, "(")
@@ -53,12 +50,8 @@ func (d method) String() string {
 	return fmt.Sprintf("%s.%s%s", d.MethodNamespace, d.MethodName, d.MethodSignature[p:])
 }
 
-// module describes ModuleLoadUnloadTraceData event payload for
-// Microsoft-Windows-DotNETRuntimeRundown Event ID 152.
 type module struct {
-	ModuleID     int64
-	AssemblyID   int64
-	ModuleFlags  int32
+	ModuleID     uint64
 	ModuleILPath string
 }
 
@@ -70,12 +63,18 @@ func newSymbols() *symbols {
 	return &symbols{
 		resolved: make(map[uint64]string),
 		methods:  make(map[uint64]*method),
-		modules:  make(map[int64]*module),
+		modules:  make(map[uint64]*module),
 	}
 }
 
 const unresolvedSymbol = "?!?"
 
+// managedCodeProximity is the distance from the closest known method within
+// which an address that can not be resolved is considered to be managed
+// code: JIT compiled methods are allocated in code heap blocks, while native
+// modules are mapped apart from them.
+const managedCodeProximity = 64 << 10
+
 func (s *symbols) resolve(addr uint64) string {
 	if n, ok := s.resolved[addr]; ok {
 		return n
@@ -87,72 +86,84 @@ func (s *symbols) resolve(addr uint64) string {
 	methodIdx := sort.Search(len(s.methodAddresses), func(i int) bool {
 		return s.methodAddresses[i] > addr
 	})
-	// Method address not found.
-	if methodIdx-1 == len(s.methods) || methodIdx == 0 {
-		return unresolvedSymbol
-	}
-	methodAddress := s.methodAddresses[methodIdx-1]
-	met, ok := s.methods[methodAddress]
-	if !ok {
-		return unresolvedSymbol
+	var met *method
+	if methodIdx > 0 {
+		met = s.methods[s.methodAddresses[methodIdx-1]]
 	}
 	// Ensure the instruction pointer is within the method address space.
-	if (met.MethodStartAddress + uint64(met.MethodSize)) <= addr {
+	if met == nil || (met.MethodStartAddress+uint64(met.MethodSize)) <= addr {
+		if s.isNearManagedCode(addr, methodIdx) {
+			s.unresolved++
+		}
 		return unresolvedSymbol
 	}
-	var name string
 	mod, ok := s.modules[met.ModuleID]
 	if !ok {
-		name = fmt.Sprintf("?!%s", met)
-	} else {
-		name = fmt.Sprintf("%s!%s", mod, met)
+		// Modules of dynamic methods are not described even by the rundown.
+		return fmt.Sprintf("?!%s", met)
 	}
+	name := fmt.Sprintf("%s!%s", mod, met)
 	s.resolved[addr] = name
 	return name
 }
 
+// isNearManagedCode reports whether the address is close to a known method,
+// or no methods are known at all. i is the index of the first method which
+// address is greater than addr.
+func (s *symbols) isNearManagedCode(addr uint64, i int) bool {
+	if len(s.methodAddresses) == 0 {
+		return true
+	}
+	if i > 0 {
+		if m := s.methods[s.methodAddresses[i-1]]; addr-(m.MethodStartAddress+uint64(m.MethodSize)) < managedCodeProximity {
+			return true
+		}
+	}
+	return i < len(s.methodAddresses) && s.methodAddresses[i]-addr < managedCodeProximity
+}
+
+// addModule handles ModuleLoad event.
 func (s *symbols) addModule(e *nettrace.Blob) error {
-	m, err := parseModule(e.Payload)
-	if err != nil {
+	var m clr.ModuleLoadUnload
+	if err := nettrace.Unmarshal(e, &m); err != nil {
 		return err
 	}
-	s.modules[m.ModuleID] = &m
+	s.addModuleInfo(&module{ModuleID: m.ModuleID, ModuleILPath: m.ModuleILPath})
 	return nil
 }
 
-func (s *symbols) addMethod(e *nettrace.Blob) error {
-	m, err := parseMethod(e.Payload)
-	if err != nil {
+// addDomainModule handles DomainModuleDCEnd rundown event, which
+// additionally specifies the AppDomain of the module.
+func (s *symbols) addDomainModule(e *nettrace.Blob) error {
+	var m clr.DomainModuleLoadUnloadRundown
+	if err := nettrace.Unmarshal(e, &m); err != nil {
 		return err
 	}
-	s.methods[m.MethodStartAddress] = &m
-	s.methodAddresses = append(s.methodAddresses, m.MethodStartAddress)
-	s.sorted = false
+	s.addModuleInfo(&module{ModuleID: m.ModuleID, ModuleILPath: m.ModuleILPath})
 	return nil
 }
 
-func parseMethod(b *bytes.Buffer) (method, error) {
-	p := &nettrace.Parser{Buffer: b}
-	var d method
-	p.Read(&d.MethodID)
-	p.Read(&d.ModuleID)
-	p.Read(&d.MethodStartAddress)
-	p.Read(&d.MethodSize)
-	p.Read(&d.MethodToken)
-	p.Read(&d.MethodFlags)
-	d.MethodNamespace = p.UTF16NTS()
-	d.MethodName = p.UTF16NTS()
-	d.MethodSignature = p.UTF16NTS()
-	return d, p.Err()
+func (s *symbols) addMethod(e *nettrace.Blob) error {
+	var m method
+	if err := nettrace.Unmarshal(e, &m); err != nil {
+		return err
+	}
+	if x, ok := s.methods[m.MethodStartAddress]; ok && *x == m {
+		return nil
+	} else if !ok {
+		s.methodAddresses = append(s.methodAddresses, m.MethodStartAddress)
+		s.sorted = false
+	}
+	// The method may reuse the code of an unloaded one.
+	s.methods[m.MethodStartAddress] = &m
+	s.resolved = make(map[uint64]string)
+	return nil
 }
 
-func parseModule(b *bytes.Buffer) (module, error) {
-	p := &nettrace.Parser{Buffer: b}
-	var d module
-	p.Read(&d.ModuleID)
-	p.Read(&d.AssemblyID)
-	p.Read(&d.ModuleFlags)
-	p.Skip(12)
-	d.ModuleILPath = p.UTF16NTS()
-	return d, p.Err()
+func (s *symbols) addModuleInfo(m *module) {
+	if x, ok := s.modules[m.ModuleID]; ok && *x == *m {
+		return
+	}
+	s.modules[m.ModuleID] = m
+	s.resolved = make(map[uint64]string)
 }
@@ -0,0 +1,359 @@
+package nettrace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Recorder keeps the most recent part of a NetTrace stream written to it
+// in memory, bounded by size or time, and allows to write it out as a
+// valid NetTrace stream at any moment (flight recorder mode).
+//
+// Like RotatingWriter, Recorder is intended to be used as a tee:
+//
+//	r := nettrace.NewRecorder(nettrace.RecorderMaxDuration(5 * time.Minute))
+//	stream := nettrace.NewStream(io.TeeReader(session, r))
+//
+// The stream is stored in segments, which end with a sequence point block,
+// or once they reach 1/8 of the size or duration limit. The oldest segments
+// are evicted first; the segment in progress and the latest complete
+// segment are always retained. Stack blocks of an evicted segment that does
+// not end with a sequence point are retained, as the stacks may be referred
+// to by the subsequent events, until the next sequence point.
+//
+// Metadata records are kept for the lifetime of the recorder, as the
+// runtime does not repeat them; the recorded data only includes the records
+// its events refer to.
+type Recorder struct {
+	sink        *objectSink
+	maxSize     uint64
+	maxDuration time.Duration
+
+	m        sync.Mutex
+	trace    *Trace
+	metadata map[int32][]byte
+	segments []*segment
+	current  *segment
+	size     uint64
+}
+
+type segment struct {
+	objects []Object
+	size    uint64
+	// Timestamp range of the events in the segment.
+	minTimestamp int64
+	maxTimestamp int64
+	// Metadata IDs the events refer to.
+	metadata map[int32]struct{}
+	// Set if the segment ends with a sequence point block.
+	sequencePoint bool
+}
+
+func newSegment() *segment {
+	return &segment{metadata: make(map[int32]struct{})}
+}
+
+var ErrNothingRecorded = errors.New("nothing has been recorded")
+
+// RecorderOption overrides default Recorder parameters.
+type RecorderOption func(*Recorder)
+
+// RecorderMaxSize limits the total size of the event data kept in memory.
+func RecorderMaxSize(n uint64) RecorderOption {
+	return func(r *Recorder) {
+		r.maxSize = n
+	}
+}
+
+// RecorderMaxDuration limits the time span of the event data kept in
+// memory. The time is measured with the event timestamps.
+func RecorderMaxDuration(d time.Duration) RecorderOption {
+	return func(r *Recorder) {
+		r.maxDuration = d
+	}
+}
+
+// defaultRecorderMaxSize is used if no limit is specified.
+const defaultRecorderMaxSize = 64 << 20
+
+// NewRecorder creates a new Recorder. If no limit is specified,
+// 64MB of event data is retained.
+func NewRecorder(options ...RecorderOption) *Recorder {
+	r := Recorder{
+		current:  newSegment(),
+		metadata: make(map[int32][]byte),
+	}
+	for _, option := range options {
+		option(&r)
+	}
+	if r.maxSize == 0 && r.maxDuration == 0 {
+		r.maxSize = defaultRecorderMaxSize
+	}
+	r.sink = newObjectSink(&r)
+	return &r
+}
+
+func (r *Recorder) Write(b []byte) (int, error) {
+	return r.sink.Write(b)
+}
+
+// Err returns the error that stopped recording, if any.
+func (r *Recorder) Err() error {
+	return r.sink.Err()
+}
+
+// Close stops recording. The recorded data is still available.
+func (r *Recorder) Close() error {
+	return r.sink.Close()
+}
+
+// WriteTo writes the recorded data to w as a NetTrace stream.
+// ErrNothingRecorded is returned, if the stream has not started yet.
+func (r *Recorder) WriteTo(w io.Writer) (int64, error) {
+	trace, metadata, objects := r.snapshot()
+	if trace == nil {
+		return 0, ErrNothingRecorded
+	}
+	enc := NewEncoder(w)
+	_ = enc.EncodeTrace(trace)
+	if len(metadata) > 0 {
+		_ = enc.encodeBlobBlock(ObjectTypeMetadataBlock, metadata, false)
+	}
+	for _, o := range objects {
+		_ = enc.EncodeObject(o)
+	}
+	err := enc.Close()
+	return int64(enc.w.offset), err
+}
+
+// snapshot returns the trace, the metadata records the retained events
+// refer to, and the retained objects.
+func (r *Recorder) snapshot() (*Trace, []Blob, []Object) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	segments := append(append([]*segment(nil), r.segments...), r.current)
+	ids := make(map[int32]struct{})
+	var objects []Object
+	for _, s := range segments {
+		objects = append(objects, s.objects...)
+		for id := range s.metadata {
+			ids[id] = struct{}{}
+		}
+	}
+	var metadata []Blob
+	for id := range ids {
+		if payload, ok := r.metadata[id]; ok {
+			metadata = append(metadata, Blob{
+				Header:  BlobHeader{MetadataID: id},
+				Payload: bytes.NewBuffer(payload),
+			})
+		}
+	}
+	sort.Slice(metadata, func(i, j int) bool {
+		return metadata[i].Header.MetadataID < metadata[j].Header.MetadataID
+	})
+	return r.trace, metadata, objects
+}
+
+// Dump creates a file at the given path and writes the recorded data to it.
+// ErrNothingRecorded is returned, if the stream has not started yet: the
+// file is not created.
+func (r *Recorder) Dump(path string) error {
+	r.m.Lock()
+	started := r.trace != nil
+	r.m.Unlock()
+	if !started {
+		return ErrNothingRecorded
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err = r.WriteTo(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// DumpOnSignal dumps the recorded data every time one of the given signals
+// is received. The path function is called to obtain a new file name; an
+// error occurred while writing the file is passed to onError, if specified.
+// The returned function stops listening to the signals.
+func (r *Recorder) DumpOnSignal(path func() string, onError func(error), sigs ...os.Signal) (stop func()) {
+	c := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(c, sigs...)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-c:
+				if err := r.Dump(path()); err != nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(c)
+			close(done)
+		})
+	}
+}
+
+func (r *Recorder) handleTrace(t *Trace) error {
+	r.m.Lock()
+	r.trace = t
+	r.m.Unlock()
+	return nil
+}
+
+func (r *Recorder) handleObject(o Object) error {
+	o = copyObject(o)
+	r.m.Lock()
+	defer r.m.Unlock()
+	switch o.Type {
+	case ObjectTypeMetadataBlock:
+		return r.handleMetadataBlock(o)
+	case ObjectTypeEventBlock:
+		if err := r.current.observeEvents(o); err != nil {
+			return err
+		}
+	case ObjectTypeSPBlock:
+		// Sequence point block starts with the timestamp.
+		var ts int64
+		if err := binary.Read(bytes.NewReader(o.Payload.Bytes()), binary.LittleEndian, &ts); err != nil {
+			return err
+		}
+		r.current.observeTimestamp(ts, ts)
+		r.current.sequencePoint = true
+	}
+	size := uint64(o.Payload.Len())
+	r.current.objects = append(r.current.objects, o)
+	r.current.size += size
+	r.size += size
+	if r.current.sequencePoint || r.isSegmentComplete(r.current) {
+		r.segments = append(r.segments, r.current)
+		r.current = newSegment()
+		r.evict()
+	}
+	return nil
+}
+
+func (r *Recorder) handleMetadataBlock(o Object) error {
+	block, err := BlobBlockFromObject(o)
+	if err != nil {
+		return err
+	}
+	var blob Blob
+	for {
+		err = block.Next(&blob)
+		switch {
+		case err == nil:
+		case errors.Is(err, io.EOF):
+			return nil
+		default:
+			return err
+		}
+		payload := blob.Payload.Bytes()
+		if len(payload) < 4 {
+			return io.ErrUnexpectedEOF
+		}
+		r.metadata[int32(binary.LittleEndian.Uint32(payload))] = payload
+	}
+}
+
+// observeEvents records the timestamps and metadata IDs of the events.
+func (s *segment) observeEvents(o Object) error {
+	block, err := BlobBlockFromObject(copyObject(o))
+	if err != nil {
+		return err
+	}
+	s.observeTimestamp(block.Header.MinTimestamp, block.Header.MaxTimestamp)
+	var blob Blob
+	for {
+		err = block.Next(&blob)
+		switch {
+		case err == nil:
+		case errors.Is(err, io.EOF):
+			return nil
+		default:
+			return err
+		}
+		s.metadata[blob.Header.MetadataID] = struct{}{}
+	}
+}
+
+func (s *segment) observeTimestamp(min, max int64) {
+	if s.minTimestamp == 0 || min < s.minTimestamp {
+		s.minTimestamp = min
+	}
+	if max > s.maxTimestamp {
+		s.maxTimestamp = max
+	}
+}
+
+// segmentFraction is the maximum share of the limits a segment
+// that does not end with a sequence point can take.
+const segmentFraction = 8
+
+// isSegmentComplete reports whether the segment has reached the size or
+// duration limit of a segment.
+func (r *Recorder) isSegmentComplete(s *segment) bool {
+	if r.maxSize > 0 && s.size >= r.maxSize/segmentFraction {
+		return true
+	}
+	return r.maxDuration > 0 && s.maxTimestamp > 0 &&
+		r.trace.Duration(s.maxTimestamp-s.minTimestamp) >= r.maxDuration/segmentFraction
+}
+
+func (r *Recorder) evict() {
+	for len(r.segments) > 1 && r.exceeds(r.segments[0]) {
+		s := r.segments[0]
+		r.size -= s.size
+		r.segments[0] = nil
+		r.segments = r.segments[1:]
+		if !s.sequencePoint {
+			r.retainStacks(s, r.segments[0])
+		}
+	}
+}
+
+// retainStacks moves stack blocks of the evicted segment s to the next
+// one: the stacks are valid until the next sequence point.
+func (r *Recorder) retainStacks(s, next *segment) {
+	var stacks []Object
+	var size uint64
+	for _, o := range s.objects {
+		if o.Type == ObjectTypeStackBlock {
+			stacks = append(stacks, o)
+			size += uint64(o.Payload.Len())
+		}
+	}
+	next.objects = append(stacks, next.objects...)
+	next.size += size
+	r.size += size
+}
+
+// exceeds reports whether the oldest segment s is to be evicted.
+func (r *Recorder) exceeds(s *segment) bool {
+	if r.maxSize > 0 && r.size > r.maxSize {
+		return true
+	}
+	if r.maxDuration <= 0 {
+		return false
+	}
+	latest := r.segments[len(r.segments)-1].maxTimestamp
+	return r.trace.Duration(latest-s.maxTimestamp) > r.maxDuration
+}
@@ -0,0 +1,129 @@
+package nettrace_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+)
+
+func TestRecorder(t *testing.T) {
+	s, err := os.Open("testdata/dotnet-5.0-SampleProfiler-webapp.golden.nettrace")
+	requireNoError(t, err)
+	defer s.Close()
+
+	r := nettrace.NewRecorder(nettrace.RecorderMaxSize(1 << 20))
+	total := countEvents(t, io.TeeReader(s, r))
+	requireNoError(t, r.Close())
+
+	var b bytes.Buffer
+	_, err = r.WriteTo(&b)
+	requireNoError(t, err)
+	if b.Len() > 2<<20 {
+		t.Fatalf("recorded data is too large: %d bytes", b.Len())
+	}
+	recorded := countEvents(t, &b)
+	if recorded == 0 || recorded >= total {
+		t.Fatalf("expected a fraction of %d events, got %d", total, recorded)
+	}
+}
+
+func TestRecorderWithoutSequencePoints(t *testing.T) {
+	var src bytes.Buffer
+	enc := nettrace.NewEncoder(&src)
+	requireNoError(t, enc.EncodeTrace(&nettrace.Trace{PointerSize: 8, QPCFrequency: 1e7}))
+	requireNoError(t, enc.EncodeMetadataBlock([]*nettrace.Metadata{
+		{Header: nettrace.MetadataHeader{MetaDataID: 1, ProviderName: "Test-Provider", EventID: 1, EventName: "First"}},
+		{Header: nettrace.MetadataHeader{MetaDataID: 2, ProviderName: "Test-Provider", EventID: 2, EventName: "Other"}},
+	}, true))
+	requireNoError(t, enc.EncodeStackBlock(&nettrace.StackBlock{Stacks: []nettrace.Stack{{ID: 1, Data: make([]byte, 8)}}}))
+	const blocks, events = 100, 10
+	seq := int32(0)
+	for i := 0; i < blocks; i++ {
+		blobs := make([]nettrace.Blob, events)
+		for j := range blobs {
+			id := int32(2)
+			if i == 0 {
+				id = 1
+			}
+			seq++
+			blobs[j] = nettrace.Blob{
+				Header: nettrace.BlobHeader{
+					MetadataID:      id,
+					SequenceNumber:  seq,
+					ThreadID:        1,
+					CaptureThreadID: 1,
+					StackID:         1,
+					TimeStamp:       int64(seq),
+				},
+				Payload: bytes.NewBuffer(make([]byte, 100)),
+			}
+		}
+		requireNoError(t, enc.EncodeEventBlock(blobs, true))
+	}
+	requireNoError(t, enc.Close())
+
+	r := nettrace.NewRecorder(nettrace.RecorderMaxSize(16 << 10))
+	_, err := io.Copy(r, &src)
+	requireNoError(t, err)
+	requireNoError(t, r.Close())
+	var b bytes.Buffer
+	_, err = r.WriteTo(&b)
+	requireNoError(t, err)
+
+	stream := nettrace.NewStream(&b)
+	_, err = stream.Open()
+	requireNoError(t, err)
+	var md []string
+	stream.MetadataHandler = func(m *nettrace.Metadata) error {
+		md = append(md, m.Header.EventName)
+		return nil
+	}
+	stacks := make(map[int32]bool)
+	stream.StackBlockHandler = func(sb *nettrace.StackBlock) error {
+		for _, s := range sb.Stacks {
+			stacks[s.ID] = true
+		}
+		return nil
+	}
+	var recorded int
+	stream.EventHandler = func(blob *nettrace.Blob) error {
+		if !stacks[blob.Header.StackID] {
+			t.Fatalf("stack %d is not recorded", blob.Header.StackID)
+		}
+		recorded++
+		return nil
+	}
+	for {
+		if err = stream.Next(); err == io.EOF {
+			break
+		}
+		requireNoError(t, err)
+	}
+	if recorded == 0 || recorded >= blocks*events {
+		t.Fatalf("expected a fraction of %d events, got %d", blocks*events, recorded)
+	}
+	// Events of the first block are evicted.
+	if len(md) != 1 || md[0] != "Other" {
+		t.Fatalf("unexpected metadata: %v", md)
+	}
+}
+
+func TestRecorderNothingRecorded(t *testing.T) {
+	r := nettrace.NewRecorder()
+	defer r.Close()
+	if _, err := r.WriteTo(io.Discard); !errors.Is(err, nettrace.ErrNothingRecorded) {
+		t.Fatalf("expected ErrNothingRecorded, got %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "trace.nettrace")
+	if err := r.Dump(path); !errors.Is(err, nettrace.ErrNothingRecorded) {
+		t.Fatalf("expected ErrNothingRecorded, got %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected the file not to be created, got %v", err)
+	}
+}
@@ -0,0 +1,225 @@
+package nettrace
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace/typecode"
+)
+
+// RedactAction specifies how a string field value is redacted.
+type RedactAction int
+
+const (
+	_ RedactAction = iota
+	// RedactHash replaces the value with a prefix of its HMAC-SHA256, hex
+	// encoded: equal values remain equal.
+	RedactHash
+	// RedactMask replaces every character of the value with '*'.
+	RedactMask
+	// RedactDrop replaces the value with an empty string.
+	RedactDrop
+)
+
+// RedactRule specifies a string field to be redacted.
+type RedactRule struct {
+	// Provider name; empty matches any provider.
+	Provider string
+	// EventIDs of the provider; empty matches any event.
+	EventIDs []int32
+	// Field name. Fields of nested objects and string arrays are matched
+	// by name as well.
+	Field  string
+	Action RedactAction
+}
+
+func (r *RedactRule) matches(md *MetadataHeader) bool {
+	if r.Provider != "" && r.Provider != md.ProviderName {
+		return false
+	}
+	if len(r.EventIDs) == 0 {
+		return true
+	}
+	for _, id := range r.EventIDs {
+		if id == md.EventID {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultRedactRules hash file paths and command lines of the runtime
+// events. Field definitions of the runtime events are not included in the
+// metadata, therefore RewriteMetadataFields is required for the rules to
+// take effect.
+var DefaultRedactRules = []RedactRule{
+	{Field: "ModuleILPath", Action: RedactHash},
+	{Field: "ModuleNativePath", Action: RedactHash},
+	{Field: "ManagedPdbBuildPath", Action: RedactHash},
+	{Field: "NativePdbBuildPath", Action: RedactHash},
+	{Field: "RuntimeDllPath", Action: RedactHash},
+	{Field: "CommandLine", Action: RedactHash},
+}
+
+var ErrRedactKeyNotSpecified = errors.New("redaction key is not specified")
+
+// RewriteRedact redacts string fields of the event payloads according to
+// the rules; the first matching rule applies. RedactHash uses the key,
+// which must be kept secret: otherwise, short values can be recovered by
+// hashing candidates.
+//
+// The fields are found with the event metadata: events which metadata does
+// not describe the payload are written as is, unless RewriteMetadataFields
+// is specified. Redaction fails closed: events of the runtime and rundown
+// providers which payload layout is not known, or is only known partially
+// (e.g., a newer event version), are dropped if any rule matches them, as
+// are events which metadata can not be decoded.
+func RewriteRedact(key []byte, rules ...RedactRule) RewriteOption {
+	// Metadata -> field name -> action.
+	actions := make(map[*Metadata]map[string]RedactAction)
+	return func(r *rewriter) {
+		r.payloads = append(r.payloads, func(md *Metadata, payload []byte) ([]byte, error) {
+			if md == nil {
+				if len(rules) > 0 {
+					return nil, errDropEvent
+				}
+				return payload, nil
+			}
+			a, ok := actions[md]
+			if !ok {
+				a = make(map[string]RedactAction)
+				for _, rule := range rules {
+					if _, exists := a[rule.Field]; !exists && rule.matches(&md.Header) {
+						a[rule.Field] = rule.Action
+					}
+				}
+				actions[md] = a
+			}
+			if len(a) == 0 {
+				return payload, nil
+			}
+			runtime := md.Header.ProviderName == runtimeProvider || md.Header.ProviderName == rundownProvider
+			if runtime && len(md.Payload.Fields) == 0 && len(payload) > 0 {
+				return nil, errDropEvent
+			}
+			out, n, err := redactPayload(payload, md.Payload.Fields, a, key)
+			if err != nil {
+				return nil, err
+			}
+			if runtime && n < len(payload) {
+				// The fields that follow are not known.
+				return nil, errDropEvent
+			}
+			return out, nil
+		})
+	}
+}
+
+// redactPayload returns the redacted payload, and the number of bytes the
+// fields take.
+func redactPayload(payload []byte, fields []MetadataField, actions map[string]RedactAction, key []byte) ([]byte, int, error) {
+	r := payloadRedactor{
+		d:       payloadDecoder{p: &Parser{Buffer: bytes.NewBuffer(payload)}},
+		src:     payload,
+		actions: actions,
+		key:     key,
+	}
+	if err := r.fields(fields); err != nil {
+		return nil, 0, fmt.Errorf("redacting payload: %w", err)
+	}
+	n := r.offset()
+	if r.out.Len() == 0 {
+		// Nothing has been redacted.
+		return payload, n, nil
+	}
+	r.out.Write(payload[r.copied:])
+	return r.out.Bytes(), n, nil
+}
+
+// payloadRedactor walks the payload according to the field definitions,
+// and copies it to out, replacing values of the redacted fields.
+type payloadRedactor struct {
+	d       payloadDecoder
+	src     []byte
+	out     bytes.Buffer
+	copied  int
+	actions map[string]RedactAction
+	key     []byte
+}
+
+func (r *payloadRedactor) offset() int { return len(r.src) - r.d.p.Len() }
+
+func (r *payloadRedactor) fields(fields []MetadataField) error {
+	for _, f := range fields {
+		if err := r.field(f, r.actions[f.Name]); err != nil {
+			return fmt.Errorf("field %s: %w", f.Name, err)
+		}
+	}
+	return r.d.p.Err()
+}
+
+// field reads a value of the field, or the array element f describes.
+func (r *payloadRedactor) field(f MetadataField, a RedactAction) error {
+	switch t := f.TypeCode; {
+	case t == typecode.Object:
+		return r.fields(f.Payload.Fields)
+	case t == typecode.Array && (f.ArrayTypeCode == typecode.Object || f.ArrayTypeCode == typecode.Array ||
+		f.ArrayTypeCode == typecode.String && a != 0):
+		var n uint16
+		r.d.p.Read(&n)
+		e := f.element()
+		for i := uint16(0); i < n && r.d.p.Err() == nil; i++ {
+			if err := r.field(e, a); err != nil {
+				return err
+			}
+		}
+		return r.d.p.Err()
+	case t == typecode.String && a != 0:
+		start := r.offset()
+		s := r.d.p.UTF16NTS()
+		if err := r.d.p.Err(); err != nil {
+			return err
+		}
+		if r.offset() == start {
+			// The string is not terminated.
+			return io.ErrUnexpectedEOF
+		}
+		v, err := redact(s, a, r.key)
+		if err != nil {
+			return err
+		}
+		r.out.Write(r.src[r.copied:start])
+		_ = binary.Write(&r.out, binary.LittleEndian, append(utf16.Encode([]rune(v)), 0))
+		r.copied = r.offset()
+		return nil
+	default:
+		_, err := r.d.value(f)
+		return err
+	}
+}
+
+func redact(s string, a RedactAction, key []byte) (string, error) {
+	switch {
+	case s == "":
+		return s, nil
+	case a == RedactHash:
+		if len(key) == 0 {
+			return "", ErrRedactKeyNotSpecified
+		}
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(s))
+		return hex.EncodeToString(h.Sum(nil)[:8]), nil
+	case a == RedactMask:
+		return strings.Repeat("*", len([]rune(s))), nil
+	default:
+		return "", nil
+	}
+}
@@ -0,0 +1,198 @@
+package nettrace_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+	"github.com/pyroscope-io/dotnetdiag/nettrace/typecode"
+)
+
+func TestRewriteRedact(t *testing.T) {
+	b := nettrace.NewBuilder()
+	id := b.Metadata("Test-Provider", 1, "Query",
+		nettrace.MetadataField{TypeCode: typecode.String, Name: "Sql"},
+		nettrace.MetadataField{TypeCode: typecode.Int32, Name: "Rows"},
+		nettrace.MetadataField{TypeCode: typecode.String, Name: "Url"},
+		nettrace.MetadataField{TypeCode: typecode.Array, ArrayTypeCode: typecode.String, Name: "Args"},
+		nettrace.MetadataField{TypeCode: typecode.String, Name: "Host"})
+	var p rawBuilder
+	p.write(utf16NTS("SELECT 1"), int32(7), utf16NTS("/users/1"), uint16(2), utf16NTS("a"), utf16NTS("bc"), utf16NTS("db"))
+	// Trailing bytes not described by the metadata are retained.
+	p.write(uint32(0xCAFE))
+	b.Event(nettrace.BlobHeader{MetadataID: id, ThreadID: 1}, p.Bytes())
+	var src bytes.Buffer
+	_, err := b.WriteTo(&src)
+	requireNoError(t, err)
+
+	var dst bytes.Buffer
+	requireNoError(t, nettrace.Rewrite(&dst, &src, nettrace.RewriteRedact([]byte("key"),
+		nettrace.RedactRule{Provider: "Other-Provider", Field: "Sql", Action: nettrace.RedactMask},
+		nettrace.RedactRule{Provider: "Test-Provider", EventIDs: []int32{1}, Field: "Sql", Action: nettrace.RedactHash},
+		nettrace.RedactRule{Field: "Url", Action: nettrace.RedactMask},
+		nettrace.RedactRule{Field: "Args", Action: nettrace.RedactDrop})))
+
+	stream := nettrace.NewStream(&dst)
+	_, err = stream.Open()
+	requireNoError(t, err)
+	var md *nettrace.Metadata
+	stream.MetadataHandler = func(m *nettrace.Metadata) error {
+		md = m
+		return nil
+	}
+	var fields map[string]interface{}
+	var trailer []byte
+	stream.EventHandler = func(blob *nettrace.Blob) error {
+		payload := blob.Payload.Bytes()
+		trailer = payload[len(payload)-4:]
+		fields, err = nettrace.DecodePayloadMap(blob, md)
+		return err
+	}
+	for {
+		if err = stream.Next(); err == io.EOF {
+			break
+		}
+		requireNoError(t, err)
+	}
+
+	sql, _ := fields["Sql"].(string)
+	if len(sql) != 16 || sql == "SELECT 1" {
+		t.Errorf("Sql is not hashed: %q", sql)
+	}
+	if fields["Url"] != "********" || fields["Rows"] != int32(7) || fields["Host"] != "db" {
+		t.Errorf("unexpected fields: %+v", fields)
+	}
+	if args, _ := fields["Args"].([]interface{}); len(args) != 2 || args[0] != "" || args[1] != "" {
+		t.Errorf("unexpected args: %+v", fields["Args"])
+	}
+	if !bytes.Equal(trailer, []byte{0xFE, 0xCA, 0, 0}) {
+		t.Errorf("unexpected trailing bytes: %x", trailer)
+	}
+}
+
+func TestRewriteRedactFailsClosed(t *testing.T) {
+	const runtime = "Microsoft-Windows-DotNETRuntime"
+	b := nettrace.NewBuilder()
+	known := b.Metadata(runtime, 152, "")
+	unknown := b.Metadata(runtime, 153, "")
+	other := b.Metadata("Test-Provider", 1, "Query",
+		nettrace.MetadataField{TypeCode: typecode.String, Name: "Sql"})
+	var path rawBuilder
+	path.write(utf16NTS(`C:\app.dll`))
+	b.Event(nettrace.BlobHeader{MetadataID: known, ThreadID: 1}, path.Bytes())
+	b.Event(nettrace.BlobHeader{MetadataID: unknown, ThreadID: 1}, path.Bytes())
+	// A newer event version: the fields that follow are not known.
+	var newer rawBuilder
+	newer.write(utf16NTS(`C:\app.dll`), utf16NTS(`C:\app.pdb`))
+	b.Event(nettrace.BlobHeader{MetadataID: known, ThreadID: 1}, newer.Bytes())
+	var sql rawBuilder
+	sql.write(utf16NTS("SELECT 1"))
+	b.Event(nettrace.BlobHeader{MetadataID: other, ThreadID: 1}, sql.Bytes())
+	var unterminated rawBuilder
+	unterminated.write([]uint16{'a', 'b'})
+	var src bytes.Buffer
+	_, err := b.WriteTo(&src)
+	requireNoError(t, err)
+
+	fields := func(md *nettrace.Metadata) ([]nettrace.MetadataField, bool) {
+		if md.Header.ProviderName == runtime && md.Header.EventID == 152 {
+			return []nettrace.MetadataField{{TypeCode: typecode.String, Name: "ModuleILPath"}}, true
+		}
+		return nil, false
+	}
+	redacted := func(rules ...nettrace.RedactRule) []*nettrace.Blob {
+		var dst bytes.Buffer
+		requireNoError(t, nettrace.Rewrite(&dst, bytes.NewReader(src.Bytes()),
+			nettrace.RewriteMetadataFields(fields),
+			nettrace.RewriteRedact([]byte("key"), rules...)))
+		var events []*nettrace.Blob
+		iterate(t, &dst, func(blob *nettrace.Blob) {
+			events = append(events, blob)
+		})
+		return events
+	}
+
+	events := redacted(nettrace.DefaultRedactRules...)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if bytes.Equal(events[0].Payload.Bytes(), path.Bytes()) || !bytes.Equal(events[1].Payload.Bytes(), sql.Bytes()) {
+		t.Fatalf("unexpected payloads: %x, %x", events[0].Payload.Bytes(), events[1].Payload.Bytes())
+	}
+	// The rules do not apply to the runtime events.
+	if events = redacted(nettrace.RedactRule{Provider: "Test-Provider", Field: "Sql", Action: nettrace.RedactMask}); len(events) != 4 {
+		t.Fatalf("expected 4 events, got %d", len(events))
+	}
+
+	b = nettrace.NewBuilder()
+	b.Event(nettrace.BlobHeader{MetadataID: b.Metadata(runtime, 152, ""), ThreadID: 1}, unterminated.Bytes())
+	src.Reset()
+	_, err = b.WriteTo(&src)
+	requireNoError(t, err)
+	err = nettrace.Rewrite(io.Discard, &src,
+		nettrace.RewriteMetadataFields(fields),
+		nettrace.RewriteRedact([]byte("key"), nettrace.DefaultRedactRules...))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestRewriteRedactKey(t *testing.T) {
+	b := nettrace.NewBuilder()
+	id := b.Metadata("Test-Provider", 1, "Query",
+		nettrace.MetadataField{TypeCode: typecode.String, Name: "Sql"})
+	var p rawBuilder
+	p.write(utf16NTS("SELECT 1"))
+	b.Event(nettrace.BlobHeader{MetadataID: id, ThreadID: 1}, p.Bytes())
+	var src bytes.Buffer
+	_, err := b.WriteTo(&src)
+	requireNoError(t, err)
+
+	rule := nettrace.RedactRule{Field: "Sql", Action: nettrace.RedactHash}
+	hash := func(key []byte) (string, error) {
+		var dst bytes.Buffer
+		if err := nettrace.Rewrite(&dst, bytes.NewReader(src.Bytes()), nettrace.RewriteRedact(key, rule)); err != nil {
+			return "", err
+		}
+		var s string
+		iterate(t, &dst, func(blob *nettrace.Blob) {
+			s = (&nettrace.Parser{Buffer: blob.Payload}).UTF16NTS()
+		})
+		return s, nil
+	}
+	ha, err := hash([]byte("a"))
+	requireNoError(t, err)
+	hb, err := hash([]byte("b"))
+	requireNoError(t, err)
+	mac := hmac.New(sha256.New, []byte("a"))
+	mac.Write([]byte("SELECT 1"))
+	if expected := hex.EncodeToString(mac.Sum(nil)[:8]); ha != expected || ha == hb {
+		t.Fatalf("unexpected hashes: %s, %s, expected %s", ha, hb, expected)
+	}
+	if _, err = hash(nil); !errors.Is(err, nettrace.ErrRedactKeyNotSpecified) {
+		t.Fatalf("expected ErrRedactKeyNotSpecified, got %v", err)
+	}
+}
+
+// iterate calls fn for every event of the stream.
+func iterate(t *testing.T, r io.Reader, fn func(*nettrace.Blob)) {
+	t.Helper()
+	stream := nettrace.NewStream(r)
+	_, err := stream.Open()
+	requireNoError(t, err)
+	stream.EventHandler = func(blob *nettrace.Blob) error {
+		fn(&nettrace.Blob{Header: blob.Header, Payload: bytes.NewBuffer(append([]byte(nil), blob.Payload.Bytes()...))})
+		return nil
+	}
+	for {
+		if err = stream.Next(); errors.Is(err, io.EOF) {
+			return
+		}
+		requireNoError(t, err)
+	}
+}
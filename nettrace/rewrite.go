@@ -0,0 +1,531 @@
+package nettrace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+const (
+	runtimeProvider = "Microsoft-Windows-DotNETRuntime"
+	// rundownProvider events describe methods and modules the stacks refer to.
+	rundownProvider = "Microsoft-Windows-DotNETRuntimeRundown"
+)
+
+// errDropEvent is returned by a payload transformation to drop the event.
+var errDropEvent = errors.New("event dropped")
+
+// RewriteOption restricts events kept by Rewrite, or transforms them. If
+// several filters are specified, an event is kept only if it satisfies all
+// of them.
+type RewriteOption func(*rewriter)
+
+// RewriteProviders keeps events of the given providers only.
+func RewriteProviders(names ...string) RewriteOption {
+	set := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		set[name] = struct{}{}
+	}
+	return func(r *rewriter) {
+		r.filters = append(r.filters, func(_ *BlobHeader, md *Metadata) bool {
+			if md == nil {
+				return false
+			}
+			_, ok := set[md.Header.ProviderName]
+			return ok
+		})
+	}
+}
+
+// RewriteEventIDs keeps events with the given IDs only.
+func RewriteEventIDs(ids ...int32) RewriteOption {
+	set := make(map[int32]struct{}, len(ids))
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+	return func(r *rewriter) {
+		r.filters = append(r.filters, func(_ *BlobHeader, md *Metadata) bool {
+			if md == nil {
+				return false
+			}
+			_, ok := set[md.Header.EventID]
+			return ok
+		})
+	}
+}
+
+// RewriteThreads keeps events of the given threads only. Rundown events and
+// EventSource manifests are kept regardless of the thread.
+func RewriteThreads(ids ...int64) RewriteOption {
+	set := make(map[int64]struct{}, len(ids))
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+	return func(r *rewriter) {
+		r.filters = append(r.filters, func(h *BlobHeader, md *Metadata) bool {
+			if isPersistent(md) {
+				return true
+			}
+			_, ok := set[h.ThreadID]
+			return ok
+		})
+	}
+}
+
+// RewriteTimeRange keeps events that occurred within [start, end). Zero
+// start or end time means the range is not bounded. Rundown events and
+// EventSource manifests are kept regardless of the time.
+func RewriteTimeRange(start, end time.Time) RewriteOption {
+	return func(r *rewriter) {
+		r.filters = append(r.filters, func(h *BlobHeader, md *Metadata) bool {
+			if isPersistent(md) {
+				return true
+			}
+			t := r.trace.Time(h.TimeStamp)
+			return (start.IsZero() || !t.Before(start)) && (end.IsZero() || t.Before(end))
+		})
+	}
+}
+
+// RewriteMetadataFields specifies field definitions of the events which
+// metadata does not describe the payload, for example, the runtime events
+// (see clr.Fields). The definitions are used by the options that decode the
+// payload, and are not written to the output. Pointers are assumed to be 8
+// bytes: traces of 32-bit processes are rejected.
+func RewriteMetadataFields(fields func(*Metadata) ([]MetadataField, bool)) RewriteOption {
+	return func(r *rewriter) {
+		r.fields = fields
+	}
+}
+
+// isPersistent reports whether the event is required to interpret other
+// events, and therefore should not be dropped by thread or time.
+func isPersistent(md *Metadata) bool {
+	return md != nil && (md.Header.ProviderName == rundownProvider || md.Header.EventID == ManifestDataEventID)
+}
+
+// Rewrite reads NetTrace stream from src and writes to dst a new stream of
+// the events that satisfy the options. Only the metadata records and stacks
+// the events refer to are written; stacks are renumbered. Sequence numbers
+// are adjusted for the dropped events, so that the events are not reported
+// as lost. Only NetTrace format versions 4 and 5 are supported.
+func Rewrite(dst io.Writer, src io.Reader, options ...RewriteOption) error {
+	return Merge(dst, []io.Reader{src}, options...)
+}
+
+// Merge reads NetTrace streams of the same process, for example, written
+// by consecutive sessions or by RotatingWriter, and writes them to dst as a
+// single stream, in the order of the first event or sequence point time,
+// with the Trace object of the earliest one. Metadata records are
+// deduplicated, and metadata and stack IDs are remapped. If the sequence
+// numbers of a thread go backwards, as in a new session, they are offset
+// to continue from the previous stream; otherwise, they are kept, so that
+// the events lost between the streams are still reported. Options apply
+// to the events like in Rewrite.
+func Merge(dst io.Writer, srcs []io.Reader, options ...RewriteOption) error {
+	if len(srcs) == 0 {
+		return io.ErrUnexpectedEOF
+	}
+	sources := make([]rewriteSource, len(srcs))
+	for i, src := range srcs {
+		dec := NewDecoder(src)
+		trace, err := dec.OpenTrace()
+		if err != nil {
+			return err
+		}
+		if err = checkRewritable(dec); err != nil {
+			return err
+		}
+		sources[i] = rewriteSource{dec: dec, trace: trace}
+		if err = sources[i].peek(); err != nil {
+			return err
+		}
+	}
+	sort.SliceStable(sources, func(i, j int) bool {
+		return sources[i].start.Before(sources[j].start)
+	})
+	base := sources[0].trace
+	for _, s := range sources[1:] {
+		if s.trace.ProcessID != base.ProcessID || s.trace.PointerSize != base.PointerSize {
+			return fmt.Errorf("%w: process %d, pointer size %d, expected process %d, pointer size %d", ErrTraceMismatch,
+				s.trace.ProcessID, s.trace.PointerSize, base.ProcessID, base.PointerSize)
+		}
+	}
+
+	r := rewriter{
+		enc:         NewEncoder(dst),
+		base:        base,
+		outMetadata: make(map[string]*rewriteMetadata),
+		metadataIDs: make(map[int32]struct{}),
+		sequences:   make(map[int64]int32),
+		offsets:     make(map[int64]int32),
+	}
+	for _, option := range options {
+		option(&r)
+	}
+	if r.fields != nil && base.PointerSize != 8 {
+		return fmt.Errorf("%w: metadata fields of %d byte pointers", ErrUnsupportedType, base.PointerSize)
+	}
+	if err := r.enc.EncodeTrace(base); err != nil {
+		return err
+	}
+	for _, s := range sources {
+		r.reset(s.trace)
+		for {
+			var o Object
+			err := s.next(&o)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if err = r.handleObject(o); err != nil {
+				return err
+			}
+		}
+	}
+	return r.enc.Close()
+}
+
+var ErrTraceMismatch = errors.New("traces of different processes can not be merged")
+
+// checkRewritable returns an error, if objects of the stream can not be
+// re-serialized: only NetTrace format versions 4 and 5 are supported.
+func checkRewritable(dec *Decoder) error {
+	if v := dec.FormatVersion(); v < netTraceFormatVersion || v >= netTraceV6 {
+		return fmt.Errorf("%w: %d", ErrUnsupportedFormatVersion, v)
+	}
+	return nil
+}
+
+type rewriteSource struct {
+	dec   *Decoder
+	trace *Trace
+	// start is the time of the first event block or sequence point.
+	start time.Time
+	// Objects read ahead to find the start time.
+	objects []Object
+	eof     bool
+}
+
+// peek reads objects up to the first event block or sequence point to find
+// the start time of the stream. If there is none, the sync time is used.
+func (s *rewriteSource) peek() error {
+	s.start = s.trace.SyncTime()
+	for {
+		var o Object
+		err := s.dec.Decode(&o)
+		if errors.Is(err, io.EOF) {
+			s.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		s.objects = append(s.objects, o)
+		switch o.Type {
+		case ObjectTypeEventBlock:
+			block, err := BlobBlockFromObject(copyObject(o))
+			if err != nil {
+				return err
+			}
+			s.start = s.trace.Time(block.Header.MinTimestamp)
+			return nil
+		case ObjectTypeSPBlock:
+			block, err := SequencePointBlockFromObject(copyObject(o))
+			if err != nil {
+				return err
+			}
+			s.start = s.trace.Time(block.TimeStamp)
+			return nil
+		}
+	}
+}
+
+// next reads the next object, starting with the ones read ahead.
+func (s *rewriteSource) next(o *Object) error {
+	if len(s.objects) > 0 {
+		*o = s.objects[0]
+		s.objects = s.objects[1:]
+		return nil
+	}
+	if s.eof {
+		return io.EOF
+	}
+	return s.dec.Decode(o)
+}
+
+type rewriter struct {
+	enc *Encoder
+	// Trace of the output stream, and of the stream being read.
+	base    *Trace
+	trace   *Trace
+	filters []func(*BlobHeader, *Metadata) bool
+	// Payload transformations, applied to the events kept.
+	payloads []func(*Metadata, []byte) ([]byte, error)
+	// fields, if specified, provides field definitions of the events
+	// whose metadata does not describe the payload.
+	fields func(*Metadata) ([]MetadataField, bool)
+
+	// Metadata ID of the stream being read -> output metadata record.
+	metadata map[int32]*rewriteMetadata
+	// Output metadata records by the payload without the ID, and the IDs
+	// in use.
+	outMetadata map[string]*rewriteMetadata
+	metadataIDs map[int32]struct{}
+	nextID      int32
+
+	// Stacks of the current segment (since the last sequence point):
+	// stack ID -> stack data.
+	stacks map[int32][]byte
+	// Stack ID -> stack ID in the output, within the current segment.
+	stackIDs    map[int32]int32
+	nextStackID int32
+
+	// Capture thread ID -> sequence number offset: the number of events
+	// dropped is subtracted, and, if the sequence numbers went backwards
+	// in a stream, the last sequence number written before it is added.
+	offsets map[int64]int32
+	// Capture thread ID -> the last sequence number written.
+	sequences map[int64]int32
+	// Capture threads seen in the stream being read.
+	threads map[int64]struct{}
+}
+
+type rewriteMetadata struct {
+	id      int32
+	payload []byte
+	// md is nil, if the record can not be decoded.
+	md      *Metadata
+	written bool
+}
+
+// reset prepares the rewriter for the next stream.
+func (r *rewriter) reset(t *Trace) {
+	r.trace = t
+	r.metadata = make(map[int32]*rewriteMetadata)
+	r.stacks = make(map[int32][]byte)
+	r.stackIDs = make(map[int32]int32)
+	r.threads = make(map[int64]struct{})
+}
+
+// offset returns the sequence number offset of the capture thread. next is
+// the sequence number of the next event of the thread in the stream being
+// read. When the thread is first seen in the stream, and the number does not
+// follow the last one written, the numbering is assumed to restart, and the
+// offset is set to continue from the last number written.
+func (r *rewriter) offset(thread int64, next int32) int32 {
+	if _, ok := r.threads[thread]; !ok {
+		r.threads[thread] = struct{}{}
+		if last, ok := r.sequences[thread]; ok && next+r.offsets[thread] <= last {
+			r.offsets[thread] = last
+		}
+	}
+	return r.offsets[thread]
+}
+
+// timestamp converts the timestamp of the stream being read to the clock
+// of the output stream. Streams of the same process share the clock, unless
+// the frequencies differ: then the timestamp is converted with the sync time,
+// which is only accurate to a millisecond.
+func (r *rewriter) timestamp(ts int64) int64 {
+	if r.trace.QPCFrequency == r.base.QPCFrequency {
+		return ts
+	}
+	d := float64(ts-r.trace.SyncTimeQPC) / float64(r.trace.QPCFrequency)
+	d += r.trace.SyncTime().Sub(r.base.SyncTime()).Seconds()
+	return r.base.SyncTimeQPC + int64(d*float64(r.base.QPCFrequency))
+}
+
+func (r *rewriter) handleObject(o Object) error {
+	switch o.Type {
+	case ObjectTypeMetadataBlock:
+		return r.handleMetadataBlock(o)
+	case ObjectTypeStackBlock:
+		block, err := StackBlockFromObject(o)
+		if err != nil {
+			return err
+		}
+		for _, s := range block.Stacks {
+			r.stacks[s.ID] = s.Data
+		}
+		return nil
+	case ObjectTypeEventBlock:
+		return r.handleEventBlock(o)
+	case ObjectTypeSPBlock:
+		block, err := SequencePointBlockFromObject(o)
+		if err != nil {
+			return err
+		}
+		block.TimeStamp = r.timestamp(block.TimeStamp)
+		for i, t := range block.Threads {
+			seq := t.SequenceNumber + r.offset(t.ThreadID, t.SequenceNumber+1)
+			block.Threads[i].SequenceNumber = seq
+			r.sequences[t.ThreadID] = seq
+		}
+		// Stack IDs are only valid until the next sequence point.
+		r.stacks = make(map[int32][]byte)
+		r.stackIDs = make(map[int32]int32)
+		return r.enc.EncodeSequencePointBlock(block)
+	default:
+		return r.enc.EncodeObject(o)
+	}
+}
+
+func (r *rewriter) handleMetadataBlock(o Object) error {
+	block, err := BlobBlockFromObject(o)
+	if err != nil {
+		return err
+	}
+	var blob Blob
+	for {
+		err = block.Next(&blob)
+		switch {
+		case err == nil:
+		case errors.Is(err, io.EOF):
+			return nil
+		default:
+			return err
+		}
+		if err = r.addMetadata(blob.Payload.Bytes()); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *rewriter) addMetadata(payload []byte) error {
+	var id int32
+	p := Parser{Buffer: bytes.NewBuffer(payload)}
+	p.Read(&id)
+	if err := p.Err(); err != nil {
+		return err
+	}
+	key := string(payload[4:])
+	if m, ok := r.outMetadata[key]; ok {
+		r.metadata[id] = m
+		return nil
+	}
+	m := rewriteMetadata{id: id, payload: append([]byte(nil), payload...)}
+	if _, used := r.metadataIDs[id]; used {
+		for {
+			r.nextID++
+			if _, used = r.metadataIDs[r.nextID]; !used {
+				break
+			}
+		}
+		m.id = r.nextID
+		binary.LittleEndian.PutUint32(m.payload, uint32(m.id))
+	}
+	r.metadataIDs[m.id] = struct{}{}
+	// Events that refer to a record that can not be decoded are
+	// only dropped if a filter requires metadata.
+	if md, err := metadataFromBlob(Blob{Payload: bytes.NewBuffer(payload)}, MetadataNetTrace); err == nil {
+		if len(md.Payload.Fields) == 0 && r.fields != nil {
+			if fields, ok := r.fields(md); ok {
+				md.Payload.Fields = fields
+			}
+		}
+		m.md = md
+	}
+	r.outMetadata[key] = &m
+	r.metadata[id] = &m
+	return nil
+}
+
+func (r *rewriter) handleEventBlock(o Object) error {
+	block, err := BlobBlockFromObject(o)
+	if err != nil {
+		return err
+	}
+	var (
+		events   []Blob
+		metadata []Blob
+		stacks   []Stack
+		blob     Blob
+	)
+	for {
+		err = block.Next(&blob)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		m := r.metadata[blob.Header.MetadataID]
+		var md *Metadata
+		if m != nil {
+			md = m.md
+		}
+		r.offset(blob.Header.CaptureThreadID, blob.Header.SequenceNumber)
+		if !r.keep(&blob.Header, md) {
+			r.offsets[blob.Header.CaptureThreadID]--
+			continue
+		}
+		payload := append([]byte(nil), blob.Payload.Bytes()...)
+		for _, transform := range r.payloads {
+			if payload, err = transform(md, payload); err != nil {
+				break
+			}
+		}
+		if errors.Is(err, errDropEvent) {
+			r.offsets[blob.Header.CaptureThreadID]--
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if m != nil {
+			if !m.written {
+				m.written = true
+				metadata = append(metadata, Blob{Payload: bytes.NewBuffer(m.payload)})
+			}
+			blob.Header.MetadataID = m.id
+		}
+		if id := blob.Header.StackID; id != 0 {
+			newID, ok := r.stackIDs[id]
+			if !ok {
+				r.nextStackID++
+				newID = r.nextStackID
+				r.stackIDs[id] = newID
+				stacks = append(stacks, Stack{ID: newID, Data: r.stacks[id]})
+			}
+			blob.Header.StackID = newID
+		}
+		blob.Header.SequenceNumber += r.offsets[blob.Header.CaptureThreadID]
+		r.sequences[blob.Header.CaptureThreadID] = blob.Header.SequenceNumber
+		blob.Header.TimeStamp = r.timestamp(blob.Header.TimeStamp)
+		events = append(events, Blob{
+			Header:  blob.Header,
+			Payload: bytes.NewBuffer(payload),
+			sorted:  blob.sorted,
+		})
+	}
+	if len(metadata) > 0 {
+		if err = r.enc.encodeBlobBlock(ObjectTypeMetadataBlock, metadata, block.IsCompressed()); err != nil {
+			return err
+		}
+	}
+	if len(stacks) > 0 {
+		if err = r.enc.EncodeStackBlock(&StackBlock{Stacks: stacks}); err != nil {
+			return err
+		}
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	return r.enc.EncodeEventBlock(events, block.IsCompressed())
+}
+
+func (r *rewriter) keep(h *BlobHeader, md *Metadata) bool {
+	for _, f := range r.filters {
+		if !f(h, md) {
+			return false
+		}
+	}
+	return true
+}
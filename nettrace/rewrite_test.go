@@ -0,0 +1,221 @@
+package nettrace_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+)
+
+func TestRewrite(t *testing.T) {
+	b := nettrace.NewBuilder()
+	b.Compressed = true
+	request := b.Metadata("Test-Provider", 1, "Request")
+	_ = b.Metadata("Test-Provider", 2, "Response")
+	other := b.Metadata("Other-Provider", 1, "Other")
+	_ = b.Stack(0x1)
+	stack := b.Stack(0x2)
+	b.Event(nettrace.BlobHeader{MetadataID: request, ThreadID: 1, TimeStamp: 100}, nil)
+	b.Event(nettrace.BlobHeader{MetadataID: other, ThreadID: 1, TimeStamp: 200}, nil)
+	b.Event(nettrace.BlobHeader{MetadataID: request, ThreadID: 1, TimeStamp: 300, StackID: stack}, nil)
+	b.Event(nettrace.BlobHeader{MetadataID: request, ThreadID: 2, TimeStamp: 400}, nil)
+	var src bytes.Buffer
+	_, err := b.WriteTo(&src)
+	requireNoError(t, err)
+
+	var dst bytes.Buffer
+	requireNoError(t, nettrace.Rewrite(&dst, &src,
+		nettrace.RewriteProviders("Test-Provider"),
+		nettrace.RewriteThreads(1),
+		nettrace.RewriteTimeRange(b.Trace.Time(150), b.Trace.Time(400))))
+
+	stream := nettrace.NewStream(&dst)
+	_, err = stream.Open()
+	requireNoError(t, err)
+	var md []string
+	stream.MetadataHandler = func(m *nettrace.Metadata) error {
+		md = append(md, m.Header.EventName)
+		return nil
+	}
+	var stacks []nettrace.Stack
+	stream.StackBlockHandler = func(sb *nettrace.StackBlock) error {
+		stacks = append(stacks, sb.Stacks...)
+		return nil
+	}
+	var sp *nettrace.SequencePointBlock
+	stream.SequencePointBlockHandler = func(b *nettrace.SequencePointBlock) error {
+		sp = b
+		return nil
+	}
+	var events []nettrace.BlobHeader
+	stream.EventHandler = func(blob *nettrace.Blob) error {
+		events = append(events, blob.Header)
+		return nil
+	}
+	for {
+		if err = stream.Next(); err == io.EOF {
+			break
+		}
+		requireNoError(t, err)
+	}
+
+	if !reflect.DeepEqual(md, []string{"Request"}) {
+		t.Fatalf("unexpected metadata: %v", md)
+	}
+	if len(stacks) != 1 || stacks[0].ID != 1 || stacks[0].Data[0] != 0x2 {
+		t.Fatalf("unexpected stacks: %+v", stacks)
+	}
+	expected := []nettrace.BlobHeader{{
+		MetadataID:      request,
+		SequenceNumber:  1,
+		ThreadID:        1,
+		CaptureThreadID: 1,
+		StackID:         1,
+		TimeStamp:       300,
+	}}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+	threads := []nettrace.Thread{{ThreadID: 1, SequenceNumber: 1}, {ThreadID: 2, SequenceNumber: 0}}
+	if sp == nil || !reflect.DeepEqual(sp.Threads, threads) {
+		t.Fatalf("unexpected sequence point: %+v", sp)
+	}
+}
+
+func TestRewriteKeepsAllEvents(t *testing.T) {
+	const sample = "testdata/dotnet-5.0-SampleProfiler-webapp.golden.nettrace"
+	b, err := os.ReadFile(sample)
+	requireNoError(t, err)
+	var dst bytes.Buffer
+	requireNoError(t, nettrace.Rewrite(&dst, bytes.NewReader(b)))
+	if expected, actual := countEvents(t, bytes.NewReader(b)), countEvents(t, &dst); actual != expected {
+		t.Fatalf("expected %d events, got %d", expected, actual)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a := nettrace.NewBuilder()
+	a.Trace.Year, a.Trace.Month, a.Trace.Day = 2021, 6, 1
+	a.Trace.SyncTimeQPC = 1000
+	request := a.Metadata("Test-Provider", 1, "Request")
+	a.Event(nettrace.BlobHeader{MetadataID: request, ThreadID: 1, TimeStamp: 1000, StackID: a.Stack(0x1)}, nil)
+
+	b := nettrace.NewBuilder()
+	b.Trace = a.Trace
+	b.Trace.Second = 1
+	b.Trace.QPCFrequency = 1e6
+	b.Trace.SyncTimeQPC = 500
+	response := b.Metadata("Test-Provider", 2, "Response")
+	_ = b.Metadata("Test-Provider", 1, "Request")
+	b.Event(nettrace.BlobHeader{MetadataID: response, ThreadID: 1, TimeStamp: 500 + 5e5, StackID: b.Stack(0x2)}, nil)
+	b.Event(nettrace.BlobHeader{MetadataID: 2, ThreadID: 1, TimeStamp: 500 + 6e5}, nil)
+
+	// The second trace is passed first: the order is defined by the start time.
+	var srcs []io.Reader
+	for _, x := range []*nettrace.Builder{b, a} {
+		var buf bytes.Buffer
+		_, err := x.WriteTo(&buf)
+		requireNoError(t, err)
+		srcs = append(srcs, &buf)
+	}
+	var dst bytes.Buffer
+	requireNoError(t, nettrace.Merge(&dst, srcs))
+
+	stream := nettrace.NewStream(&dst)
+	trace, err := stream.Open()
+	requireNoError(t, err)
+	if trace.SyncTimeQPC != a.Trace.SyncTimeQPC || trace.QPCFrequency != a.Trace.QPCFrequency {
+		t.Fatalf("unexpected trace: %+v", trace)
+	}
+	md := make(map[int32]string)
+	stream.MetadataHandler = func(m *nettrace.Metadata) error {
+		md[m.Header.MetaDataID] = m.Header.EventName
+		return nil
+	}
+	var events []string
+	stream.EventHandler = func(blob *nettrace.Blob) error {
+		h := blob.Header
+		events = append(events, fmt.Sprintf("%s seq=%d stack=%d ts=%d",
+			md[h.MetadataID], h.SequenceNumber, h.StackID, h.TimeStamp))
+		return nil
+	}
+	for {
+		if err = stream.Next(); err == io.EOF {
+			break
+		}
+		requireNoError(t, err)
+	}
+	expected := []string{
+		"Request seq=1 stack=1 ts=1000",
+		"Response seq=2 stack=2 ts=15001000",
+		"Request seq=3 stack=0 ts=16001000",
+	}
+	if !reflect.DeepEqual(events, expected) || len(md) != 2 {
+		t.Fatalf("unexpected events: %q, metadata: %v", events, md)
+	}
+}
+
+func TestSplitMerge(t *testing.T) {
+	const (
+		sample   = "testdata/dotnet-5.0-SampleProfiler-webapp.golden.nettrace"
+		expected = "testdata/dotnet-5.0-SampleProfiler-webapp.txt"
+	)
+	dir := t.TempDir()
+	f, err := os.Open(sample)
+	requireNoError(t, err)
+	defer f.Close()
+	requireNoError(t, nettrace.Split(f, nettrace.NumberedFiles(filepath.Join(dir, "trace.nettrace")),
+		nettrace.RotateByDuration(10*time.Second)))
+
+	files, err := filepath.Glob(filepath.Join(dir, "trace.*.nettrace"))
+	requireNoError(t, err)
+	if len(files) < 2 {
+		t.Fatalf("expected multiple files, got %d", len(files))
+	}
+	// The order is defined by the first event time, not by the file names.
+	var srcs []io.Reader
+	for i := len(files) - 1; i >= 0; i-- {
+		f, err := os.Open(files[i])
+		requireNoError(t, err)
+		defer f.Close()
+		srcs = append(srcs, f)
+	}
+	merged := filepath.Join(dir, "merged.nettrace")
+	out, err := os.Create(merged)
+	requireNoError(t, err)
+	requireNoError(t, nettrace.Merge(out, srcs))
+	requireNoError(t, out.Close())
+	requireEqual(t, merged, expected)
+	// Sequence numbers continue across the files.
+	if a, b := lostEvents(t, sample), lostEvents(t, merged); !reflect.DeepEqual(a, b) {
+		t.Fatalf("lost events: %+v, expected %+v", b, a)
+	}
+}
+
+func lostEvents(t *testing.T, name string) []nettrace.LostEvents {
+	t.Helper()
+	f, err := os.Open(name)
+	requireNoError(t, err)
+	defer f.Close()
+	stream := nettrace.NewStream(f)
+	_, err = stream.Open()
+	requireNoError(t, err)
+	var lost []nettrace.LostEvents
+	stream.LostEventsHandler = func(l *nettrace.LostEvents) error {
+		lost = append(lost, *l)
+		return nil
+	}
+	for {
+		if err = stream.Next(); errors.Is(err, io.EOF) {
+			return lost
+		}
+		requireNoError(t, err)
+	}
+}
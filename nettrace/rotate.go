@@ -0,0 +1,273 @@
+package nettrace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RotatingWriter splits NetTrace stream written to it into a sequence of
+// size- or time-bounded files. Every file is a valid NetTrace stream on its
+// own: every file starts with the NetTrace header and the Trace object, and
+// the metadata records are written to a file before the first event that
+// refers to them.
+//
+// The writer is intended to be used as a tee, e.g.:
+//
+//	w := nettrace.NewRotatingWriter(nettrace.NumberedFiles("trace.nettrace"),
+//		nettrace.RotateBySize(64<<20))
+//	stream := nettrace.NewStream(io.TeeReader(session, w))
+//
+// Files are only switched right after a sequence point block: stack IDs
+// referenced by events are not valid beyond it. A stream that does not
+// contain sequence points is written to a single file.
+//
+// Errors that occur while the stream is being decoded do not fail writes:
+// the rest of the stream is discarded, and the error is returned by Err
+// and Close. Close must be called, even if the stream has been read to the
+// end.
+type RotatingWriter struct {
+	sink   *objectSink
+	create func(int) (io.WriteCloser, error)
+	now    func() time.Time
+
+	maxSize     uint64
+	maxDuration time.Duration
+	traceTime   bool
+	// The latest event timestamp, if traceTime is set.
+	latest int64
+
+	trace *Trace
+	// Metadata records by ID, and the IDs of the records written to the
+	// current file.
+	metadata map[int32][]byte
+	written  map[int32]struct{}
+
+	seq     int
+	file    io.WriteCloser
+	enc     *Encoder
+	created time.Time
+}
+
+// RotateOption overrides default RotatingWriter parameters.
+type RotateOption func(*RotatingWriter)
+
+// RotateBySize specifies the file size in bytes upon reaching which the
+// next file is to be created.
+func RotateBySize(n uint64) RotateOption {
+	return func(w *RotatingWriter) {
+		w.maxSize = n
+	}
+}
+
+// RotateByDuration specifies for how long data is written to a file
+// before the next one is created.
+func RotateByDuration(d time.Duration) RotateOption {
+	return func(w *RotatingWriter) {
+		w.maxDuration = d
+	}
+}
+
+// RotateByTraceTime specifies that the duration is measured with the event
+// timestamps instead of the wall clock, e.g. when an existing trace is split.
+func RotateByTraceTime() RotateOption {
+	return func(w *RotatingWriter) {
+		w.traceTime = true
+	}
+}
+
+// NewRotatingWriter creates a new RotatingWriter. The create function is
+// called with the file sequence number, starting from zero. If neither
+// size nor duration limit is specified, a single file is written.
+func NewRotatingWriter(create func(n int) (io.WriteCloser, error), options ...RotateOption) *RotatingWriter {
+	w := RotatingWriter{
+		create:   create,
+		now:      time.Now,
+		metadata: make(map[int32][]byte),
+	}
+	for _, option := range options {
+		option(&w)
+	}
+	w.sink = newObjectSink(&w)
+	return &w
+}
+
+// NumberedFiles returns a function that creates files named after the given
+// path with the sequence number inserted before the extension, for example:
+// trace.0.nettrace, trace.1.nettrace, etc.
+func NumberedFiles(path string) func(int) (io.WriteCloser, error) {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	return func(n int) (io.WriteCloser, error) {
+		return os.Create(fmt.Sprintf("%s.%d%s", base, n, ext))
+	}
+}
+
+// Split writes NetTrace stream read from src to a sequence of size- or
+// time-bounded files, as RotatingWriter does. The duration is measured with
+// the event timestamps.
+func Split(src io.Reader, create func(n int) (io.WriteCloser, error), options ...RotateOption) error {
+	w := NewRotatingWriter(create, append(options, RotateByTraceTime())...)
+	if _, err := io.Copy(w, src); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (w *RotatingWriter) Write(b []byte) (int, error) {
+	return w.sink.Write(b)
+}
+
+// Err returns the error that stopped writing files, if any.
+func (w *RotatingWriter) Err() error {
+	return w.sink.Err()
+}
+
+// Close completes the current file. The call returns an error if the
+// stream written is incomplete or malformed.
+func (w *RotatingWriter) Close() error {
+	err := w.sink.Close()
+	if cerr := w.closeFile(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (w *RotatingWriter) handleTrace(t *Trace) error {
+	w.trace = t
+	if w.traceTime {
+		w.now = func() time.Time {
+			if w.latest == 0 {
+				return time.Time{}
+			}
+			return t.Time(w.latest)
+		}
+	}
+	return w.openFile()
+}
+
+func (w *RotatingWriter) handleObject(o Object) error {
+	switch o.Type {
+	case ObjectTypeMetadataBlock:
+		return w.handleMetadataBlock(o)
+	case ObjectTypeEventBlock:
+		if err := w.handleEventBlock(o); err != nil {
+			return err
+		}
+	}
+	if err := w.enc.EncodeObject(o); err != nil {
+		return err
+	}
+	if o.Type != ObjectTypeSPBlock || !w.shouldRotate() {
+		return nil
+	}
+	if err := w.closeFile(); err != nil {
+		return err
+	}
+	w.seq++
+	return w.openFile()
+}
+
+// handleMetadataBlock retains the metadata records: they are written
+// to a file before the first event that refers to them.
+func (w *RotatingWriter) handleMetadataBlock(o Object) error {
+	block, err := BlobBlockFromObject(o)
+	if err != nil {
+		return err
+	}
+	var blob Blob
+	for {
+		err = block.Next(&blob)
+		switch {
+		case err == nil:
+		case errors.Is(err, io.EOF):
+			return nil
+		default:
+			return err
+		}
+		payload := blob.Payload.Bytes()
+		if len(payload) < 4 {
+			return io.ErrUnexpectedEOF
+		}
+		id := int32(binary.LittleEndian.Uint32(payload))
+		w.metadata[id] = append([]byte(nil), payload...)
+	}
+}
+
+// handleEventBlock writes the metadata records the events refer to,
+// if they have not been written to the current file yet.
+func (w *RotatingWriter) handleEventBlock(o Object) error {
+	block, err := BlobBlockFromObject(copyObject(o))
+	if err != nil {
+		return err
+	}
+	if w.traceTime {
+		if block.Header.MaxTimestamp > w.latest {
+			w.latest = block.Header.MaxTimestamp
+		}
+		if w.created.IsZero() {
+			w.created = w.trace.Time(block.Header.MinTimestamp)
+		}
+	}
+	var (
+		metadata []Blob
+		blob     Blob
+	)
+	for {
+		err = block.Next(&blob)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		id := blob.Header.MetadataID
+		if _, ok := w.written[id]; ok {
+			continue
+		}
+		if payload, ok := w.metadata[id]; ok {
+			w.written[id] = struct{}{}
+			metadata = append(metadata, Blob{Payload: bytes.NewBuffer(payload)})
+		}
+	}
+	if len(metadata) == 0 {
+		return nil
+	}
+	return w.enc.encodeBlobBlock(ObjectTypeMetadataBlock, metadata, block.IsCompressed())
+}
+
+func (w *RotatingWriter) shouldRotate() bool {
+	return (w.maxSize > 0 && w.enc.w.offset >= w.maxSize) ||
+		(w.maxDuration > 0 && w.now().Sub(w.created) >= w.maxDuration)
+}
+
+func (w *RotatingWriter) openFile() error {
+	f, err := w.create(w.seq)
+	if err != nil {
+		return err
+	}
+	w.file = f
+	w.created = w.now()
+	w.enc = NewEncoder(f)
+	w.written = make(map[int32]struct{})
+	return w.enc.EncodeTrace(w.trace)
+}
+
+func (w *RotatingWriter) closeFile() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.enc.Close()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	w.file = nil
+	return err
+}
@@ -0,0 +1,123 @@
+package nettrace_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+)
+
+func TestRotatingWriter(t *testing.T) {
+	const sample = "testdata/dotnet-5.0-SampleProfiler-webapp.golden.nettrace"
+	path := filepath.Join(t.TempDir(), "trace.nettrace")
+
+	s, err := os.Open(sample)
+	requireNoError(t, err)
+	defer s.Close()
+
+	w := nettrace.NewRotatingWriter(nettrace.NumberedFiles(path), nettrace.RotateBySize(512<<10))
+	expected := countEvents(t, io.TeeReader(s, w))
+	requireNoError(t, w.Close())
+
+	files, err := filepath.Glob(filepath.Join(filepath.Dir(path), "trace.*.nettrace"))
+	requireNoError(t, err)
+	if len(files) < 2 {
+		t.Fatalf("expected multiple files, got %d", len(files))
+	}
+
+	var actual int
+	for _, name := range files {
+		f, err := os.Open(name)
+		requireNoError(t, err)
+		actual += countEvents(t, f)
+		_ = f.Close()
+		requireReferencedMetadata(t, name)
+	}
+	if actual != expected {
+		t.Fatalf("expected %d events, got %d", expected, actual)
+	}
+}
+
+// requireReferencedMetadata checks that every metadata record of the file
+// is written once, and is referenced by an event.
+func requireReferencedMetadata(t *testing.T, name string) {
+	t.Helper()
+	f, err := os.Open(name)
+	requireNoError(t, err)
+	defer f.Close()
+	stream := nettrace.NewStream(f)
+	_, err = stream.Open()
+	requireNoError(t, err)
+	md := make(map[int32]bool)
+	stream.MetadataHandler = func(m *nettrace.Metadata) error {
+		if _, ok := md[m.Header.MetaDataID]; ok {
+			t.Fatalf("%s: metadata %d is written twice", name, m.Header.MetaDataID)
+		}
+		md[m.Header.MetaDataID] = false
+		return nil
+	}
+	stream.EventHandler = func(blob *nettrace.Blob) error {
+		md[blob.Header.MetadataID] = true
+		return nil
+	}
+	for {
+		if err = stream.Next(); err == io.EOF {
+			break
+		}
+		requireNoError(t, err)
+	}
+	for id, referenced := range md {
+		if !referenced {
+			t.Fatalf("%s: metadata %d is not referenced", name, id)
+		}
+	}
+}
+
+func TestRotatingWriterCorruptStream(t *testing.T) {
+	b, err := os.ReadFile("testdata/dotnet-5.0-SampleProfiler-webapp.golden.nettrace")
+	requireNoError(t, err)
+	// Corrupt the type name of an object in the middle of the stream.
+	i := bytes.Index(b[len(b)/2:], []byte("EventBlock")) + len(b)/2
+	b[i] = 'X'
+
+	path := filepath.Join(t.TempDir(), "trace.nettrace")
+	w := nettrace.NewRotatingWriter(nettrace.NumberedFiles(path), nettrace.RotateBySize(512<<10))
+	// The primary consumer of the stream is not affected.
+	n, err := io.Copy(io.Discard, io.TeeReader(bytes.NewReader(b), w))
+	requireNoError(t, err)
+	if n != int64(len(b)) {
+		t.Fatalf("expected %d bytes to be copied, got %d", len(b), n)
+	}
+	if err = w.Close(); !errors.Is(err, nettrace.ErrInvalidObjectType) {
+		t.Fatalf("expected ErrInvalidObjectType, got %v", err)
+	}
+	if err = w.Err(); !errors.Is(err, nettrace.ErrInvalidObjectType) {
+		t.Fatalf("expected ErrInvalidObjectType, got %v", err)
+	}
+}
+
+func countEvents(t *testing.T, r io.Reader) int {
+	t.Helper()
+	stream := nettrace.NewStream(r)
+	_, err := stream.Open()
+	requireNoError(t, err)
+	var n int
+	stream.EventHandler = func(*nettrace.Blob) error {
+		n++
+		return nil
+	}
+	stream.MetadataHandler = func(*nettrace.Metadata) error { return nil }
+	for {
+		switch err = stream.Next(); err {
+		case nil:
+		case io.EOF:
+			return n
+		default:
+			requireNoError(t, err)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package nettrace
+
+import (
+	"errors"
+	"io"
+)
+
+// objectHandler is called by objectSink for every object decoded.
+type objectHandler interface {
+	handleTrace(*Trace) error
+	handleObject(Object) error
+}
+
+// objectSink is an io.Writer that decodes NetTrace stream written to it
+// and passes decoded objects to the handler. This allows to tee a stream
+// that is being consumed by a Stream or Decoder.
+//
+// Handlers are called from a separate goroutine; Write returns once the
+// written bytes are consumed by the decoder, which does not guarantee that
+// the handler has returned. The goroutine exits when the end of the stream
+// is reached, or an error occurs; otherwise, Close must be called.
+//
+// A decoding or handler error does not fail writes, as the tee must not
+// break the primary consumer of the stream: the rest of the stream is
+// discarded, and the error is returned by Err and Close.
+type objectSink struct {
+	pw     *io.PipeWriter
+	done   chan struct{}
+	err    error
+	closed bool
+}
+
+func newObjectSink(h objectHandler) *objectSink {
+	pr, pw := io.Pipe()
+	s := objectSink{pw: pw, done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		s.err = decodeObjects(pr, h)
+		_ = pr.CloseWithError(s.err)
+	}()
+	return &s
+}
+
+func (s *objectSink) Write(b []byte) (int, error) {
+	if s.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if _, err := s.pw.Write(b); err != nil {
+		// The decoder has stopped: the error, if any, is reported
+		// by Err and Close.
+		<-s.done
+	}
+	return len(b), nil
+}
+
+// Err returns the error that stopped decoding, if any.
+func (s *objectSink) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+// Close waits for the handler to process the remaining objects and returns
+// the first error occurred, if any.
+func (s *objectSink) Close() error {
+	s.closed = true
+	_ = s.pw.Close()
+	<-s.done
+	return s.err
+}
+
+func decodeObjects(r io.Reader, h objectHandler) error {
+	dec := NewDecoder(r)
+	trace, err := dec.OpenTrace()
+	switch {
+	case err == nil:
+	case errors.Is(err, io.EOF):
+		// Nothing has been written.
+		return nil
+	default:
+		return err
+	}
+	if err = checkRewritable(dec); err != nil {
+		return err
+	}
+	if err = h.handleTrace(trace); err != nil {
+		return err
+	}
+	for {
+		var o Object
+		err = dec.Decode(&o)
+		switch {
+		case err == nil:
+		case errors.Is(err, io.EOF):
+			return nil
+		default:
+			return err
+		}
+		if err = h.handleObject(o); err != nil {
+			return err
+		}
+	}
+}
@@ -23,7 +23,7 @@ const (
 	Double   // A floating point type representing values ranging from approximately 5.0 x 10 -324 to 1.7 x 10 308 with a precision of 15-16 digits.
 	Decimal  // A simple type representing values ranging from 1.0 x 10 -28 to approximately 7.9 x 10 28 with 28-29 significant digits.
 	DateTime // A type representing a date and time value.
-	_        // http://blogs.ugidotnet.org/adrian/archive/2008/03/17/91755.aspx
+	Guid     // Not defined by System.TypeCode (http://blogs.ugidotnet.org/adrian/archive/2008/03/17/91755.aspx), EventPipe uses it for GUIDs.
 	String   // A sealed class type representing Unicode character strings.
 	Array    // https://github.com/microsoft/perfview/blob/main/src/TraceEvent/EventPipe/EventPipeFormat.md#metadata-event-encoding
 )
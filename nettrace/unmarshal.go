@@ -0,0 +1,342 @@
+package nettrace
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var ErrUnsupportedType = errors.New("unsupported type")
+
+// Unmarshal decodes the event payload into the struct v points to. Unlike
+// DecodePayload, the layout is defined by the struct, and the metadata is
+// not needed. The blob payload is not consumed.
+//
+// Fields are read in the declaration order:
+//   - integers, floats, and their fixed size arrays are read as is;
+//   - bool is a 4 byte Win32 BOOL;
+//   - string is a null terminated UTF-16 string;
+//   - time.Time is a FILETIME;
+//   - [16]byte is a GUID;
+//   - a slice is prefixed with uint16 number of elements, unless the
+//     number is specified by an earlier integer field with "count=Name";
+//   - nested structs are read in place.
+//
+// Blank (_) fields are skipped: the payload bytes are discarded. Other
+// unexported fields, and fields tagged "-" are ignored.
+//
+// A field tagged "optional", and all the fields that follow it, may be
+// absent: this allows decoding events of earlier versions into a struct
+// describing the latest one. Absent fields keep zero values.
+//
+// A uint64 field (or an array or slice of them) tagged "pointer" holds
+// pointers of the traced process. Unmarshal reads them as 8 byte values:
+// use Trace.Unmarshal to decode payloads of 32-bit processes.
+//
+// Tag options are comma-separated, e.g.:
+//
+//	type StackWalk struct {
+//		ClrInstanceID uint16
+//		_             [2]byte
+//		FrameCount    int32
+//		Stack         []uint64 `nettrace:"count=FrameCount,pointer"`
+//	}
+func Unmarshal(blob *Blob, v interface{}) error {
+	return unmarshal(blob, v, 8)
+}
+
+// Unmarshal is like the Unmarshal function, but pointer fields are read
+// according to the trace pointer size.
+func (t *Trace) Unmarshal(blob *Blob, v interface{}) error {
+	if t.PointerSize != 4 && t.PointerSize != 8 {
+		return fmt.Errorf("%w: %d byte pointers", ErrUnsupportedType, t.PointerSize)
+	}
+	return unmarshal(blob, v, int(t.PointerSize))
+}
+
+func unmarshal(blob *Blob, v interface{}, pointerSize int) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("%w: %T: pointer to struct expected", ErrUnsupportedType, v)
+	}
+	u := unmarshaler{
+		p:           &Parser{Buffer: bytes.NewBuffer(blob.Payload.Bytes())},
+		pointerSize: pointerSize,
+	}
+	return u.structure(rv.Elem())
+}
+
+type unmarshaler struct {
+	p           *Parser
+	pointerSize int
+}
+
+type fieldTag struct {
+	ignore   bool
+	optional bool
+	pointer  bool
+	count    string
+}
+
+func parseFieldTag(s string) fieldTag {
+	var t fieldTag
+	for _, o := range strings.Split(s, ",") {
+		switch {
+		case o == "-":
+			t.ignore = true
+		case o == "optional":
+			t.optional = true
+		case o == "pointer":
+			t.pointer = true
+		case strings.HasPrefix(o, "count="):
+			t.count = strings.TrimPrefix(o, "count=")
+		}
+	}
+	return t
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (u *unmarshaler) structure(v reflect.Value) error {
+	t := v.Type()
+	var optional bool
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := parseFieldTag(f.Tag.Get("nettrace"))
+		if tag.ignore || (f.PkgPath != "" && f.Name != "_") {
+			continue
+		}
+		if optional = optional || tag.optional; optional && u.p.Len() == 0 {
+			return nil
+		}
+		var err error
+		switch {
+		case f.Name == "_":
+			err = u.skip(int(f.Type.Size()))
+		case tag.count != "" && f.Type.Kind() == reflect.Slice:
+			var n int
+			if n, err = count(v, tag.count); err == nil {
+				err = u.slice(v.Field(i), n, tag.pointer)
+			}
+		case tag.pointer:
+			err = u.pointer(v.Field(i))
+		default:
+			err = u.value(v.Field(i))
+		}
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return fmt.Errorf("field %s: %w", f.Name, err)
+		}
+	}
+	return nil
+}
+
+const maxInt = int(^uint(0) >> 1)
+
+// count returns the value of the integer field name.
+func count(v reflect.Value, name string) (int, error) {
+	c := v.FieldByName(name)
+	switch c.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if c.Int() < 0 || uint64(c.Int()) > uint64(maxInt) {
+			return 0, fmt.Errorf("invalid count %s: %d", name, c.Int())
+		}
+		return int(c.Int()), nil
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if c.Uint() > uint64(maxInt) {
+			return 0, fmt.Errorf("invalid count %s: %d", name, c.Uint())
+		}
+		return int(c.Uint()), nil
+	default:
+		return 0, fmt.Errorf("%w: count field %q is not an integer", ErrUnsupportedType, name)
+	}
+}
+
+func (u *unmarshaler) skip(n int) error {
+	if n > u.p.Len() {
+		return io.ErrUnexpectedEOF
+	}
+	u.p.Skip(n)
+	return nil
+}
+
+func (u *unmarshaler) value(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		u.p.Read(v.Addr().Interface())
+	case reflect.Bool:
+		var x int32
+		u.p.Read(&x)
+		v.SetBool(x != 0)
+	case reflect.String:
+		if u.p.Len() == 0 {
+			return io.ErrUnexpectedEOF
+		}
+		v.SetString(u.p.UTF16NTS())
+	case reflect.Array:
+		if fixedSize(v.Type().Elem()) {
+			u.p.Read(v.Addr().Interface())
+			break
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := u.value(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		var n uint16
+		u.p.Read(&n)
+		if err := u.p.Err(); err != nil {
+			return err
+		}
+		return u.slice(v, int(n), false)
+	case reflect.Struct:
+		if v.Type() == timeType {
+			var x int64
+			u.p.Read(&x)
+			x -= fileTimeUnixEpoch
+			v.Set(reflect.ValueOf(time.Unix(x/1e7, x%1e7*100).UTC()))
+			break
+		}
+		return u.structure(v)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedType, v.Type())
+	}
+	return u.p.Err()
+}
+
+// pointer reads the pointer, or the array or slice of pointers.
+func (u *unmarshaler) pointer(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Uint64:
+		if u.pointerSize == 4 {
+			var x uint32
+			u.p.Read(&x)
+			v.SetUint(uint64(x))
+		} else {
+			var x uint64
+			u.p.Read(&x)
+			v.SetUint(x)
+		}
+		return u.p.Err()
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := u.pointer(v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Slice:
+		var n uint16
+		u.p.Read(&n)
+		if err := u.p.Err(); err != nil {
+			return err
+		}
+		return u.slice(v, int(n), true)
+	default:
+		return fmt.Errorf("%w: %s pointer", ErrUnsupportedType, v.Type())
+	}
+}
+
+// slice reads n elements into the slice v. The number is checked against
+// the remaining payload before the slice is allocated: an element takes at
+// least one byte.
+func (u *unmarshaler) slice(v reflect.Value, n int, pointer bool) error {
+	m := minSize(v.Type().Elem())
+	if pointer {
+		m = u.pointerSize
+	}
+	if m == 0 {
+		m = 1
+	}
+	if n > u.p.Len()/m {
+		return io.ErrUnexpectedEOF
+	}
+	s := reflect.MakeSlice(v.Type(), n, n)
+	for i := 0; i < n; i++ {
+		var err error
+		if pointer {
+			err = u.pointer(s.Index(i))
+		} else {
+			err = u.value(s.Index(i))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	v.Set(s)
+	return nil
+}
+
+// minSize returns the minimal number of bytes a value of the type takes.
+func minSize(t reflect.Type) int {
+	switch {
+	case fixedSize(t):
+		return int(t.Size())
+	case t == timeType:
+		return 8
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return 4
+	case reflect.String, reflect.Slice:
+		return 2
+	case reflect.Array:
+		return t.Len() * minSize(t.Elem())
+	case reflect.Struct:
+		var n int
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := parseFieldTag(f.Tag.Get("nettrace"))
+			switch {
+			case tag.optional:
+				return n
+			case f.Name == "_":
+				n += int(f.Type.Size())
+			case tag.ignore || f.PkgPath != "" || tag.count != "":
+			case tag.pointer:
+				n += minPointerSize(f.Type)
+			default:
+				n += minSize(f.Type)
+			}
+		}
+		return n
+	default:
+		return 0
+	}
+}
+
+// minPointerSize returns the minimal number of bytes the pointer, or the
+// array or slice of pointers takes: pointers of 32-bit processes.
+func minPointerSize(t reflect.Type) int {
+	switch t.Kind() {
+	case reflect.Array:
+		return t.Len() * 4
+	case reflect.Slice:
+		return 2
+	default:
+		return 4
+	}
+}
+
+// fixedSize reports whether values of the type can be read as is.
+func fixedSize(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Array:
+		return fixedSize(t.Elem())
+	default:
+		return false
+	}
+}
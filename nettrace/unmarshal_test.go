@@ -0,0 +1,96 @@
+package nettrace_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+)
+
+type testEvent struct {
+	ID       [16]byte
+	Enabled  bool
+	Name     string
+	_        [2]byte
+	Count    uint8
+	Values   []int32 `nettrace:"count=Count"`
+	Items    []testItem
+	internal int
+	Ignored  string `nettrace:"-"`
+	// Added in the next event version.
+	Extra  int64 `nettrace:"optional"`
+	Labels [2]string
+}
+
+type testItem struct {
+	Key   string
+	Value float64
+}
+
+func TestUnmarshal(t *testing.T) {
+	var b rawBuilder
+	b.write([16]byte{1, 2}, int32(1), utf16NTS("test"), [2]byte{}, uint8(2), []int32{3, 4})
+	b.write(uint16(1), utf16NTS("k"), 0.5)
+
+	expected := testEvent{
+		ID:      [16]byte{1, 2},
+		Enabled: true,
+		Name:    "test",
+		Count:   2,
+		Values:  []int32{3, 4},
+		Items:   []testItem{{Key: "k", Value: 0.5}},
+	}
+	var e testEvent
+	requireNoError(t, nettrace.Unmarshal(&nettrace.Blob{Payload: bytes.NewBuffer(b.Bytes())}, &e))
+	if !reflect.DeepEqual(e, expected) {
+		t.Fatalf("unexpected event:\n%+v\n%+v", e, expected)
+	}
+
+	b.write(int64(-1), utf16NTS("a"), utf16NTS("b"))
+	expected.Extra = -1
+	expected.Labels = [2]string{"a", "b"}
+	e = testEvent{}
+	requireNoError(t, nettrace.Unmarshal(&nettrace.Blob{Payload: bytes.NewBuffer(b.Bytes())}, &e))
+	if !reflect.DeepEqual(e, expected) {
+		t.Fatalf("unexpected event:\n%+v\n%+v", e, expected)
+	}
+
+	// The payload ends in the middle of a required field.
+	err := nettrace.Unmarshal(&nettrace.Blob{Payload: bytes.NewBuffer(b.Bytes()[:30])}, &e)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestUnmarshalCounts(t *testing.T) {
+	type event struct {
+		Count uint32
+		Names []string `nettrace:"count=Count"`
+	}
+	var b rawBuilder
+	b.write(uint32(0xFFFFFFFF), utf16NTS("a"))
+	err := nettrace.Unmarshal(&nettrace.Blob{Payload: bytes.NewBuffer(b.Bytes())}, new(event))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+
+	var items rawBuilder
+	items.write(uint16(1000), utf16NTS("k"), 0.5)
+	err = nettrace.Unmarshal(&nettrace.Blob{Payload: bytes.NewBuffer(items.Bytes())}, new(struct{ Items []testItem }))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+
+	type large struct {
+		Count  uint64
+		Values []byte `nettrace:"count=Count"`
+	}
+	var l rawBuilder
+	l.write(uint64(1) << 63)
+	if err = nettrace.Unmarshal(&nettrace.Blob{Payload: bytes.NewBuffer(l.Bytes())}, new(large)); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,296 @@
+package nettrace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// NetTrace format version 6 drops FastSerialization object framing: the
+// header is followed by a sequence of blocks, each of which starts with
+// a 4 byte header: the lower 24 bits specify the block size (not counting
+// the header), the upper 8 bits specify the block kind.
+//
+// Event blobs refer to threads by index, which is defined in ThreadBlock,
+// and to label lists (activity IDs and other attributes) by LabelListID,
+// which is defined in LabelListBlock.
+//
+// The support is experimental: it has not been verified with traces written
+// by a runtime. StackBlock is assumed to keep the version 5 layout.
+const netTraceV6 = 6
+
+type netTraceV6Header struct {
+	NetTraceMagic [8]byte
+	// Reserved is always zero: in earlier versions this is the length
+	// of the FastSerialization magic string.
+	Reserved     int32
+	MajorVersion uint32
+	MinorVersion uint32
+}
+
+type blockKind byte
+
+const (
+	blockKindEndOfStream blockKind = iota
+	blockKindTrace
+	blockKindEvent
+	blockKindMetadata
+	blockKindSequencePoint
+	blockKindStack
+	blockKindThread
+	blockKindRemoveThread
+	blockKindLabelList
+)
+
+// Object types of format version 6 blocks that have no counterparts
+// in the earlier versions.
+const (
+	ObjectTypeThreadBlock       ObjectType = "ThreadBlock"
+	ObjectTypeRemoveThreadBlock ObjectType = "RemoveThreadBlock"
+	ObjectTypeLabelListBlock    ObjectType = "LabelListBlock"
+)
+
+var blockObjectTypes = map[blockKind]ObjectType{
+	blockKindTrace:         ObjectTypeTrace,
+	blockKindEvent:         ObjectTypeEventBlock,
+	blockKindMetadata:      ObjectTypeMetadataBlock,
+	blockKindSequencePoint: ObjectTypeSPBlock,
+	blockKindStack:         ObjectTypeStackBlock,
+	blockKindThread:        ObjectTypeThreadBlock,
+	blockKindRemoveThread:  ObjectTypeRemoveThreadBlock,
+	blockKindLabelList:     ObjectTypeLabelListBlock,
+}
+
+// readBlock reads the next format version 6 block to o. Blocks are not
+// versioned, therefore the object version is the format version.
+func (d *Decoder) readBlock(o *Object) error {
+	var header uint32
+	if err := d.read(&header); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	size := header & 0xFFFFFF
+	kind := blockKind(header >> 24)
+	if kind == blockKindEndOfStream {
+		return io.EOF
+	}
+	t, ok := blockObjectTypes[kind]
+	if !ok {
+		return fmt.Errorf("%w: block kind %d", ErrInvalidObjectType, kind)
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	*o = Object{
+		Type:                 t,
+		Version:              d.version,
+		MinimumReaderVersion: d.version,
+		Payload:              bytes.NewBuffer(b),
+	}
+	return nil
+}
+
+// Well-known trace header keys.
+const (
+	TraceKeyProcessID               = "ProcessId"
+	TraceKeyProcessorCount          = "ProcessorCount"
+	TraceKeyExpectedCPUSamplingRate = "ExpectedCPUSamplingRate"
+)
+
+func (d *Decoder) readTraceBlock() (*Trace, error) {
+	var o Object
+	if err := d.readBlock(&o); err != nil {
+		return nil, err
+	}
+	if o.Type != ObjectTypeTrace {
+		return nil, fmt.Errorf("%w: %s", ErrUnexpectedObjectType, o.Type)
+	}
+	var t Trace
+	p := Parser{Buffer: o.Payload}
+	for _, f := range t.fields()[:11] {
+		// Start time through PointerSize.
+		p.Read(f)
+	}
+	var n int32
+	p.Read(&n)
+	t.KeyValues = make(map[string]string, n)
+	for i := int32(0); i < n && p.Err() == nil; i++ {
+		k := p.UTF8()
+		t.KeyValues[k] = p.UTF8()
+	}
+	if err := p.Err(); err != nil {
+		return nil, fmt.Errorf("invalid trace block: %w", err)
+	}
+	for k, f := range map[string]*int32{
+		TraceKeyProcessID:               &t.ProcessID,
+		TraceKeyProcessorCount:          &t.NumberOfProcessors,
+		TraceKeyExpectedCPUSamplingRate: &t.ExpectedCPUSamplingRate,
+	} {
+		if v, ok := t.KeyValues[k]; ok {
+			x, _ := strconv.ParseInt(v, 10, 32)
+			*f = int32(x)
+		}
+	}
+	return &t, nil
+}
+
+// ThreadBlock describes threads referred by events (format version 6).
+type ThreadBlock struct {
+	Threads []ThreadInfo
+}
+
+type ThreadInfo struct {
+	// Index of the thread, that is used as ThreadID in event headers.
+	Index     int64
+	Name      string
+	ProcessID int64
+	ThreadID  int64
+	KeyValues map[string]string
+}
+
+// Thread info optional field kinds.
+const (
+	threadFieldEnd byte = iota
+	threadFieldName
+	threadFieldProcessID
+	threadFieldThreadID
+	threadFieldKeyValue
+)
+
+func ThreadBlockFromObject(o Object) (*ThreadBlock, error) {
+	var b ThreadBlock
+	p := Parser{Buffer: o.Payload}
+	for p.Len() > 0 && p.Err() == nil {
+		t := ThreadInfo{Index: int64(p.Uvarint())}
+		for p.Err() == nil {
+			var kind byte
+			p.Read(&kind)
+			if kind == threadFieldEnd {
+				break
+			}
+			switch kind {
+			case threadFieldName:
+				t.Name = p.UTF8()
+			case threadFieldProcessID:
+				t.ProcessID = int64(p.Uvarint())
+			case threadFieldThreadID:
+				t.ThreadID = int64(p.Uvarint())
+			case threadFieldKeyValue:
+				if t.KeyValues == nil {
+					t.KeyValues = make(map[string]string)
+				}
+				k := p.UTF8()
+				t.KeyValues[k] = p.UTF8()
+			default:
+				return nil, fmt.Errorf("unknown thread field kind: %d", kind)
+			}
+		}
+		b.Threads = append(b.Threads, t)
+	}
+	return &b, p.Err()
+}
+
+// RemoveThreadBlock lists threads that are not referred anymore. Thread
+// indexes may be reused by ThreadBlocks that follow.
+type RemoveThreadBlock struct {
+	// ThreadID member of the threads is the thread index.
+	Threads []Thread
+}
+
+func RemoveThreadBlockFromObject(o Object) (*RemoveThreadBlock, error) {
+	var b RemoveThreadBlock
+	p := Parser{Buffer: o.Payload}
+	for p.Len() > 0 && p.Err() == nil {
+		b.Threads = append(b.Threads, Thread{
+			ThreadID:       int64(p.Uvarint()),
+			SequenceNumber: int32(p.Uvarint()),
+		})
+	}
+	return &b, p.Err()
+}
+
+// LabelListBlock defines label lists that events refer to by LabelListID.
+type LabelListBlock struct {
+	LabelLists []LabelList
+}
+
+// LabelList is a set of attributes attached to an event.
+type LabelList struct {
+	ID                int32
+	TraceID           [16]byte
+	SpanID            uint64
+	OpaqueID          uint64
+	ActivityID        [16]byte
+	RelatedActivityID [16]byte
+	// Labels contains name/value labels. Values are either strings,
+	// or int64 numbers.
+	Labels []Label
+}
+
+type Label struct {
+	Name  string
+	Value interface{}
+}
+
+// Label kinds. The high bit of the kind byte is set for the last label
+// in the list.
+const (
+	labelKindTraceID byte = iota + 1
+	labelKindSpanID
+	labelKindOpaqueID
+	labelKindActivityID
+	labelKindRelatedActivityID
+	labelKindNameValueString
+	labelKindNameValueVarInt
+
+	labelLast = 0x80
+)
+
+func LabelListBlockFromObject(o Object) (*LabelListBlock, error) {
+	var b LabelListBlock
+	var first, count uint32
+	p := Parser{Buffer: o.Payload}
+	p.Read(&first)
+	p.Read(&count)
+	for i := uint32(0); i < count && p.Err() == nil; i++ {
+		l := LabelList{ID: int32(first + i)}
+		for p.Err() == nil {
+			var kind byte
+			p.Read(&kind)
+			switch kind &^ labelLast {
+			case labelKindTraceID:
+				p.Read(&l.TraceID)
+			case labelKindSpanID:
+				p.Read(&l.SpanID)
+			case labelKindOpaqueID:
+				p.Read(&l.OpaqueID)
+			case labelKindActivityID:
+				p.Read(&l.ActivityID)
+			case labelKindRelatedActivityID:
+				p.Read(&l.RelatedActivityID)
+			case labelKindNameValueString:
+				name := p.UTF8()
+				l.Labels = append(l.Labels, Label{Name: name, Value: p.UTF8()})
+			case labelKindNameValueVarInt:
+				name := p.UTF8()
+				v, err := binary.ReadVarint(p)
+				if err != nil {
+					return nil, err
+				}
+				l.Labels = append(l.Labels, Label{Name: name, Value: v})
+			default:
+				return nil, fmt.Errorf("unknown label kind: %d", kind)
+			}
+			if kind&labelLast != 0 {
+				break
+			}
+		}
+		b.LabelLists = append(b.LabelLists, l)
+	}
+	return &b, p.Err()
+}
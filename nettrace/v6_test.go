@@ -0,0 +1,204 @@
+package nettrace_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+	"github.com/pyroscope-io/dotnetdiag/nettrace/typecode"
+)
+
+func (b *rawBuilder) uvarint(x uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	b.Write(tmp[:binary.PutUvarint(tmp[:], x)])
+}
+
+func (b *rawBuilder) utf8(s string) {
+	b.uvarint(uint64(len(s)))
+	b.WriteString(s)
+}
+
+func (b *rawBuilder) block(kind byte, content []byte) {
+	b.write(uint32(len(content)) | uint32(kind)<<24)
+	b.Write(content)
+}
+
+// blobBlock returns event or metadata block content of compressed blobs.
+func blobBlock(blobs ...[]byte) []byte {
+	var b rawBuilder
+	// Header size, flags (compressed), min and max timestamps.
+	b.write(int16(20), int16(1), int64(0), int64(0))
+	for _, x := range blobs {
+		b.Write(x)
+	}
+	return b.Bytes()
+}
+
+func netTraceV6Stream() []byte {
+	var b rawBuilder
+	b.write([]byte("Nettrace"), int32(0), uint32(6), uint32(0))
+
+	var trace rawBuilder
+	trace.write([8]int16{2025, 11, 2, 4}, int64(1000), int64(1000000), int32(8), int32(2))
+	trace.utf8("ProcessId")
+	trace.utf8("42")
+	trace.utf8("HostMachineName")
+	trace.utf8("test")
+	b.block(1, trace.Bytes())
+
+	var md rawBuilder
+	md.uvarint(1)
+	md.utf8("Test-Provider")
+	md.uvarint(7)
+	md.utf8("TestEvent")
+	var field rawBuilder
+	field.utf8("Value")
+	field.write(typecode.Int32)
+	md.write(int32(1), int32(field.Len()+4), field.Bytes())
+	// Opcode, level.
+	md.write(byte(1), byte(10), byte(7), byte(4))
+	var mdBlob rawBuilder
+	mdBlob.write(byte(0x80))
+	mdBlob.uvarint(0)
+	mdBlob.uvarint(uint64(md.Len()))
+	mdBlob.Write(md.Bytes())
+	b.block(3, blobBlock(mdBlob.Bytes()))
+
+	var threads rawBuilder
+	threads.uvarint(3)
+	threads.write(byte(1))
+	threads.utf8("worker")
+	threads.write(byte(3))
+	threads.uvarint(1234)
+	threads.write(byte(0))
+	b.block(6, threads.Bytes())
+
+	var labels rawBuilder
+	labels.write(uint32(1), uint32(1), byte(2), uint64(99), byte(6|0x80))
+	labels.utf8("http.route")
+	labels.utf8("/api")
+	b.block(8, labels.Bytes())
+
+	var stacks rawBuilder
+	stacks.write(int32(1), int32(1), int32(16), uint64(0x10), uint64(0x20))
+	b.block(5, stacks.Bytes())
+
+	var eventBlob rawBuilder
+	// Metadata ID, thread, stack, label list, payload size.
+	eventBlob.write(byte(1 | 4 | 8 | 16 | 128))
+	eventBlob.uvarint(1)
+	eventBlob.uvarint(3)
+	eventBlob.uvarint(1)
+	eventBlob.uvarint(1500)
+	eventBlob.uvarint(1)
+	eventBlob.uvarint(4)
+	eventBlob.write(int32(5))
+	b.block(2, blobBlock(eventBlob.Bytes()))
+
+	var sp rawBuilder
+	sp.write(int64(1500), int32(1))
+	sp.uvarint(3)
+	sp.uvarint(1)
+	b.block(4, sp.Bytes())
+
+	var remove rawBuilder
+	remove.uvarint(3)
+	remove.uvarint(1)
+	b.block(7, remove.Bytes())
+	b.block(0, nil)
+	return b.Bytes()
+}
+
+func TestNetTraceV6Decoding(t *testing.T) {
+	stream := nettrace.NewStream(bytes.NewReader(netTraceV6Stream()))
+	tr, err := stream.Open()
+	requireNoError(t, err)
+	if tr.ProcessID != 42 || tr.PointerSize != 8 || tr.KeyValues["HostMachineName"] != "test" {
+		t.Fatalf("unexpected trace: %+v", tr)
+	}
+
+	var (
+		metadata  *nettrace.Metadata
+		thread    nettrace.ThreadInfo
+		labelList nettrace.LabelList
+		event     nettrace.BlobHeader
+		stackIDs  int
+		removed   int
+		sps       int
+	)
+	stream.MetadataHandler = func(md *nettrace.Metadata) error {
+		metadata = md
+		return nil
+	}
+	stream.ThreadBlockHandler = func(b *nettrace.ThreadBlock) error {
+		thread = b.Threads[0]
+		return nil
+	}
+	stream.LabelListBlockHandler = func(b *nettrace.LabelListBlock) error {
+		labelList = b.LabelLists[0]
+		return nil
+	}
+	stream.StackBlockHandler = func(b *nettrace.StackBlock) error {
+		stackIDs += len(b.Stacks)
+		return nil
+	}
+	stream.EventHandler = func(e *nettrace.Blob) error {
+		event = e.Header
+		return nil
+	}
+	stream.SequencePointBlockHandler = func(b *nettrace.SequencePointBlock) error {
+		sps += len(b.Threads)
+		return nil
+	}
+	stream.RemoveThreadBlockHandler = func(b *nettrace.RemoveThreadBlock) error {
+		removed += len(b.Threads)
+		return nil
+	}
+	for {
+		err = stream.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		requireNoError(t, err)
+	}
+
+	if metadata == nil || metadata.Header.ProviderName != "Test-Provider" || metadata.Header.EventName != "TestEvent" ||
+		metadata.Header.Opcode != 10 || metadata.Header.Level != 4 ||
+		len(metadata.Payload.Fields) != 1 || metadata.Payload.Fields[0].Name != "Value" {
+		t.Fatalf("unexpected metadata: %+v", metadata)
+	}
+	if thread.Index != 3 || thread.Name != "worker" || thread.ThreadID != 1234 {
+		t.Fatalf("unexpected thread: %+v", thread)
+	}
+	if labelList.ID != 1 || labelList.SpanID != 99 || len(labelList.Labels) != 1 || labelList.Labels[0].Value != "/api" {
+		t.Fatalf("unexpected label list: %+v", labelList)
+	}
+	if event.MetadataID != 1 || event.ThreadID != 3 || event.StackID != 1 || event.LabelListID != 1 ||
+		event.TimeStamp != 1500 || event.PayloadSize != 4 {
+		t.Fatalf("unexpected event header: %+v", event)
+	}
+	if stackIDs != 1 || sps != 1 || removed != 1 {
+		t.Fatalf("stacks: %d, sequence point threads: %d, removed threads: %d", stackIDs, sps, removed)
+	}
+}
+
+func TestNetTraceV6NotEncoded(t *testing.T) {
+	err := nettrace.Rewrite(io.Discard, bytes.NewReader(netTraceV6Stream()))
+	if !errors.Is(err, nettrace.ErrUnsupportedFormatVersion) {
+		t.Fatalf("expected ErrUnsupportedFormatVersion, got %v", err)
+	}
+
+	dec := nettrace.NewDecoder(bytes.NewReader(netTraceV6Stream()))
+	tr, err := dec.OpenTrace()
+	requireNoError(t, err)
+	var o nettrace.Object
+	requireNoError(t, dec.Decode(&o))
+	enc := nettrace.NewEncoder(io.Discard)
+	requireNoError(t, enc.EncodeTrace(tr))
+	if err = enc.EncodeObject(o); !errors.Is(err, nettrace.ErrUnsupportedFormatVersion) {
+		t.Fatalf("expected ErrUnsupportedFormatVersion, got %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package nettrace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// Object versions written by runtimes that produce NetTrace format version 4.
+const (
+	traceObjectVersion = 4
+	blockObjectVersion = 2
+)
+
+// objectWriter serializes NetTrace objects to the underlying writer. The
+// writer tracks the stream offset, which is required to align block
+// payloads: the padding depends on the position in the output stream and
+// therefore raw objects can not be copied byte by byte.
+//
+// The first error is sticky, and all subsequent writes are no-op.
+type objectWriter struct {
+	w      io.Writer
+	offset uint64
+	err    error
+}
+
+func (w *objectWriter) write(b []byte) {
+	if w.err != nil {
+		return
+	}
+	n, err := w.w.Write(b)
+	w.offset += uint64(n)
+	w.err = err
+}
+
+func (w *objectWriter) writeValue(v interface{}) {
+	if w.err != nil {
+		return
+	}
+	b := new(bytes.Buffer)
+	_ = binary.Write(b, binary.LittleEndian, v)
+	w.write(b.Bytes())
+}
+
+func (w *objectWriter) writeHeader() {
+	w.writeValue(netTraceHeader{
+		NetTraceMagic:          netTraceMagic,
+		Len:                    int32(len(fastSerializationMagic)),
+		FastSerializationMagic: fastSerializationMagic,
+	})
+}
+
+func (w *objectWriter) writeTrace(t *Trace) {
+	w.writeObjectHeader(ObjectTypeTrace, traceObjectVersion, traceObjectVersion)
+	for _, f := range t.fields() {
+		w.writeValue(f)
+	}
+	w.writeValue(EndObject)
+}
+
+// writeObject writes a block object o. The object payload is not consumed.
+func (w *objectWriter) writeObject(o Object) {
+	w.writeObjectHeader(o.Type, o.Version, o.MinimumReaderVersion)
+	w.writeBlock(o.Payload.Bytes())
+	w.writeValue(EndObject)
+}
+
+func (w *objectWriter) writeObjectHeader(t ObjectType, version, minReaderVersion int32) {
+	w.writeValue(objectHeader{
+		Tags:                 objectHeaderTags,
+		Version:              version,
+		MinimumReaderVersion: minReaderVersion,
+		TypeNameLen:          int32(len(t)),
+	})
+	w.write([]byte(t))
+	w.writeValue(EndObject)
+}
+
+func (w *objectWriter) writeBlock(payload []byte) {
+	w.writeValue(int32(len(payload)))
+	if padLen := w.offset % 4; padLen != 0 {
+		w.write(make([]byte, 4-padLen))
+	}
+	w.write(payload)
+}
+
+// writeEnd terminates the stream with NullReference tag.
+func (w *objectWriter) writeEnd() {
+	w.writeValue(NullReference)
+}
+
+// copyObject returns a copy of o that does not share the payload buffer.
+func copyObject(o Object) Object {
+	c := o
+	c.Payload = bytes.NewBuffer(append([]byte(nil), o.Payload.Bytes()...))
+	return c
+}
@@ -0,0 +1,69 @@
+// Package observe provides adapters for dotnetdiag and nettrace observers.
+package observe
+
+import (
+	"time"
+
+	"github.com/pyroscope-io/dotnetdiag"
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+)
+
+// Logger is a structured logger: args are key-value pairs.
+// *slog.Logger satisfies the interface.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// LogObserver writes notifications to the logger. Objects decoded and
+// sessions read are logged at debug level, events are only logged if
+// the handler takes longer than the threshold.
+type LogObserver struct {
+	Logger Logger
+	// SlowEventThreshold specifies the handler duration upon exceeding
+	// which a warning is logged. By default, 100ms.
+	SlowEventThreshold time.Duration
+}
+
+var (
+	_ dotnetdiag.Observer = (*LogObserver)(nil)
+	_ nettrace.Observer   = (*LogObserver)(nil)
+)
+
+const defaultSlowEventThreshold = 100 * time.Millisecond
+
+// NewLogObserver creates a new LogObserver with the default parameters.
+func NewLogObserver(l Logger) *LogObserver {
+	return &LogObserver{Logger: l, SlowEventThreshold: defaultSlowEventThreshold}
+}
+
+func (o *LogObserver) SessionStarted(sessionID uint64) {
+	o.Logger.Debug("session started", "session_id", sessionID)
+}
+
+func (o *LogObserver) SessionStopped(sessionID uint64) {
+	o.Logger.Debug("session stopped", "session_id", sessionID)
+}
+
+func (o *LogObserver) SessionRead(sessionID uint64, n int) {
+	o.Logger.Debug("session read", "session_id", sessionID, "bytes", n)
+}
+
+func (o *LogObserver) ObjectDecoded(t nettrace.ObjectType, n int) {
+	o.Logger.Debug("object decoded", "type", string(t), "bytes", n)
+}
+
+func (o *LogObserver) EventHandled(provider string, eventID int32, _ time.Time, d time.Duration) {
+	threshold := o.SlowEventThreshold
+	if threshold <= 0 {
+		threshold = defaultSlowEventThreshold
+	}
+	if d >= threshold {
+		o.Logger.Warn("slow event handler", "provider", provider, "event_id", eventID, "duration", d)
+	}
+}
+
+func (o *LogObserver) Error(err error) {
+	o.Logger.Error("diagnostics error", "error", err)
+}
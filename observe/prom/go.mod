@@ -0,0 +1,10 @@
+module github.com/pyroscope-io/dotnetdiag/observe/prom
+
+go 1.16
+
+require (
+	github.com/prometheus/client_golang v1.11.0
+	github.com/pyroscope-io/dotnetdiag v0.0.0
+)
+
+replace github.com/pyroscope-io/dotnetdiag => ../..
@@ -0,0 +1,156 @@
+// Package prom provides Prometheus metrics for dotnetdiag and nettrace
+// observers.
+package prom
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pyroscope-io/dotnetdiag"
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+)
+
+// Observer exports notifications as Prometheus metrics.
+type Observer struct {
+	sessions       prometheus.Gauge
+	sessionBytes   prometheus.Counter
+	objects        *prometheus.CounterVec
+	objectBytes    *prometheus.CounterVec
+	events         *prometheus.CounterVec
+	eventLag       prometheus.Gauge
+	handlerLatency prometheus.Histogram
+	errors         prometheus.Counter
+	providers      map[string]struct{}
+}
+
+// otherProvider is the provider label value of events which provider
+// is not listed explicitly.
+const otherProvider = "other"
+
+// Option configures Observer.
+type Option func(*Observer)
+
+// WithProviders specifies providers which events are counted under their
+// own provider label; events of other providers are counted under "other".
+// EventSource names are arbitrary, therefore the label values are limited
+// to bound the metric cardinality. By default, the providers defined in
+// dotnetdiag package are used.
+func WithProviders(names ...string) Option {
+	return func(o *Observer) {
+		o.providers = make(map[string]struct{}, len(names))
+		for _, name := range names {
+			o.providers[name] = struct{}{}
+		}
+	}
+}
+
+var defaultProviders = []string{
+	dotnetdiag.ProviderRuntime,
+	dotnetdiag.ProviderRuntimeRundown,
+	dotnetdiag.ProviderRuntimePrivate,
+	dotnetdiag.ProviderSampleProfiler,
+	dotnetdiag.ProviderEventPipe,
+	dotnetdiag.ProviderSystemRuntime,
+	dotnetdiag.ProviderDiagnosticSource,
+	dotnetdiag.ProviderTPL,
+	dotnetdiag.ProviderHTTP,
+	dotnetdiag.ProviderSockets,
+	dotnetdiag.ProviderNameResolution,
+	dotnetdiag.ProviderAspNetCore,
+	dotnetdiag.ProviderLogging,
+}
+
+var (
+	_ dotnetdiag.Observer = (*Observer)(nil)
+	_ nettrace.Observer   = (*Observer)(nil)
+)
+
+// NewObserver creates a new Observer and registers its metrics
+// with the registerer given.
+func NewObserver(reg prometheus.Registerer, options ...Option) (*Observer, error) {
+	o := Observer{
+		sessions: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "dotnetdiag",
+			Name:      "sessions_active",
+			Help:      "Number of active EventPipe sessions.",
+		}),
+		sessionBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "dotnetdiag",
+			Name:      "session_read_bytes_total",
+			Help:      "Total number of bytes read from EventPipe sessions.",
+		}),
+		objects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "dotnetdiag",
+			Name:      "nettrace_objects_total",
+			Help:      "Total number of NetTrace objects decoded.",
+		}, []string{"type"}),
+		objectBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "dotnetdiag",
+			Name:      "nettrace_decoded_bytes_total",
+			Help:      "Total number of NetTrace bytes decoded.",
+		}, []string{"type"}),
+		events: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "dotnetdiag",
+			Name:      "nettrace_events_total",
+			Help:      "Total number of events handled.",
+		}, []string{"provider"}),
+		eventLag: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "dotnetdiag",
+			Name:      "nettrace_event_lag_seconds",
+			Help:      "Difference between the time the latest event was handled at and its timestamp.",
+		}),
+		handlerLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "dotnetdiag",
+			Name:      "nettrace_event_handler_duration_seconds",
+			Help:      "Event handler latency.",
+			Buckets:   prometheus.ExponentialBuckets(1e-6, 10, 7),
+		}),
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "dotnetdiag",
+			Name:      "errors_total",
+			Help:      "Total number of errors.",
+		}),
+	}
+	WithProviders(defaultProviders...)(&o)
+	for _, option := range options {
+		option(&o)
+	}
+	for _, c := range []prometheus.Collector{
+		o.sessions,
+		o.sessionBytes,
+		o.objects,
+		o.objectBytes,
+		o.events,
+		o.eventLag,
+		o.handlerLatency,
+		o.errors,
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return &o, nil
+}
+
+func (o *Observer) SessionStarted(uint64) { o.sessions.Inc() }
+
+func (o *Observer) SessionStopped(uint64) { o.sessions.Dec() }
+
+func (o *Observer) SessionRead(_ uint64, n int) { o.sessionBytes.Add(float64(n)) }
+
+func (o *Observer) ObjectDecoded(t nettrace.ObjectType, n int) {
+	o.objects.WithLabelValues(string(t)).Inc()
+	o.objectBytes.WithLabelValues(string(t)).Add(float64(n))
+}
+
+func (o *Observer) EventHandled(provider string, _ int32, timestamp time.Time, d time.Duration) {
+	if _, ok := o.providers[provider]; !ok {
+		provider = otherProvider
+	}
+	o.events.WithLabelValues(provider).Inc()
+	o.eventLag.Set(time.Since(timestamp).Seconds())
+	o.handlerLatency.Observe(d.Seconds())
+}
+
+func (o *Observer) Error(error) { o.errors.Inc() }
@@ -0,0 +1,32 @@
+package prom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/pyroscope-io/dotnetdiag"
+)
+
+func TestObserverEvents(t *testing.T) {
+	o, err := NewObserver(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	o.EventHandled(dotnetdiag.ProviderRuntime, 1, now.Add(-time.Minute), time.Millisecond)
+	o.EventHandled("Custom.Source.1", 1, now, time.Millisecond)
+	o.EventHandled("Custom.Source.2", 1, now, time.Millisecond)
+
+	if n := testutil.CollectAndCount(o.events); n != 2 {
+		t.Fatalf("expected 2 provider labels, got %d", n)
+	}
+	if v := testutil.ToFloat64(o.events.WithLabelValues(otherProvider)); v != 2 {
+		t.Fatalf("expected 2 events of other providers, got %v", v)
+	}
+	if v := testutil.ToFloat64(o.eventLag); v < 0 || v >= 60 {
+		t.Fatalf("expected the lag of the latest event, got %v", v)
+	}
+}
@@ -0,0 +1,27 @@
+package dotnetdiag
+
+// Observer receives notifications about the client activity. Methods are
+// called synchronously, therefore implementations should not block.
+type Observer interface {
+	// SessionStarted is called when a new EventPipe session is created.
+	SessionStarted(sessionID uint64)
+	// SessionStopped is called when the session is stopped.
+	SessionStopped(sessionID uint64)
+	// SessionRead is called when n bytes are read from the session stream.
+	SessionRead(sessionID uint64, n int)
+	// Error is called when a command or a session read fails.
+	// io.EOF is not reported.
+	Error(err error)
+}
+
+// NopObserver implements Observer interface and does nothing. It can be
+// embedded by an observer that is only interested in some notifications.
+type NopObserver struct{}
+
+func (NopObserver) SessionStarted(uint64) {}
+
+func (NopObserver) SessionStopped(uint64) {}
+
+func (NopObserver) SessionRead(uint64, int) {}
+
+func (NopObserver) Error(error) {}
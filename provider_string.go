@@ -0,0 +1,278 @@
+package dotnetdiag
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidProviderString = errors.New("invalid provider string")
+
+// Keyword names by provider, keys are lower case.
+var keywordNames = map[string]map[string]uint64{
+	strings.ToLower(ProviderRuntime): {
+		"gc":                            RuntimeKeywordGC,
+		"gchandle":                      RuntimeKeywordGCHandle,
+		"binder":                        RuntimeKeywordBinder,
+		"loader":                        RuntimeKeywordLoader,
+		"jit":                           RuntimeKeywordJit,
+		"ngen":                          RuntimeKeywordNGen,
+		"startenumeration":              RuntimeKeywordStartEnumeration,
+		"endenumeration":                RuntimeKeywordEndEnumeration,
+		"security":                      RuntimeKeywordSecurity,
+		"appdomainresourcemanagement":   RuntimeKeywordAppDomainResourceManagement,
+		"jittracing":                    RuntimeKeywordJitTracing,
+		"interop":                       RuntimeKeywordInterop,
+		"contention":                    RuntimeKeywordContention,
+		"exception":                     RuntimeKeywordException,
+		"threading":                     RuntimeKeywordThreading,
+		"jittedmethodiltonativemap":     RuntimeKeywordJittedMethodILToNativeMap,
+		"overrideandsuppressngenevents": RuntimeKeywordOverrideAndSuppressNGenEvents,
+		"type":                          RuntimeKeywordType,
+		"gcheapdump":                    RuntimeKeywordGCHeapDump,
+		"gcsampledobjectallocationhigh": RuntimeKeywordGCSampledObjectAllocationHigh,
+		"gcheapsurvivalandmovement":     RuntimeKeywordGCHeapSurvivalAndMovement,
+		"gcheapcollect":                 RuntimeKeywordGCHeapCollect,
+		"gcheapandtypenames":            RuntimeKeywordGCHeapAndTypeNames,
+		"gcsampledobjectallocationlow":  RuntimeKeywordGCSampledObjectAllocationLow,
+		"perftrack":                     RuntimeKeywordPerfTrack,
+		"stack":                         RuntimeKeywordStack,
+		"threadtransfer":                RuntimeKeywordThreadTransfer,
+		"debugger":                      RuntimeKeywordDebugger,
+		"monitoring":                    RuntimeKeywordMonitoring,
+		"codesymbols":                   RuntimeKeywordCodeSymbols,
+		"eventsource":                   RuntimeKeywordEventSource,
+		"compilation":                   RuntimeKeywordCompilation,
+		"compilationdiagnostic":         RuntimeKeywordCompilationDiagnostic,
+		"methoddiagnostic":              RuntimeKeywordMethodDiagnostic,
+		"typediagnostic":                RuntimeKeywordTypeDiagnostic,
+		"gcheapsnapshot":                RuntimeKeywordGCHeapSnapshot,
+		"default":                       RuntimeKeywordDefault,
+	},
+	strings.ToLower(ProviderRuntimeRundown): {
+		"loader":                        RundownKeywordLoader,
+		"jit":                           RundownKeywordJit,
+		"ngen":                          RundownKeywordNGen,
+		"start":                         RundownKeywordStart,
+		"end":                           RundownKeywordEnd,
+		"appdomainresourcemanagement":   RundownKeywordAppDomainResourceManagement,
+		"overrideandsuppressngenevents": RundownKeywordOverrideAndSuppressNGenEvents,
+		"perftrack":                     RundownKeywordPerfTrack,
+		"threadtransfer":                RundownKeywordThreadTransfer,
+		"compilation":                   RundownKeywordCompilation,
+		"default":                       RundownKeywordDefault,
+	},
+	strings.ToLower(ProviderDiagnosticSource): {
+		"messages":                    DiagnosticSourceKeywordMessages,
+		"events":                      DiagnosticSourceKeywordEvents,
+		"ignoreshortcutkeywords":      DiagnosticSourceKeywordIgnoreShortCutKeywords,
+		"aspnetcorehosting":           DiagnosticSourceKeywordAspNetCoreHosting,
+		"entityframeworkcorecommands": DiagnosticSourceKeywordEntityFrameworkCoreCommands,
+	},
+	strings.ToLower(ProviderTPL): {
+		"tasktransfer":         TPLKeywordTaskTransfer,
+		"tasks":                TPLKeywordTasks,
+		"parallel":             TPLKeywordParallel,
+		"asynccausalityops":    TPLKeywordAsyncCausalityOps,
+		"asynccausalityrel":    TPLKeywordAsyncCausalityRel,
+		"asynccausalitysync":   TPLKeywordAsyncCausalitySync,
+		"debug":                TPLKeywordDebug,
+		"tasksflowactivityids": TPLKeywordTasksFlowActivityIDs,
+	},
+}
+
+var levelNames = map[string]uint32{
+	"logalways":     LevelLogAlways,
+	"critical":      LevelCritical,
+	"error":         LevelError,
+	"warning":       LevelWarning,
+	"informational": LevelInformational,
+	"info":          LevelInformational,
+	"verbose":       LevelVerbose,
+}
+
+// ParseProviders parses comma-separated list of providers in the format
+// accepted by dotnet-trace --providers option.
+// See ParseProvider for details.
+func ParseProviders(s string) ([]ProviderConfig, error) {
+	var providers []ProviderConfig
+	for _, x := range splitUnquoted(s, ',', -1) {
+		if strings.TrimSpace(x) == "" {
+			continue
+		}
+		p, err := ParseProvider(x)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, p)
+	}
+	return providers, nil
+}
+
+// ParseProvider parses provider string in the following format:
+//
+//	Provider[:Keywords[:Level[:FilterData]]]
+//
+// Keywords is a hexadecimal number, or a list of keyword names separated
+// by '+' or '|' (names are only known for the providers listed in this
+// package). If keywords are omitted, or specified as '*', all keywords are
+// enabled.
+//
+// Level is a number or level name (Critical, Error, Warning, Informational,
+// Verbose); if omitted, Verbose level is used.
+//
+// FilterData is a list of key=value pairs separated by ';'. A value can be
+// enclosed in double quotes if it contains ';', '=', ',' or ':' characters.
+func ParseProvider(s string) (ProviderConfig, error) {
+	fields := splitUnquoted(strings.TrimSpace(s), ':', 4)
+	p := ProviderConfig{
+		ProviderName: fields[0],
+		Keywords:     KeywordsAll,
+		LogLevel:     LevelVerbose,
+	}
+	if p.ProviderName == "" {
+		return p, fmt.Errorf("%w: %q: provider name is empty", ErrInvalidProviderString, s)
+	}
+	var err error
+	if len(fields) > 1 && fields[1] != "" {
+		if p.Keywords, err = ParseKeywords(p.ProviderName, fields[1]); err != nil {
+			return p, err
+		}
+	}
+	if len(fields) > 2 && fields[2] != "" {
+		if p.LogLevel, err = ParseLevel(fields[2]); err != nil {
+			return p, err
+		}
+	}
+	if len(fields) > 3 {
+		if p.FilterData, err = ParseFilterData(fields[3]); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+// ParseKeywords parses keywords of the given provider. The value is either
+// a hexadecimal number, or a list of keyword names and hexadecimal numbers
+// separated by '+' or '|'.
+func ParseKeywords(provider, s string) (uint64, error) {
+	if s == "*" {
+		return KeywordsAll, nil
+	}
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		k, err := strconv.ParseUint(s[2:], 16, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: invalid keywords %q", ErrInvalidProviderString, s)
+		}
+		return k, nil
+	}
+	names := keywordNames[strings.ToLower(provider)]
+	var k uint64
+	for _, name := range strings.FieldsFunc(s, func(r rune) bool { return r == '+' || r == '|' }) {
+		name = strings.TrimSpace(name)
+		v, ok := names[strings.ToLower(name)]
+		if !ok {
+			// dotnet-trace accepts hexadecimal numbers without the prefix.
+			h, err := strconv.ParseUint(name, 16, 64)
+			if err != nil {
+				return 0, fmt.Errorf("%w: unknown keyword %q for provider %s", ErrInvalidProviderString, name, provider)
+			}
+			v = h
+		}
+		k |= v
+	}
+	return k, nil
+}
+
+// ParseLevel parses event level given as a number or name.
+func ParseLevel(s string) (uint32, error) {
+	if l, ok := levelNames[strings.ToLower(s)]; ok {
+		return l, nil
+	}
+	l, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || uint32(l) > LevelVerbose {
+		return 0, fmt.Errorf("%w: invalid level %q", ErrInvalidProviderString, s)
+	}
+	return uint32(l), nil
+}
+
+// ParseFilterData parses ';'-separated list of key=value pairs, and
+// returns the string encoded the way the runtime expects: quotes are
+// only kept for values that contain ';', '=', ',' or ':'.
+func ParseFilterData(s string) (string, error) {
+	var pairs []string
+	for _, x := range splitUnquoted(s, ';', -1) {
+		if x == "" {
+			continue
+		}
+		kv := splitUnquoted(x, '=', 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return "", fmt.Errorf("%w: invalid filter data %q", ErrInvalidProviderString, x)
+		}
+		v, err := unquote(kv[1])
+		if err != nil {
+			return "", err
+		}
+		pairs = append(pairs, formatArgument(kv[0], v))
+	}
+	return strings.Join(pairs, ";"), nil
+}
+
+// FormatProviders returns comma-separated provider strings that can be
+// parsed with ParseProviders.
+func FormatProviders(providers []ProviderConfig) string {
+	s := make([]string, len(providers))
+	for i, p := range providers {
+		s[i] = p.String()
+	}
+	return strings.Join(s, ",")
+}
+
+// String returns provider string in dotnet-trace format.
+func (p ProviderConfig) String() string {
+	s := fmt.Sprintf("%s:0x%X:%d", p.ProviderName, p.Keywords, p.LogLevel)
+	if p.FilterData != "" {
+		s += ":" + p.FilterData
+	}
+	return s
+}
+
+// formatArgument quotes values that contain separators of the filter data,
+// or of the provider string.
+func formatArgument(k, v string) string {
+	if strings.ContainsAny(v, ";=,:") {
+		return k + `="` + v + `"`
+	}
+	return k + "=" + v
+}
+
+func unquote(s string) (string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return s, nil
+	}
+	if len(s) < 2 || !strings.HasSuffix(s, `"`) {
+		return "", fmt.Errorf("%w: unterminated quoted value %s", ErrInvalidProviderString, s)
+	}
+	return s[1 : len(s)-1], nil
+}
+
+// splitUnquoted slices s into at most n substrings separated by sep,
+// which is ignored within double quotes. If n < 0, there is no limit.
+func splitUnquoted(s string, sep byte, n int) []string {
+	var parts []string
+	var quoted bool
+	start := 0
+	for i := 0; i < len(s) && n != len(parts)+1; i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
@@ -0,0 +1,98 @@
+package dotnetdiag
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseProviders(t *testing.T) {
+	for _, tc := range []struct {
+		input    string
+		expected []ProviderConfig
+	}{
+		{
+			input: "Microsoft-DotNETCore-SampleProfiler",
+			expected: []ProviderConfig{
+				{ProviderName: ProviderSampleProfiler, Keywords: KeywordsAll, LogLevel: LevelVerbose},
+			},
+		},
+		{
+			input: "Microsoft-Windows-DotNETRuntime:GC+Jit|Loader:Informational,System.Runtime:0x1:4:EventCounterIntervalSec=1",
+			expected: []ProviderConfig{
+				{
+					ProviderName: ProviderRuntime,
+					Keywords:     RuntimeKeywordGC | RuntimeKeywordJit | RuntimeKeywordLoader,
+					LogLevel:     LevelInformational,
+				},
+				{
+					ProviderName: ProviderSystemRuntime,
+					Keywords:     0x1,
+					LogLevel:     LevelInformational,
+					FilterData:   "EventCounterIntervalSec=1",
+				},
+			},
+		},
+		{
+			input: "Microsoft-Windows-DotNETRuntime:gc+10|Loader",
+			expected: []ProviderConfig{
+				{
+					ProviderName: ProviderRuntime,
+					Keywords:     RuntimeKeywordGC | RuntimeKeywordJit | RuntimeKeywordLoader,
+					LogLevel:     LevelVerbose,
+				},
+			},
+		},
+		{
+			input: `MyProvider:0x1:4:a="x,y";b="host:80",Other`,
+			expected: []ProviderConfig{
+				{
+					ProviderName: "MyProvider",
+					Keywords:     0x1,
+					LogLevel:     LevelInformational,
+					FilterData:   `a="x,y";b="host:80"`,
+				},
+				{ProviderName: "Other", Keywords: KeywordsAll, LogLevel: LevelVerbose},
+			},
+		},
+		{
+			input: `MyProvider:f0::a=1;b="x;y=z,w";c="plain"`,
+			expected: []ProviderConfig{
+				{
+					ProviderName: "MyProvider",
+					Keywords:     0xf0,
+					LogLevel:     LevelVerbose,
+					FilterData:   `a=1;b="x;y=z,w";c=plain`,
+				},
+			},
+		},
+	} {
+		actual, err := ParseProviders(tc.input)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.input, err)
+		}
+		if !reflect.DeepEqual(actual, tc.expected) {
+			t.Fatalf("%s: expected %+v, got %+v", tc.input, tc.expected, actual)
+		}
+		formatted, err := ParseProviders(FormatProviders(actual))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.input, err)
+		}
+		if !reflect.DeepEqual(formatted, tc.expected) {
+			t.Fatalf("%s: round trip mismatch: %+v", tc.input, formatted)
+		}
+	}
+}
+
+func TestParseProvidersErrors(t *testing.T) {
+	for _, input := range []string{
+		":0x1:4",
+		"Microsoft-Windows-DotNETRuntime:NoSuchKeyword",
+		"MyProvider:0x1:Loud",
+		"MyProvider:0x1:4:novalue",
+		`MyProvider:0x1:4:a="unterminated`,
+	} {
+		if _, err := ParseProviders(input); err == nil {
+			t.Fatalf("%s: expected error", input)
+		}
+	}
+}
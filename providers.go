@@ -0,0 +1,300 @@
+package dotnetdiag
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var ErrUnknownPreset = errors.New("unknown preset")
+
+// Well-known event providers.
+const (
+	ProviderRuntime          = "Microsoft-Windows-DotNETRuntime"
+	ProviderRuntimeRundown   = "Microsoft-Windows-DotNETRuntimeRundown"
+	ProviderRuntimePrivate   = "Microsoft-Windows-DotNETRuntimePrivate"
+	ProviderSampleProfiler   = "Microsoft-DotNETCore-SampleProfiler"
+	ProviderEventPipe        = "Microsoft-DotNETCore-EventPipe"
+	ProviderSystemRuntime    = "System.Runtime"
+	ProviderDiagnosticSource = "Microsoft-Diagnostics-DiagnosticSource"
+	ProviderTPL              = "System.Threading.Tasks.TplEventSource"
+	ProviderHTTP             = "System.Net.Http"
+	ProviderSockets          = "System.Net.Sockets"
+	ProviderNameResolution   = "System.Net.NameResolution"
+	ProviderAspNetCore       = "Microsoft.AspNetCore.Hosting"
+	ProviderLogging          = "Microsoft-Extensions-Logging"
+)
+
+// Event levels.
+// https://docs.microsoft.com/en-us/dotnet/api/system.diagnostics.tracing.eventlevel
+const (
+	LevelLogAlways uint32 = iota
+	LevelCritical
+	LevelError
+	LevelWarning
+	LevelInformational
+	LevelVerbose
+)
+
+// KeywordsAll enables all the events of a provider.
+const KeywordsAll uint64 = 0xFFFFFFFFFFFFFFFF
+
+// SampleProfilerKeywords enables Microsoft-DotNETCore-SampleProfiler provider.
+// The provider does not define keywords, the value is chosen to be compatible
+// with other tools.
+const SampleProfilerKeywords uint64 = 0x0000F00000000000
+
+// Microsoft-Windows-DotNETRuntime provider keywords.
+// https://docs.microsoft.com/en-us/dotnet/fundamentals/diagnostics/runtime-events
+const (
+	RuntimeKeywordGC                            uint64 = 0x1
+	RuntimeKeywordGCHandle                      uint64 = 0x2
+	RuntimeKeywordBinder                        uint64 = 0x4
+	RuntimeKeywordLoader                        uint64 = 0x8
+	RuntimeKeywordJit                           uint64 = 0x10
+	RuntimeKeywordNGen                          uint64 = 0x20
+	RuntimeKeywordStartEnumeration              uint64 = 0x40
+	RuntimeKeywordEndEnumeration                uint64 = 0x80
+	RuntimeKeywordSecurity                      uint64 = 0x400
+	RuntimeKeywordAppDomainResourceManagement   uint64 = 0x800
+	RuntimeKeywordJitTracing                    uint64 = 0x1000
+	RuntimeKeywordInterop                       uint64 = 0x2000
+	RuntimeKeywordContention                    uint64 = 0x4000
+	RuntimeKeywordException                     uint64 = 0x8000
+	RuntimeKeywordThreading                     uint64 = 0x10000
+	RuntimeKeywordJittedMethodILToNativeMap     uint64 = 0x20000
+	RuntimeKeywordOverrideAndSuppressNGenEvents uint64 = 0x40000
+	RuntimeKeywordType                          uint64 = 0x80000
+	RuntimeKeywordGCHeapDump                    uint64 = 0x100000
+	RuntimeKeywordGCSampledObjectAllocationHigh uint64 = 0x200000
+	RuntimeKeywordGCHeapSurvivalAndMovement     uint64 = 0x400000
+	RuntimeKeywordGCHeapCollect                 uint64 = 0x800000
+	RuntimeKeywordGCHeapAndTypeNames            uint64 = 0x1000000
+	RuntimeKeywordGCSampledObjectAllocationLow  uint64 = 0x2000000
+	RuntimeKeywordPerfTrack                     uint64 = 0x20000000
+	RuntimeKeywordStack                         uint64 = 0x40000000
+	RuntimeKeywordThreadTransfer                uint64 = 0x80000000
+	RuntimeKeywordDebugger                      uint64 = 0x100000000
+	RuntimeKeywordMonitoring                    uint64 = 0x200000000
+	RuntimeKeywordCodeSymbols                   uint64 = 0x400000000
+	RuntimeKeywordEventSource                   uint64 = 0x800000000
+	RuntimeKeywordCompilation                   uint64 = 0x1000000000
+	RuntimeKeywordCompilationDiagnostic         uint64 = 0x2000000000
+	RuntimeKeywordMethodDiagnostic              uint64 = 0x4000000000
+	RuntimeKeywordTypeDiagnostic                uint64 = 0x8000000000
+
+	// RuntimeKeywordGCHeapSnapshot enables events required to build
+	// a heap snapshot (gcdump).
+	RuntimeKeywordGCHeapSnapshot = RuntimeKeywordGC |
+		RuntimeKeywordGCHeapCollect |
+		RuntimeKeywordGCHeapDump |
+		RuntimeKeywordGCHeapAndTypeNames |
+		RuntimeKeywordType
+
+	// RuntimeKeywordDefault is the set of keywords enabled by
+	// dotnet-trace cpu-sampling profile.
+	RuntimeKeywordDefault = RuntimeKeywordGC |
+		RuntimeKeywordType |
+		RuntimeKeywordGCHeapSurvivalAndMovement |
+		RuntimeKeywordBinder |
+		RuntimeKeywordLoader |
+		RuntimeKeywordJit |
+		RuntimeKeywordNGen |
+		RuntimeKeywordOverrideAndSuppressNGenEvents |
+		RuntimeKeywordEndEnumeration |
+		RuntimeKeywordSecurity |
+		RuntimeKeywordAppDomainResourceManagement |
+		RuntimeKeywordException |
+		RuntimeKeywordThreading |
+		RuntimeKeywordContention |
+		RuntimeKeywordStack |
+		RuntimeKeywordJittedMethodILToNativeMap |
+		RuntimeKeywordThreadTransfer |
+		RuntimeKeywordGCHeapAndTypeNames |
+		RuntimeKeywordCodeSymbols |
+		RuntimeKeywordCompilation
+)
+
+// Microsoft-Windows-DotNETRuntimeRundown provider keywords.
+const (
+	RundownKeywordLoader                        uint64 = 0x8
+	RundownKeywordJit                           uint64 = 0x10
+	RundownKeywordNGen                          uint64 = 0x20
+	RundownKeywordStart                         uint64 = 0x40
+	RundownKeywordEnd                           uint64 = 0x100
+	RundownKeywordAppDomainResourceManagement   uint64 = 0x800
+	RundownKeywordOverrideAndSuppressNGenEvents uint64 = 0x40000
+	RundownKeywordPerfTrack                     uint64 = 0x20000000
+	RundownKeywordThreadTransfer                uint64 = 0x80000000
+	RundownKeywordCompilation                   uint64 = 0x1000000000
+
+	// RundownKeywordDefault is the set of keywords that is used by
+	// dotnet-trace to resolve method and module names.
+	RundownKeywordDefault = RundownKeywordLoader |
+		RundownKeywordJit |
+		RundownKeywordNGen |
+		RundownKeywordEnd |
+		RundownKeywordOverrideAndSuppressNGenEvents |
+		RundownKeywordThreadTransfer |
+		RundownKeywordCompilation
+)
+
+// Microsoft-Diagnostics-DiagnosticSource provider keywords.
+const (
+	DiagnosticSourceKeywordMessages                    uint64 = 0x1
+	DiagnosticSourceKeywordEvents                      uint64 = 0x2
+	DiagnosticSourceKeywordIgnoreShortCutKeywords      uint64 = 0x800
+	DiagnosticSourceKeywordAspNetCoreHosting           uint64 = 0x1000
+	DiagnosticSourceKeywordEntityFrameworkCoreCommands uint64 = 0x2000
+)
+
+// System.Threading.Tasks.TplEventSource provider keywords.
+const (
+	TPLKeywordTaskTransfer         uint64 = 0x1
+	TPLKeywordTasks                uint64 = 0x2
+	TPLKeywordParallel             uint64 = 0x4
+	TPLKeywordAsyncCausalityOps    uint64 = 0x8
+	TPLKeywordAsyncCausalityRel    uint64 = 0x10
+	TPLKeywordAsyncCausalitySync   uint64 = 0x20
+	TPLKeywordDebug                uint64 = 0x40
+	TPLKeywordTasksFlowActivityIDs uint64 = 0x80
+)
+
+// Collection presets.
+const (
+	PresetCPUSampling = "cpu-sampling"
+	PresetGCVerbose   = "gc-verbose"
+	PresetGCCollect   = "gc-collect"
+	PresetDatabase    = "database"
+	PresetHTTP        = "http"
+)
+
+var presets = map[string]func() []ProviderConfig{
+	PresetCPUSampling: CPUSamplingProviders,
+	PresetGCVerbose:   GCVerboseProviders,
+	PresetGCCollect:   GCCollectProviders,
+	PresetDatabase:    DatabaseProviders,
+	PresetHTTP:        HTTPProviders,
+}
+
+// Preset returns provider configuration of the named preset.
+func Preset(name string) ([]ProviderConfig, error) {
+	p, ok := presets[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownPreset, name)
+	}
+	return p(), nil
+}
+
+// Presets returns sorted list of available preset names.
+func Presets() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CPUSamplingProviders enables CPU sampling and the runtime events required
+// to resolve managed code symbols.
+func CPUSamplingProviders() []ProviderConfig {
+	return []ProviderConfig{
+		{
+			ProviderName: ProviderSampleProfiler,
+			Keywords:     SampleProfilerKeywords,
+			LogLevel:     LevelInformational,
+		},
+		{
+			ProviderName: ProviderRuntime,
+			Keywords:     RuntimeKeywordDefault,
+			LogLevel:     LevelInformational,
+		},
+	}
+}
+
+// GCVerboseProviders enables verbose GC events, including object allocation
+// sampling.
+func GCVerboseProviders() []ProviderConfig {
+	return []ProviderConfig{
+		{
+			ProviderName: ProviderRuntime,
+			Keywords:     RuntimeKeywordGC | RuntimeKeywordGCHandle | RuntimeKeywordException,
+			LogLevel:     LevelVerbose,
+		},
+	}
+}
+
+// GCCollectProviders enables GC collection events only, which has a very
+// low overhead.
+func GCCollectProviders() []ProviderConfig {
+	return []ProviderConfig{
+		{
+			ProviderName: ProviderRuntime,
+			Keywords:     RuntimeKeywordGC,
+			LogLevel:     LevelInformational,
+		},
+		{
+			ProviderName: ProviderRuntimePrivate,
+			Keywords:     RuntimeKeywordGC,
+			LogLevel:     LevelInformational,
+		},
+	}
+}
+
+const databaseFilterAndPayloadSpecs = "SqlClientDiagnosticListener/System.Data.SqlClient.WriteCommandBefore@Activity1Start:-" +
+	"Command;Command.CommandText;ConnectionId;Operation;Command.Connection.ServerVersion;" +
+	"Command.CommandTimeout;Command.CommandType;Command.Connection.ConnectionString;" +
+	"Command.Connection.Database;Command.Connection.DataSource;Command.Connection.PacketSize\r\n" +
+	"SqlClientDiagnosticListener/System.Data.SqlClient.WriteCommandAfter@Activity1Stop:\r\n" +
+	"Microsoft.EntityFrameworkCore/Microsoft.EntityFrameworkCore.Database.Command.CommandExecuting@Activity2Start:-" +
+	"Command;Command.CommandText;Command.Connection.DataSource;Command.Connection.Database\r\n" +
+	"Microsoft.EntityFrameworkCore/Microsoft.EntityFrameworkCore.Database.Command.CommandExecuted@Activity2Stop:"
+
+// DatabaseProviders enables ADO.NET and Entity Framework database command events.
+func DatabaseProviders() []ProviderConfig {
+	return []ProviderConfig{
+		{
+			ProviderName: ProviderTPL,
+			Keywords:     TPLKeywordTasksFlowActivityIDs,
+			LogLevel:     LevelInformational,
+		},
+		{
+			ProviderName: ProviderDiagnosticSource,
+			Keywords:     DiagnosticSourceKeywordMessages | DiagnosticSourceKeywordEvents,
+			LogLevel:     LevelVerbose,
+			FilterData:   `FilterAndPayloadSpecs="` + databaseFilterAndPayloadSpecs + `"`,
+		},
+	}
+}
+
+// HTTPProviders enables incoming and outgoing HTTP request events.
+func HTTPProviders() []ProviderConfig {
+	return []ProviderConfig{
+		{
+			ProviderName: ProviderTPL,
+			Keywords:     TPLKeywordTasksFlowActivityIDs,
+			LogLevel:     LevelInformational,
+		},
+		{
+			ProviderName: ProviderAspNetCore,
+			Keywords:     KeywordsAll,
+			LogLevel:     LevelInformational,
+		},
+		{
+			ProviderName: ProviderHTTP,
+			Keywords:     KeywordsAll,
+			LogLevel:     LevelInformational,
+		},
+		{
+			ProviderName: ProviderSockets,
+			Keywords:     KeywordsAll,
+			LogLevel:     LevelInformational,
+		},
+		{
+			ProviderName: ProviderNameResolution,
+			Keywords:     KeywordsAll,
+			LogLevel:     LevelInformational,
+		},
+	}
+}
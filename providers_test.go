@@ -0,0 +1,78 @@
+package dotnetdiag
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPresetKeywords(t *testing.T) {
+	type provider struct {
+		name     string
+		keywords uint64
+		level    uint32
+	}
+	for _, tc := range []struct {
+		preset   string
+		expected []provider
+	}{
+		{
+			// Masks of dotnet-trace cpu-sampling profile.
+			preset: PresetCPUSampling,
+			expected: []provider{
+				{ProviderSampleProfiler, 0xF00000000000, LevelInformational},
+				{ProviderRuntime, 0x14C14FCCBD, LevelInformational},
+			},
+		},
+		{
+			preset: PresetGCVerbose,
+			expected: []provider{
+				{ProviderRuntime, 0x8003, LevelVerbose},
+			},
+		},
+		{
+			preset: PresetGCCollect,
+			expected: []provider{
+				{ProviderRuntime, 0x1, LevelInformational},
+				{ProviderRuntimePrivate, 0x1, LevelInformational},
+			},
+		},
+		{
+			preset: PresetDatabase,
+			expected: []provider{
+				{ProviderTPL, 0x80, LevelInformational},
+				{ProviderDiagnosticSource, 0x3, LevelVerbose},
+			},
+		},
+		{
+			preset: PresetHTTP,
+			expected: []provider{
+				{ProviderTPL, 0x80, LevelInformational},
+				{ProviderAspNetCore, KeywordsAll, LevelInformational},
+				{ProviderHTTP, KeywordsAll, LevelInformational},
+				{ProviderSockets, KeywordsAll, LevelInformational},
+				{ProviderNameResolution, KeywordsAll, LevelInformational},
+			},
+		},
+	} {
+		configs, err := Preset(tc.preset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(configs) != len(tc.expected) {
+			t.Fatalf("%s: expected %d providers, got %d", tc.preset, len(tc.expected), len(configs))
+		}
+		for i, e := range tc.expected {
+			c := configs[i]
+			if c.ProviderName != e.name || c.Keywords != e.keywords || c.LogLevel != e.level {
+				t.Errorf("%s: expected %s:0x%X:%d, got %s:0x%X:%d", tc.preset,
+					e.name, e.keywords, e.level, c.ProviderName, c.Keywords, c.LogLevel)
+			}
+		}
+	}
+	if len(Presets()) != 5 {
+		t.Fatalf("unexpected presets: %v", Presets())
+	}
+	if _, err := Preset("unknown"); !errors.Is(err, ErrUnknownPreset) {
+		t.Fatalf("expected ErrUnknownPreset, got %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package trigger
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pyroscope-io/dotnetdiag"
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+	"github.com/pyroscope-io/dotnetdiag/nettrace/clr"
+)
+
+// TraceAction collects a trace for the specified duration.
+type TraceAction struct {
+	// Providers to enable, by default the CPU sampling preset is used.
+	Providers []dotnetdiag.ProviderConfig
+	Duration  time.Duration
+	// Output creates a new file for the trace.
+	Output func() (io.WriteCloser, error)
+}
+
+// DumpAction creates a dump of the target process.
+type DumpAction struct {
+	// Path returns the dump file path, the path is interpreted
+	// by the target process.
+	Path func() string
+	Type dotnetdiag.DumpType
+}
+
+// GCHeapTraceAction collects a trace of the GC heap snapshot: a full
+// blocking GC is induced, and the trace is completed as soon as the GC
+// finishes. The output is a NetTrace stream, not a .gcdump file: it can be
+// converted to .gcdump with PerfView.
+type GCHeapTraceAction struct {
+	// Timeout specifies the maximum session duration.
+	Timeout time.Duration
+	Output  func() (io.WriteCloser, error)
+}
+
+const (
+	defaultTraceDuration      = 30 * time.Second
+	defaultGCHeapTraceTimeout = 30 * time.Second
+)
+
+// OutputFile returns a function that creates a file named after the
+// given prefix and extension, and the current time.
+func OutputFile(prefix, ext string) func() (io.WriteCloser, error) {
+	return func() (io.WriteCloser, error) {
+		return os.Create(prefix + time.Now().UTC().Format("-20060102T150405") + ext)
+	}
+}
+
+// DumpFile returns a function that returns dump file path made of
+// the given prefix and the current time.
+func DumpFile(prefix string) func() string {
+	return func() string {
+		return prefix + time.Now().UTC().Format("-20060102T150405") + ".dmp"
+	}
+}
+
+func (a TraceAction) Run(c *dotnetdiag.Client) error {
+	providers := a.Providers
+	if len(providers) == 0 {
+		providers = dotnetdiag.CPUSamplingProviders()
+	}
+	d := a.Duration
+	if d <= 0 {
+		d = defaultTraceDuration
+	}
+	w, err := a.Output()
+	if err != nil {
+		return err
+	}
+	s, err := c.CollectTracing(dotnetdiag.CollectTracingConfig{
+		CircularBufferSizeMB: 256,
+		Providers:            providers,
+	})
+	if err != nil {
+		_ = w.Close()
+		return err
+	}
+	t := time.AfterFunc(d, func() { _ = s.Close() })
+	defer t.Stop()
+	_, err = io.Copy(w, s)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (a DumpAction) Run(c *dotnetdiag.Client) error {
+	t := a.Type
+	if t == 0 {
+		t = dotnetdiag.DumpTypeFull
+	}
+	return c.CreateCoreDump(a.Path(), t)
+}
+
+// GCStart reason of a GC induced by the diagnostics session.
+const gcReasonInduced = 1
+
+var errGCNotFinished = errors.New("induced GC has not finished within the timeout")
+
+func (a GCHeapTraceAction) Run(c *dotnetdiag.Client) error {
+	d := a.Timeout
+	if d <= 0 {
+		d = defaultGCHeapTraceTimeout
+	}
+	w, err := a.Output()
+	if err != nil {
+		return err
+	}
+	s, err := c.CollectTracing(dotnetdiag.CollectTracingConfig{
+		CircularBufferSizeMB: 256,
+		Providers: []dotnetdiag.ProviderConfig{
+			{
+				ProviderName: dotnetdiag.ProviderRuntime,
+				Keywords:     dotnetdiag.RuntimeKeywordGCHeapSnapshot,
+				LogLevel:     dotnetdiag.LevelVerbose,
+			},
+		},
+	})
+	if err != nil {
+		_ = w.Close()
+		return err
+	}
+	t := time.AfterFunc(d, func() { _ = s.Close() })
+	defer t.Stop()
+	finished, err := waitInducedGC(io.TeeReader(s, w), func() {
+		if t.Stop() {
+			_ = s.Close()
+		}
+	})
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && !finished {
+		err = errGCNotFinished
+	}
+	return err
+}
+
+// waitInducedGC reads the stream to the end, and calls done when
+// the induced GC completes. The call reports whether the GC has finished.
+func waitInducedGC(r io.Reader, done func()) (bool, error) {
+	stream := nettrace.NewStream(r)
+	trace, err := stream.Open()
+	if err != nil {
+		return false, err
+	}
+	md := make(map[int32]*nettrace.Metadata)
+	stream.MetadataHandler = func(m *nettrace.Metadata) error {
+		md[m.Header.MetaDataID] = m
+		return nil
+	}
+	var count uint32
+	var induced, finished bool
+	start := func(c, reason uint32) {
+		if !induced {
+			induced = reason == gcReasonInduced
+			count = c
+		}
+	}
+	end := func(c uint32) {
+		if induced && c == count {
+			finished = true
+			done()
+		}
+	}
+	stream.EventHandler = func(e *nettrace.Blob) error {
+		m, ok := md[e.Header.MetadataID]
+		if finished || !ok || m.Header.ProviderName != clr.RuntimeProvider {
+			return nil
+		}
+		if id := m.Header.EventID; id != clr.RuntimeGCStart && id != clr.RuntimeGCEnd {
+			return nil
+		}
+		v, _, err := clr.Parse(trace, m, e)
+		if err != nil {
+			return fmt.Errorf("GC event %d: %w", m.Header.EventID, err)
+		}
+		switch x := v.(type) {
+		case *clr.GCStart:
+			start(x.Count, x.Reason)
+		case *clr.GCStartV1:
+			start(x.Count, x.Reason)
+		case *clr.GCStartV2:
+			start(x.Count, x.Reason)
+		case *clr.GCEnd:
+			end(x.Count)
+		case *clr.GCEndV1:
+			end(x.Count)
+		}
+		return nil
+	}
+	for {
+		err := stream.Next()
+		switch {
+		case err == nil:
+		case errors.Is(err, io.EOF):
+			return finished, nil
+		default:
+			return finished, err
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package trigger
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCondition = errors.New("invalid condition")
+
+// Condition describes a counter threshold that must be exceeded for
+// the specified duration.
+type Condition struct {
+	// Provider of the counter, by default System.Runtime.
+	Provider string
+	// Counter name, e.g. "cpu-usage" or "exception-count".
+	Counter  string
+	Operator Operator
+	// Threshold is compared with the counter value: the mean value for
+	// polling counters, or the rate per second for incrementing counters.
+	Threshold float64
+	// For specifies how long the condition must hold before the rule fires.
+	// If zero, the rule fires on the first matching sample.
+	For time.Duration
+}
+
+type Operator string
+
+const (
+	GreaterThan        Operator = ">"
+	GreaterThanOrEqual Operator = ">="
+	LessThan           Operator = "<"
+	LessThanOrEqual    Operator = "<="
+)
+
+func (o Operator) compare(v, threshold float64) bool {
+	switch o {
+	case GreaterThan:
+		return v > threshold
+	case GreaterThanOrEqual:
+		return v >= threshold
+	case LessThan:
+		return v < threshold
+	case LessThanOrEqual:
+		return v <= threshold
+	default:
+		return false
+	}
+}
+
+// ParseCondition parses condition string in the following format:
+//
+//	[provider/]counter operator threshold[unit] [for duration]
+//
+// For example: "cpu-usage > 80% for 30s", "exception-count > 100/s".
+// The unit suffix ('%' or '/s') is optional and only serves readability.
+func ParseCondition(s string) (Condition, error) {
+	var c Condition
+	f := strings.Fields(s)
+	if !(len(f) == 3 || (len(f) == 5 && f[3] == "for")) {
+		return c, fmt.Errorf("%w: %q", ErrInvalidCondition, s)
+	}
+	if i := strings.LastIndex(f[0], "/"); i > 0 {
+		c.Provider, c.Counter = f[0][:i], f[0][i+1:]
+	} else {
+		c.Counter = f[0]
+	}
+	c.Operator = Operator(f[1])
+	switch c.Operator {
+	case GreaterThan, GreaterThanOrEqual, LessThan, LessThanOrEqual:
+	default:
+		return c, fmt.Errorf("%w: unknown operator %q", ErrInvalidCondition, f[1])
+	}
+	var err error
+	t := strings.TrimSuffix(strings.TrimSuffix(f[2], "%"), "/s")
+	if c.Threshold, err = strconv.ParseFloat(t, 64); err != nil {
+		return c, fmt.Errorf("%w: invalid threshold %q", ErrInvalidCondition, f[2])
+	}
+	if len(f) == 5 {
+		if c.For, err = time.ParseDuration(f[4]); err != nil {
+			return c, fmt.Errorf("%w: invalid duration %q", ErrInvalidCondition, f[4])
+		}
+	}
+	return c, nil
+}
+
+func (c Condition) String() string {
+	s := c.Counter
+	if c.Provider != "" {
+		s = c.Provider + "/" + s
+	}
+	s = fmt.Sprintf("%s %s %g", s, c.Operator, c.Threshold)
+	if c.For > 0 {
+		s += " for " + c.For.String()
+	}
+	return s
+}
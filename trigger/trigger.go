@@ -0,0 +1,248 @@
+// Package trigger implements conditional collection of diagnostic
+// artifacts: an EventCounters session watches the target process, and a
+// collection action (a trace, a dump, etc.) fires when a rule matches.
+package trigger
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/pyroscope-io/dotnetdiag"
+	"github.com/pyroscope-io/dotnetdiag/counters"
+)
+
+// Rule fires the action when the condition is met.
+type Rule struct {
+	Name      string
+	Condition Condition
+	Action    Action
+	// Cooldown specifies the minimal interval between two consecutive
+	// actions of the rule.
+	Cooldown time.Duration
+}
+
+// Action collects a diagnostic artifact from the target process.
+type Action interface {
+	Run(*dotnetdiag.Client) error
+}
+
+// ActionFunc is an adapter to allow the use of ordinary functions as actions.
+type ActionFunc func(*dotnetdiag.Client) error
+
+func (f ActionFunc) Run(c *dotnetdiag.Client) error { return f(c) }
+
+// Event describes an action fired.
+type Event struct {
+	Rule    string
+	Counter counters.Counter
+	// Err is the action error. ErrActionLimitExceeded indicates that the
+	// action has not been executed because of the hourly limit.
+	Err error
+}
+
+var ErrActionLimitExceeded = errors.New("actions per hour limit exceeded")
+
+// Engine evaluates rules against counters of the target process.
+type Engine struct {
+	client   *dotnetdiag.Client
+	rules    []*ruleState
+	interval time.Duration
+	maxRate  int
+	notify   func(Event)
+	now      func() time.Time
+
+	m       sync.Mutex
+	reader  *counters.Reader
+	closed  bool
+	actions []time.Time
+	wg      sync.WaitGroup
+}
+
+type ruleState struct {
+	Rule
+	provider string
+	since    time.Time
+	last     time.Time
+	running  bool
+}
+
+// Option overrides default Engine parameters.
+type Option func(*Engine)
+
+// WithInterval specifies counters publishing interval.
+func WithInterval(d time.Duration) Option {
+	return func(e *Engine) {
+		e.interval = d
+	}
+}
+
+// WithMaxActionsPerHour limits the number of actions all the rules can
+// fire within an hour. By default, the limit is 10.
+func WithMaxActionsPerHour(n int) Option {
+	return func(e *Engine) {
+		e.maxRate = n
+	}
+}
+
+// WithNotify specifies a function to be called when an action completes.
+// The function may be called concurrently.
+func WithNotify(fn func(Event)) Option {
+	return func(e *Engine) {
+		e.notify = fn
+	}
+}
+
+const defaultMaxActionsPerHour = 10
+
+// NewEngine creates a new Engine for the given client and rules.
+func NewEngine(c *dotnetdiag.Client, rules []Rule, options ...Option) *Engine {
+	e := Engine{
+		client:   c,
+		interval: time.Second,
+		maxRate:  defaultMaxActionsPerHour,
+		notify:   func(Event) {},
+		now:      time.Now,
+	}
+	for _, option := range options {
+		option(&e)
+	}
+	for _, r := range rules {
+		s := ruleState{Rule: r, provider: r.Condition.Provider}
+		if s.provider == "" {
+			s.provider = dotnetdiag.ProviderSystemRuntime
+		}
+		e.rules = append(e.rules, &s)
+	}
+	return &e
+}
+
+// Run starts EventCounters session and evaluates rules until the engine
+// is closed. The call blocks until all the actions in progress complete.
+func (e *Engine) Run() error {
+	r, err := counters.NewReader(e.client, counters.Config{
+		Providers: e.providers(),
+		Interval:  e.interval,
+	})
+	if err != nil {
+		return err
+	}
+	e.m.Lock()
+	e.reader = r
+	closed := e.closed
+	e.m.Unlock()
+	if closed {
+		_ = r.Close()
+	}
+	defer e.wg.Wait()
+	for {
+		c, err := r.Next()
+		if err != nil {
+			if e.isClosed() {
+				return nil
+			}
+			_ = r.Close()
+			return err
+		}
+		for _, s := range e.observe(c) {
+			e.run(s, c)
+		}
+	}
+}
+
+// Close stops the engine.
+func (e *Engine) Close() error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	if e.reader != nil {
+		return e.reader.Close()
+	}
+	return nil
+}
+
+func (e *Engine) isClosed() bool {
+	e.m.Lock()
+	defer e.m.Unlock()
+	return e.closed
+}
+
+func (e *Engine) providers() []string {
+	seen := make(map[string]struct{})
+	var providers []string
+	for _, r := range e.rules {
+		if _, ok := seen[r.provider]; !ok {
+			seen[r.provider] = struct{}{}
+			providers = append(providers, r.provider)
+		}
+	}
+	return providers
+}
+
+// observe evaluates the counter sample against the rules and returns
+// the ones to be fired. The cooldown starts once the action is run.
+func (e *Engine) observe(c counters.Counter) []*ruleState {
+	e.m.Lock()
+	defer e.m.Unlock()
+	now := e.now()
+	var fire []*ruleState
+	for _, r := range e.rules {
+		if r.provider != c.Provider || r.Condition.Counter != c.Name {
+			continue
+		}
+		if !r.Condition.Operator.compare(c.Value, r.Condition.Threshold) {
+			r.since = time.Time{}
+			continue
+		}
+		if r.since.IsZero() {
+			r.since = now
+		}
+		if now.Sub(r.since) < r.Condition.For || r.running {
+			continue
+		}
+		if !r.last.IsZero() && now.Sub(r.last) < r.Cooldown {
+			continue
+		}
+		r.since = time.Time{}
+		fire = append(fire, r)
+	}
+	return fire
+}
+
+// acquire reports whether an action can be fired, given the hourly limit.
+func (e *Engine) acquire() bool {
+	e.m.Lock()
+	defer e.m.Unlock()
+	now := e.now()
+	for len(e.actions) > 0 && now.Sub(e.actions[0]) >= time.Hour {
+		e.actions = e.actions[1:]
+	}
+	if len(e.actions) >= e.maxRate {
+		return false
+	}
+	e.actions = append(e.actions, now)
+	return true
+}
+
+func (e *Engine) run(r *ruleState, c counters.Counter) {
+	if !e.acquire() {
+		e.notify(Event{Rule: r.Name, Counter: c, Err: ErrActionLimitExceeded})
+		return
+	}
+	e.m.Lock()
+	r.running = true
+	r.last = e.now()
+	e.m.Unlock()
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		err := r.Action.Run(e.client)
+		e.m.Lock()
+		r.running = false
+		e.m.Unlock()
+		e.notify(Event{Rule: r.Name, Counter: c, Err: err})
+	}()
+}
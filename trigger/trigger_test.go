@@ -0,0 +1,159 @@
+package trigger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/pyroscope-io/dotnetdiag"
+	"github.com/pyroscope-io/dotnetdiag/counters"
+	"github.com/pyroscope-io/dotnetdiag/nettrace"
+	"github.com/pyroscope-io/dotnetdiag/nettrace/clr"
+)
+
+func TestParseCondition(t *testing.T) {
+	c, err := ParseCondition("cpu-usage > 80% for 30s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Condition{Counter: "cpu-usage", Operator: GreaterThan, Threshold: 80, For: 30 * time.Second}
+	if c != expected {
+		t.Fatalf("expected %v, got %v", expected, c)
+	}
+	c, err = ParseCondition("Custom.Source/requests <= 100/s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = Condition{Provider: "Custom.Source", Counter: "requests", Operator: LessThanOrEqual, Threshold: 100}
+	if c != expected {
+		t.Fatalf("expected %v, got %v", expected, c)
+	}
+	for _, s := range []string{"cpu-usage", "cpu-usage ~ 80", "cpu-usage > x", "cpu-usage > 80 for x"} {
+		if _, err = ParseCondition(s); err == nil {
+			t.Fatalf("%s: expected error", s)
+		}
+	}
+}
+
+func TestEngineObserve(t *testing.T) {
+	var now time.Time
+	var events []Event
+	e := NewEngine(nil, []Rule{
+		{
+			Name:      "cpu",
+			Condition: Condition{Counter: "cpu-usage", Operator: GreaterThan, Threshold: 80, For: 2 * time.Second},
+			Action:    ActionFunc(func(*dotnetdiag.Client) error { return nil }),
+			Cooldown:  time.Minute,
+		},
+	}, WithMaxActionsPerHour(2), WithNotify(func(e Event) { events = append(events, e) }))
+	e.now = func() time.Time { return now }
+
+	sample := func(v float64) int {
+		now = now.Add(time.Second)
+		c := counters.Counter{Provider: "System.Runtime", Name: "cpu-usage", Value: v}
+		fire := e.observe(c)
+		for _, r := range fire {
+			e.run(r, c)
+		}
+		e.wg.Wait()
+		return len(fire)
+	}
+
+	for i, x := range []struct {
+		value    float64
+		expected int
+	}{
+		{90, 0}, {90, 0}, {50, 0}, // Condition is interrupted.
+		{90, 0}, {90, 0}, {90, 1}, // Holds for 2s.
+		{90, 0}, {90, 0}, {90, 0}, // Cooldown.
+	} {
+		if n := sample(x.value); n != x.expected {
+			t.Fatalf("sample %d: expected %d rules to fire, got %d", i, x.expected, n)
+		}
+	}
+
+	now = now.Add(time.Minute)
+	if n := sample(90); n != 1 {
+		t.Fatalf("expected rule to fire after cooldown, got %d", n)
+	}
+	if len(events) != 2 || events[0].Err != nil || events[1].Err != nil {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+
+	if e.acquire() {
+		t.Fatal("expected the hourly limit to apply")
+	}
+	now = now.Add(time.Hour)
+	if !e.acquire() {
+		t.Fatal("expected the hourly limit to reset")
+	}
+}
+
+func TestEngineLimitDoesNotStartCooldown(t *testing.T) {
+	var now time.Time
+	var events []Event
+	e := NewEngine(nil, []Rule{
+		{
+			Name:      "cpu",
+			Condition: Condition{Counter: "cpu-usage", Operator: GreaterThan, Threshold: 80},
+			Action:    ActionFunc(func(*dotnetdiag.Client) error { return nil }),
+			Cooldown:  time.Minute,
+		},
+	}, WithMaxActionsPerHour(1), WithNotify(func(e Event) { events = append(events, e) }))
+	e.now = func() time.Time { return now }
+	sample := func() {
+		c := counters.Counter{Provider: "System.Runtime", Name: "cpu-usage", Value: 90}
+		for _, r := range e.observe(c) {
+			e.run(r, c)
+		}
+		e.wg.Wait()
+	}
+
+	sample()
+	// The action is refused because of the hourly limit.
+	now = now.Add(time.Hour - 10*time.Second)
+	sample()
+	// The limit is reset, and the refused action did not start the cooldown.
+	now = now.Add(10 * time.Second)
+	sample()
+	if len(events) != 3 || events[0].Err != nil || events[1].Err != ErrActionLimitExceeded || events[2].Err != nil {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+}
+
+func TestWaitInducedGC(t *testing.T) {
+	b := nettrace.NewBuilder()
+	start := b.Metadata(clr.RuntimeProvider, clr.RuntimeGCStart, "GCStart")
+	end := b.Metadata(clr.RuntimeProvider, clr.RuntimeGCEnd, "GCEnd")
+	payload := func(v interface{}) []byte {
+		var buf bytes.Buffer
+		_ = binary.Write(&buf, binary.LittleEndian, v)
+		return buf.Bytes()
+	}
+	for _, e := range []struct {
+		id      int32
+		payload interface{}
+	}{
+		{start, clr.GCStart{Count: 1, Reason: 0}},
+		{end, clr.GCEnd{Count: 1}},
+		{start, clr.GCStart{Count: 2, Reason: gcReasonInduced}},
+		{start, clr.GCStart{Count: 3, Reason: 0}},
+		{end, clr.GCEnd{Count: 3}},
+		{end, clr.GCEnd{Count: 2}},
+	} {
+		b.Event(nettrace.BlobHeader{MetadataID: e.id, ThreadID: 1}, payload(e.payload))
+	}
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var calls int
+	finished, err := waitInducedGC(&buf, func() { calls++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !finished || calls != 1 {
+		t.Fatalf("expected the induced GC to finish once, finished: %v, calls: %d", finished, calls)
+	}
+}